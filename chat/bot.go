@@ -0,0 +1,327 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+// retryWait is how long the bot waits after failing to receive
+// messages before trying again.
+const retryWait = 5 * time.Second
+
+// BotConfig is how the bot listens. Timeout is how long each
+// long poll for messages waits. Completed, if set, is called
+// with every assignment the bot marks as done.
+type BotConfig struct {
+	Timeout   time.Duration
+	Completed func(assignment *models.Assignment)
+}
+
+// Bot answers commands sent to it from linked chats, acting for
+// the user whoever sent the command linked themselves to, so in
+// a group everyone only ever acts for themselves:
+//
+//	/link <code>  links the chat to whoever got the code
+//	/done <job>   marks the user's unfinished <job> as done
+//	/whois <job>  says who has <job> this week
+//
+// A job is named by its name or any part of it, in any case.
+type Bot struct {
+	service Service
+	links   models.ChatLinkService
+	as      models.AssignmentService
+	hs      models.HouseholdService
+	tfs     models.TwoFactorService
+	cfg     BotConfig
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+func NewBot(service Service, links models.ChatLinkService, as models.AssignmentService, hs models.HouseholdService, tfs models.TwoFactorService, cfg BotConfig) *Bot {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	return &Bot{
+		service: service,
+		links:   links,
+		as:      as,
+		hs:      hs,
+		tfs:     tfs,
+		cfg:     cfg,
+		stopped: make(chan struct{}),
+	}
+}
+
+// Start listens for commands in the background until Stop is
+// called.
+func (b *Bot) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	go b.run(ctx)
+}
+
+// Stop stops listening, waiting for the command being answered,
+// if any.
+func (b *Bot) Stop() {
+	b.cancel()
+	<-b.stopped
+}
+
+func (b *Bot) run(ctx context.Context) {
+	defer close(b.stopped)
+	for {
+		messages, err := b.service.Receive(ctx, b.cfg.Timeout)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryWait):
+			}
+			continue
+		}
+		for _, m := range messages {
+			reply := b.Answer(m)
+			if reply == "" {
+				continue
+			}
+			if err := b.service.Send(m.ChatID, reply); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// Answer runs the command in the message and returns the reply,
+// which is empty for messages that aren't commands.
+func (b *Bot) Answer(m Message) string {
+	command, arg := parseCommand(m.Text)
+	var reply string
+	var err error
+	switch command {
+	case "":
+		return ""
+	case "link":
+		reply, err = b.link(m, arg)
+	case "done":
+		reply, err = b.withUser(m.SenderID, arg, b.done)
+	case "whois":
+		reply, err = b.withUser(m.SenderID, arg, b.whois)
+	default:
+		reply = helpText
+	}
+	if err != nil {
+		log.Println(err)
+		return "Something went wrong, sorry. Please try again later."
+	}
+	return reply
+}
+
+const helpText = `Here is what I can do:
+
+/done <job> marks your <job> as done
+/whois <job> tells you who has <job> this week
+/link <code> links this chat to your account, with the code from your settings`
+
+// parseCommand splits a message like "/done@bot kitchen floor"
+// into "done" and "kitchen floor". Messages that don't start
+// with a slash aren't commands.
+func parseCommand(text string) (command, arg string) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return "", ""
+	}
+	fields := strings.SplitN(text[1:], " ", 2)
+	command = strings.ToLower(fields[0])
+	if i := strings.Index(command, "@"); i >= 0 {
+		command = command[:i]
+	}
+	if len(fields) > 1 {
+		arg = strings.TrimSpace(fields[1])
+	}
+	return command, arg
+}
+
+func (b *Bot) link(m Message, code string) (string, error) {
+	if code == "" {
+		return "Send /link followed by the code from your chat settings on the site.", nil
+	}
+	switch _, err := b.links.Link(code, m.ChatID, m.SenderID); err {
+	case nil:
+		return "Done! I'll send your reminders here. Send /help to see what else I can do.", nil
+	case models.ErrTokenInvalid:
+		return "That code doesn't work, it may have expired. Please get a new one from your chat settings.", nil
+	default:
+		return "", err
+	}
+}
+
+// withUser runs fn for the user the sender linked themselves
+// to, once they have said which job.
+func (b *Bot) withUser(senderID, job string, fn func(userID uint, job string) (string, error)) (string, error) {
+	link, err := b.links.BySenderID(senderID)
+	switch err {
+	case nil:
+	case models.ErrNotFound:
+		return "I don't know who you are yet. Get a code from your chat settings on the site and send /link <code>.", nil
+	default:
+		return "", err
+	}
+	if job == "" {
+		return "Which job? Send it after the command, like /done kitchen.", nil
+	}
+	return fn(link.UserID, job)
+}
+
+// done marks the user's unfinished assignment of the job as
+// done, the oldest first if there are a few.
+func (b *Bot) done(userID uint, job string) (string, error) {
+	assignments, err := b.as.ByUserID(userID)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	var unfinished []models.Assignment
+	for _, a := range assignments {
+		if a.Swappable() && (a.WeekStart == nil || !a.WeekStart.After(now)) {
+			unfinished = append(unfinished, a)
+		}
+	}
+	matches, ambiguous := matchJobs(unfinished, job)
+	if len(matches) == 0 {
+		return fmt.Sprintf("You have nothing called %q left to do.", job), nil
+	}
+	if ambiguous != "" {
+		return fmt.Sprintf("Which one did you mean? %s", ambiguous), nil
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return weekBefore(matches[i].WeekStart, matches[j].WeekStart)
+	})
+	assignment := matches[0]
+	membership, err := b.hs.Membership(assignment.HouseholdID, userID)
+	switch err {
+	case nil:
+	case models.ErrNotFound:
+		return "You're not in that household anymore.", nil
+	default:
+		return "", err
+	}
+	household, err := b.hs.ByID(assignment.HouseholdID)
+	if err != nil {
+		return "", err
+	}
+	allowed, err := b.tfs.Allowed(household, userID)
+	if err != nil {
+		return "", err
+	}
+	if !allowed {
+		return household.Name + " requires two-factor authentication. Please turn it on on the site first.", nil
+	}
+	if !membership.CanSetStatus(&assignment, models.StatusDone) {
+		return "You're not allowed to finish that one.", nil
+	}
+	if err := b.as.SetStatus(&assignment, models.StatusDone, userID); err != nil {
+		if perr, ok := err.(views.PublicError); ok {
+			return perr.Public(), nil
+		}
+		return "", err
+	}
+	if b.cfg.Completed != nil {
+		b.cfg.Completed(&assignment)
+	}
+	return fmt.Sprintf("Marked %s for %s as done. Thanks!", assignment.Job.Name, weekText(assignment.WeekStart)), nil
+}
+
+// whois lists who has the job this week in each of the user's
+// households, leaving out those that require two-factor
+// authentication the user hasn't turned on.
+func (b *Bot) whois(userID uint, job string) (string, error) {
+	households, err := b.hs.ByUserID(userID)
+	if err != nil {
+		return "", err
+	}
+	week := models.WeekOf(time.Now())
+	var lines []string
+	for _, h := range households {
+		allowed, err := b.tfs.Allowed(&h, userID)
+		if err != nil {
+			return "", err
+		}
+		if !allowed {
+			continue
+		}
+		assignments, err := b.as.ByWeek(h.ID, week)
+		if err != nil {
+			return "", err
+		}
+		matches, _ := matchJobs(assignments, job)
+		for _, a := range matches {
+			line := fmt.Sprintf("%s: %s (%s)", a.Job.Name, a.User.Name, a.Status)
+			if len(households) > 1 {
+				line = fmt.Sprintf("%s in %s: %s (%s)", a.Job.Name, h.Name, a.User.Name, a.Status)
+			}
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return fmt.Sprintf("Nobody has anything called %q this week.", job), nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// matchJobs returns the assignments whose job is called job. If
+// none is, it returns the ones whose job has job anywhere in its
+// name instead, and if those are for different jobs their names
+// in ambiguous.
+func matchJobs(assignments []models.Assignment, job string) (matches []models.Assignment, ambiguous string) {
+	job = strings.ToLower(strings.TrimSpace(job))
+	var partial []models.Assignment
+	names := map[string]bool{}
+	for _, a := range assignments {
+		name := strings.ToLower(a.Job.Name)
+		switch {
+		case name == job:
+			matches = append(matches, a)
+		case strings.Contains(name, job):
+			partial = append(partial, a)
+			names[a.Job.Name] = true
+		}
+	}
+	if len(matches) > 0 {
+		return matches, ""
+	}
+	if len(names) > 1 {
+		list := make([]string, 0, len(names))
+		for name := range names {
+			list = append(list, name)
+		}
+		sort.Strings(list)
+		ambiguous = strings.Join(list, ", ")
+	}
+	return partial, ambiguous
+}
+
+// weekBefore orders assignments in no particular week last.
+func weekBefore(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return b == nil && a != nil
+	}
+	return a.Before(*b)
+}
+
+func weekText(week *time.Time) string {
+	if week == nil {
+		return "no particular week"
+	}
+	return "the week of " + week.Format(views.DateLayout)
+}
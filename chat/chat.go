@@ -0,0 +1,94 @@
+// Package chat sends reminders and digests to the chats people
+// linked to their account, and runs the bot that answers
+// commands sent from those chats.
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirodoht/heartfort/email"
+)
+
+// Message is a text sent to the bot. ChatID is what the chat
+// service calls the chat it was sent in, and the chat replies
+// go to. SenderID is what it calls whoever sent it, which in a
+// group is not the same as the chat.
+type Message struct {
+	ChatID   string
+	SenderID string
+	Text     string
+}
+
+// Service is a chat service the bot is on, like Telegram or
+// Matrix.
+type Service interface {
+	// Send posts text to the chat.
+	Send(chatID, text string) error
+	// Receive waits up to timeout for messages sent to the bot
+	// since the last call, or until ctx is done. It is only ever
+	// called from one goroutine at a time.
+	Receive(ctx context.Context, timeout time.Duration) ([]Message, error)
+}
+
+// Notifier sends the reminder and digest events that also go
+// out by email to a chat. Every method takes the chat first.
+type Notifier interface {
+	Reminder(chatID, name, jobName, dueDate string, assignmentID uint) error
+	Overdue(chatID, name, jobName, week string, assignmentID uint) error
+	Digest(chatID, householdName, week string, entries []email.DigestEntry) error
+}
+
+var _ Notifier = &Client{}
+
+// NewClient returns a Notifier that sends on service, with
+// links to the site at baseURL.
+func NewClient(service Service, baseURL string) *Client {
+	return &Client{
+		service: service,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// Client writes the events out as chat messages, which are
+// shorter than the emails and point at the bot's commands.
+type Client struct {
+	service Service
+	baseURL string
+}
+
+// Reminder tells the assignee that their assignment is due by
+// the end of dueDate.
+func (c *Client) Reminder(chatID, name, jobName, dueDate string, assignmentID uint) error {
+	return c.service.Send(chatID, fmt.Sprintf(
+		"Hi %s! Just a reminder that %s is yours this week and needs doing by the end of %s.\n\n"+
+			"Once it's done, send /done %s or tick it off here:\n%s",
+		name, jobName, dueDate, strings.ToLower(jobName), c.assignmentURL(assignmentID)))
+}
+
+// Overdue nags the assignee about an assignment whose week is
+// over but which is still not finished.
+func (c *Client) Overdue(chatID, name, jobName, week string, assignmentID uint) error {
+	return c.service.Send(chatID, fmt.Sprintf(
+		"Hi %s! %s for the week of %s still hasn't been done. "+
+			"Please get it done as soon as you can, or skip it if it can't happen.\n\n%s",
+		name, jobName, week, c.assignmentURL(assignmentID)))
+}
+
+// Digest sends the weekly digest of who is doing what in the
+// household during the week starting on week.
+func (c *Client) Digest(chatID, householdName, week string, entries []email.DigestEntry) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Here is who is doing what in %s for the week of %s:\n", householdName, week)
+	for _, e := range entries {
+		fmt.Fprintf(&b, "\n- %s: %s", e.Job, e.Assignee)
+	}
+	fmt.Fprintf(&b, "\n\nYou can see the whole rota here:\n%s/assignments", c.baseURL)
+	return c.service.Send(chatID, b.String())
+}
+
+func (c *Client) assignmentURL(id uint) string {
+	return fmt.Sprintf("%s/assignments/%d", c.baseURL, id)
+}
@@ -0,0 +1,166 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var _ Service = &Matrix{}
+
+// NewMatrix returns a Service that talks to the Matrix
+// homeserver at endpoint through its client-server API, as the
+// user userID with the access token. Chats are rooms, and the
+// bot joins any room it is invited to.
+func NewMatrix(endpoint, token, userID string) *Matrix {
+	return &Matrix{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		token:    token,
+		userID:   userID,
+		client:   &http.Client{},
+		txnBase:  strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+}
+
+// Matrix gets messages by long polling sync. Since is where the
+// last sync got to, and is empty until the first one, which
+// skips whatever was said before the bot started.
+type Matrix struct {
+	// txn is first so it is 64-bit aligned for atomic.
+	txn      uint64
+	endpoint string
+	token    string
+	userID   string
+	client   *http.Client
+	since    string
+	// Every message needs a transaction ID of its own, which is
+	// txnBase followed by the txn counter.
+	txnBase string
+}
+
+type matrixError struct {
+	ErrCode string `json:"errcode"`
+	Error   string `json:"error"`
+}
+
+type matrixSync struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []matrixEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]json.RawMessage `json:"invite"`
+	} `json:"rooms"`
+}
+
+type matrixEvent struct {
+	Type    string `json:"type"`
+	Sender  string `json:"sender"`
+	Content struct {
+		MsgType string `json:"msgtype"`
+		Body    string `json:"body"`
+	} `json:"content"`
+}
+
+func (m *Matrix) Send(chatID, text string) error {
+	body, err := json.Marshal(map[string]string{
+		"msgtype": "m.text",
+		"body":    text,
+	})
+	if err != nil {
+		return err
+	}
+	txn := fmt.Sprintf("%s.%d", m.txnBase, atomic.AddUint64(&m.txn, 1))
+	path := fmt.Sprintf("/rooms/%s/send/m.room.message/%s", url.PathEscape(chatID), txn)
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	return m.call(ctx, http.MethodPut, path, nil, body, nil)
+}
+
+func (m *Matrix) Receive(ctx context.Context, timeout time.Duration) ([]Message, error) {
+	query := url.Values{}
+	if m.since == "" {
+		query.Set("timeout", "0")
+	} else {
+		query.Set("since", m.since)
+		query.Set("timeout", strconv.Itoa(int(timeout/time.Millisecond)))
+	}
+	var sync matrixSync
+	if err := m.call(ctx, http.MethodGet, "/sync", query, nil, &sync); err != nil {
+		return nil, err
+	}
+	first := m.since == ""
+	m.since = sync.NextBatch
+	// An invite only shows up in one sync, so failing to join
+	// must not lose the messages that came with it.
+	for roomID := range sync.Rooms.Invite {
+		path := fmt.Sprintf("/join/%s", url.PathEscape(roomID))
+		if err := m.call(ctx, http.MethodPost, path, nil, []byte("{}"), nil); err != nil {
+			log.Println(err)
+		}
+	}
+	if first {
+		return nil, nil
+	}
+	var messages []Message
+	for roomID, room := range sync.Rooms.Join {
+		for _, e := range room.Timeline.Events {
+			if e.Type != "m.room.message" || e.Content.MsgType != "m.text" || e.Sender == m.userID {
+				continue
+			}
+			messages = append(messages, Message{
+				ChatID:   roomID,
+				SenderID: e.Sender,
+				Text:     e.Content.Body,
+			})
+		}
+	}
+	return messages, nil
+}
+
+// call calls the API at path and decodes the response into
+// result, if it isn't nil.
+func (m *Matrix) call(ctx context.Context, method, path string, query url.Values, body []byte, result interface{}) error {
+	u := m.endpoint + "/_matrix/client/v3" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+m.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := m.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("matrix: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var merr matrixError
+		if err := json.NewDecoder(res.Body).Decode(&merr); err != nil || merr.ErrCode == "" {
+			return fmt.Errorf("matrix: %s %s: %s", method, path, res.Status)
+		}
+		return fmt.Errorf("matrix: %s %s: %s: %s", method, path, merr.ErrCode, merr.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
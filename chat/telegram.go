@@ -0,0 +1,151 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TelegramEndpoint is where the Telegram Bot API is served.
+const TelegramEndpoint = "https://api.telegram.org"
+
+// sendTimeout is how long sending one message may take.
+const sendTimeout = 10 * time.Second
+
+var _ Service = &Telegram{}
+
+// NewTelegram returns a Service that talks to the Telegram Bot
+// API, or anything compatible with it, at endpoint as the bot
+// with the token. An empty endpoint is TelegramEndpoint.
+func NewTelegram(endpoint, token string) *Telegram {
+	if endpoint == "" {
+		endpoint = TelegramEndpoint
+	}
+	return &Telegram{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		token:    token,
+		client:   &http.Client{},
+	}
+}
+
+// Telegram gets messages by long polling getUpdates. Offset is
+// the ID of the first update it hasn't seen.
+type Telegram struct {
+	endpoint string
+	token    string
+	client   *http.Client
+	offset   int64
+}
+
+type telegramResponse struct {
+	OK          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		From *struct {
+			ID int64 `json:"id"`
+		} `json:"from"`
+		Text string `json:"text"`
+	} `json:"message"`
+}
+
+func (t *Telegram) Send(chatID, text string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"chat_id":                  chatID,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	return t.call(ctx, http.MethodPost, "sendMessage", nil, body, nil)
+}
+
+func (t *Telegram) Receive(ctx context.Context, timeout time.Duration) ([]Message, error) {
+	query := url.Values{
+		"offset":          {strconv.FormatInt(t.offset, 10)},
+		"timeout":         {strconv.Itoa(int(timeout / time.Second))},
+		"allowed_updates": {`["message"]`},
+	}
+	var updates []telegramUpdate
+	if err := t.call(ctx, http.MethodGet, "getUpdates", query, nil, &updates); err != nil {
+		return nil, err
+	}
+	var messages []Message
+	for _, u := range updates {
+		if u.UpdateID >= t.offset {
+			t.offset = u.UpdateID + 1
+		}
+		// Messages posted on behalf of a channel have nobody to
+		// answer for them.
+		if u.Message == nil || u.Message.Text == "" || u.Message.From == nil {
+			continue
+		}
+		messages = append(messages, Message{
+			ChatID:   strconv.FormatInt(u.Message.Chat.ID, 10),
+			SenderID: strconv.FormatInt(u.Message.From.ID, 10),
+			Text:     u.Message.Text,
+		})
+	}
+	return messages, nil
+}
+
+// call calls the API method and decodes its result into
+// result, if it isn't nil.
+func (t *Telegram) call(ctx context.Context, httpMethod, method string, query url.Values, body []byte, result interface{}) error {
+	u := fmt.Sprintf("%s/bot%s/%s", t.endpoint, t.token, method)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(httpMethod, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := t.client.Do(req)
+	if err != nil {
+		// The error has the URL in it, and so the token.
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("telegram: %s failed: %v", method, unwrapURLError(err))
+	}
+	defer res.Body.Close()
+	var tr telegramResponse
+	if err := json.NewDecoder(res.Body).Decode(&tr); err != nil {
+		return fmt.Errorf("telegram: %s: %s", method, res.Status)
+	}
+	if !tr.OK {
+		return fmt.Errorf("telegram: %s: %s", method, tr.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(tr.Result, result)
+}
+
+// unwrapURLError drops the request URL from err.
+func unwrapURLError(err error) error {
+	if uerr, ok := err.(*url.Error); ok {
+		return uerr.Err
+	}
+	return err
+}
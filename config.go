@@ -3,17 +3,38 @@ package main
 import (
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
 
 type Config struct {
-	Port     int
-	Env      string
-	Pepper   string
-	HMACKey  string
-	Database PostgresConfig
-	Mailgun  MailgunConfig
+	Port    int
+	Env     string
+	Pepper  string
+	HMACKey string
+	// BaseURL is where the site is served from, for the links
+	// in emails.
+	BaseURL string
+	// DefaultLocale is the language of the emails to people who
+	// haven't picked one. There have to be email templates for it.
+	DefaultLocale string
+	// AdminEmails are the addresses of the users who can see
+	// the site admin pages, like the failed emails.
+	AdminEmails []string
+	// TrustProxy takes the client's address from the headers set
+	// by the proxy in front of the site, like Caddy. Only turn it
+	// on when the site can't be reached except through the proxy.
+	TrustProxy bool
+	Database   PostgresConfig
+	Mailgun    MailgunConfig
+	Email      EmailConfig
+	Outbox     OutboxConfig
+	Webhooks   WebhooksConfig
+	Chat       ChatConfig
+	Scheduler  SchedulerConfig
+	RateLimit  RateLimitConfig
 }
 
 type PostgresConfig struct {
@@ -43,10 +64,12 @@ func (c Config) IsProd() bool {
 
 func DefaultConfig() Config {
 	return Config{
-		Port:    3000,
-		Env:     "dev",
-		Pepper:  "secret-random-string",
-		HMACKey: "secret-hmac-key",
+		Port:          3000,
+		Env:           "dev",
+		Pepper:        "secret-random-string",
+		HMACKey:       "secret-hmac-key",
+		BaseURL:       "http://localhost:3000",
+		DefaultLocale: "en",
 		Database: PostgresConfig{
 			Host:     "localhost",
 			Port:     5432,
@@ -54,6 +77,46 @@ func DefaultConfig() Config {
 			Password: "postgres",
 			Name:     "postgres",
 		},
+		Email: EmailConfig{
+			Maildir: "tmp/maildir",
+			SMTP: SMTPConfig{
+				Host: "localhost",
+				Port: 587,
+			},
+		},
+		Outbox: OutboxConfig{
+			Workers:     4,
+			MaxAttempts: 8,
+			Backoff:     time.Minute,
+			Poll:        30 * time.Second,
+			Retention:   30 * 24 * time.Hour,
+		},
+		Webhooks: WebhooksConfig{
+			Workers:     2,
+			MaxAttempts: 8,
+			Backoff:     time.Minute,
+			Poll:        30 * time.Second,
+			Timeout:     10 * time.Second,
+		},
+		Chat: ChatConfig{
+			Timeout: 30 * time.Second,
+		},
+		Scheduler: SchedulerConfig{
+			Interval:           15 * time.Minute,
+			ReminderDaysBefore: 2,
+			NagEveryDays:       1,
+			MaxNags:            3,
+			DigestWeekday:      "Sunday",
+			DigestHour:         18,
+		},
+		RateLimit: RateLimitConfig{
+			Store:     "memory",
+			PerIP:     20,
+			PerEmail:  5,
+			Window:    15 * time.Minute,
+			LockAfter: 10,
+			LockFor:   30 * time.Minute,
+		},
 	}
 }
 
@@ -63,6 +126,110 @@ type MailgunConfig struct {
 	Domain       string
 }
 
+// EmailConfig picks how emails are sent: "mailgun" through the
+// Mailgun API in the Mailgun config, "smtp" through a mail
+// server, "maildir" into a directory or "memory" nowhere but a
+// recorder. Left empty it is mailgun when there is a Mailgun API
+// key and maildir otherwise. FromAddress defaults to support@
+// the Mailgun domain.
+type EmailConfig struct {
+	Transport   string
+	FromAddress string
+	Maildir     string
+	SMTP        SMTPConfig
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// OutboxConfig is how the outbox delivers emails in the
+// background. The first retry waits Backoff and every one after
+// that twice as long, until a message has had MaxAttempts.
+// Sent and dead messages are purged once they are older than
+// Retention.
+type OutboxConfig struct {
+	Workers     int
+	MaxAttempts int
+	Backoff     time.Duration
+	Poll        time.Duration
+	Retention   time.Duration
+}
+
+// WebhooksConfig is how webhook deliveries are posted in the
+// background. Like the outbox, the first retry waits Backoff and
+// every one after that twice as long, until a delivery has had
+// MaxAttempts. Timeout is how long a webhook has to answer.
+type WebhooksConfig struct {
+	Workers     int
+	MaxAttempts int
+	Backoff     time.Duration
+	Poll        time.Duration
+	Timeout     time.Duration
+}
+
+// ChatConfig sets up the chat bot on "telegram" or "matrix", or
+// no bot when Service is empty. Endpoint is where the service's
+// API is, which defaults to Telegram's own for telegram and is
+// the homeserver for matrix. Token is the bot token or Matrix
+// access token, and UserID the bot's own Matrix user so it
+// doesn't answer itself. Contact is who people are told to
+// message, eg "@heartfort_bot". Timeout is how long each long
+// poll for commands waits.
+type ChatConfig struct {
+	Service  string
+	Endpoint string
+	Token    string
+	UserID   string
+	Contact  string
+	Timeout  time.Duration
+}
+
+// SchedulerConfig is how often the background scheduler runs
+// and when it sends reminders and the weekly digest. An Interval
+// of zero turns the scheduler off. DigestHour is in UTC.
+type SchedulerConfig struct {
+	Interval           time.Duration
+	ReminderDaysBefore int
+	NagEveryDays       int
+	MaxNags            int
+	DigestWeekday      string
+	DigestHour         int
+}
+
+// Weekday parses DigestWeekday, eg "Sunday" or "sun", falling
+// back to Sunday for anything it doesn't recognise.
+func (c SchedulerConfig) Weekday() time.Weekday {
+	name := strings.ToLower(strings.TrimSpace(c.DigestWeekday))
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		day := strings.ToLower(d.String())
+		if name == day || (len(name) >= 3 && strings.HasPrefix(day, name)) {
+			return d
+		}
+	}
+	log.Printf("unknown digest weekday %q, using Sunday", c.DigestWeekday)
+	return time.Sunday
+}
+
+// RateLimitConfig is how often logging in and emails like
+// password resets can be tried. Each IP address can try PerIP
+// times and each email address PerEmail times in every Window.
+// Store is "memory" for limits each server keeps on its own, or
+// "database" to share them between servers. LockAfter wrong
+// passwords within LockFor lock an account for LockFor, or never
+// when it is zero.
+type RateLimitConfig struct {
+	Store     string
+	PerIP     int
+	PerEmail  int
+	Window    time.Duration
+	LockAfter int
+	LockFor   time.Duration
+}
+
 func LoadConfig() Config {
 	var c Config
 	c = DefaultConfig()
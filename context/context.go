@@ -9,7 +9,11 @@ import (
 type privateKey string
 
 const (
-	userKey privateKey = "user"
+	userKey       privateKey = "user"
+	householdKey  privateKey = "household"
+	membershipKey privateKey = "membership"
+	apiTokenKey   privateKey = "api_token"
+	sessionKey    privateKey = "session"
 )
 
 func WithUser(ctx context.Context, user *models.User) context.Context {
@@ -24,3 +28,63 @@ func User(ctx context.Context) *models.User {
 	}
 	return nil
 }
+
+func WithHousehold(ctx context.Context, household *models.Household) context.Context {
+	return context.WithValue(ctx, householdKey, household)
+}
+
+// Household returns the household the current user is working
+// in, or nil if there isn't one.
+func Household(ctx context.Context) *models.Household {
+	if temp := ctx.Value(householdKey); temp != nil {
+		if household, ok := temp.(*models.Household); ok {
+			return household
+		}
+	}
+	return nil
+}
+
+func WithMembership(ctx context.Context, membership *models.Membership) context.Context {
+	return context.WithValue(ctx, membershipKey, membership)
+}
+
+// Membership returns the current user's membership of their
+// active household, or nil if there isn't one.
+func Membership(ctx context.Context) *models.Membership {
+	if temp := ctx.Value(membershipKey); temp != nil {
+		if membership, ok := temp.(*models.Membership); ok {
+			return membership
+		}
+	}
+	return nil
+}
+
+func WithAPIToken(ctx context.Context, apiToken *models.APIToken) context.Context {
+	return context.WithValue(ctx, apiTokenKey, apiToken)
+}
+
+// APIToken returns the personal access token the request was
+// made with, or nil if it came from a logged in browser.
+func APIToken(ctx context.Context) *models.APIToken {
+	if temp := ctx.Value(apiTokenKey); temp != nil {
+		if apiToken, ok := temp.(*models.APIToken); ok {
+			return apiToken
+		}
+	}
+	return nil
+}
+
+func WithSession(ctx context.Context, session *models.Session) context.Context {
+	return context.WithValue(ctx, sessionKey, session)
+}
+
+// Session returns the session of the device the request came
+// from, or nil if it wasn't made from a logged in browser.
+func Session(ctx context.Context) *models.Session {
+	if temp := ctx.Value(sessionKey); temp != nil {
+		if session, ok := temp.(*models.Session); ok {
+			return session
+		}
+	}
+	return nil
+}
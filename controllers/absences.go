@@ -0,0 +1,267 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+const (
+	IndexAbsences = "index_absences"
+	EditAbsence   = "edit_absence"
+)
+
+func NewAbsences(abs models.AbsenceService, hs models.HouseholdService, r *mux.Router) *Absences {
+	return &Absences{
+		NewView:   views.NewView("layout", "absences/new"),
+		EditView:  views.NewView("layout", "absences/edit"),
+		IndexView: views.NewView("layout", "absences/index"),
+		abs:       abs,
+		hs:        hs,
+		r:         r,
+	}
+}
+
+type Absences struct {
+	NewView   *views.View
+	EditView  *views.View
+	IndexView *views.View
+	abs       models.AbsenceService
+	hs        models.HouseholdService
+	r         *mux.Router
+}
+
+type AbsenceForm struct {
+	UserID    uint   `schema:"user_id"`
+	StartDate string `schema:"start_date"`
+	EndDate   string `schema:"end_date"`
+	Reason    string `schema:"reason"`
+}
+
+// AbsenceData is what the new and edit absence views expect as
+// their Yield. Members are who the absence can be for.
+type AbsenceData struct {
+	Absence *models.Absence
+	Members []models.User
+	Form    AbsenceForm
+}
+
+// GET /absences
+func (a *Absences) Index(w http.ResponseWriter, r *http.Request) {
+	household := context.Household(r.Context())
+	absences, err := a.abs.ByHouseholdID(household.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = absences
+	a.IndexView.Render(w, r, vd)
+}
+
+// GET /absences/new
+func (a *Absences) New(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	form := AbsenceForm{UserID: context.User(r.Context()).ID}
+	a.render(w, r, a.NewView, vd, nil, form)
+}
+
+// POST /absences
+func (a *Absences) Create(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form AbsenceForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		a.render(w, r, a.NewView, vd, nil, form)
+		return
+	}
+	household := context.Household(r.Context())
+	absence := models.Absence{
+		HouseholdID: household.ID,
+	}
+	if err := a.fill(&absence, form); err != nil {
+		vd.SetAlert(err)
+		a.render(w, r, a.NewView, vd, nil, form)
+		return
+	}
+	if !context.Membership(r.Context()).CanManageAbsence(&absence) {
+		forbidden(w, r)
+		return
+	}
+	if err := a.abs.Create(&absence); err != nil {
+		vd.SetAlert(err)
+		a.render(w, r, a.NewView, vd, nil, form)
+		return
+	}
+	a.redirectToIndex(w, r, "Absence added.")
+}
+
+// GET /absences/:id/edit
+func (a *Absences) Edit(w http.ResponseWriter, r *http.Request) {
+	absence, err := a.absenceByID(w, r)
+	if err != nil {
+		return
+	}
+	if !context.Membership(r.Context()).CanManageAbsence(absence) {
+		forbidden(w, r)
+		return
+	}
+	var vd views.Data
+	form := AbsenceForm{
+		UserID:    absence.UserID,
+		StartDate: absence.StartDate.Format(views.DateLayout),
+		EndDate:   absence.EndDate.Format(views.DateLayout),
+		Reason:    absence.Reason,
+	}
+	a.render(w, r, a.EditView, vd, absence, form)
+}
+
+// POST /absences/:id/update
+func (a *Absences) Update(w http.ResponseWriter, r *http.Request) {
+	absence, err := a.absenceByID(w, r)
+	if err != nil {
+		return
+	}
+	membership := context.Membership(r.Context())
+	if !membership.CanManageAbsence(absence) {
+		forbidden(w, r)
+		return
+	}
+	var vd views.Data
+	var form AbsenceForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		a.render(w, r, a.EditView, vd, absence, form)
+		return
+	}
+	if err := a.fill(absence, form); err != nil {
+		vd.SetAlert(err)
+		a.render(w, r, a.EditView, vd, absence, form)
+		return
+	}
+	// Check again in case the absence was handed to someone else.
+	if !membership.CanManageAbsence(absence) {
+		forbidden(w, r)
+		return
+	}
+	if err := a.abs.Update(absence); err != nil {
+		vd.SetAlert(err)
+		a.render(w, r, a.EditView, vd, absence, form)
+		return
+	}
+	a.redirectToIndex(w, r, "Absence updated.")
+}
+
+// POST /absences/:id/delete
+func (a *Absences) Delete(w http.ResponseWriter, r *http.Request) {
+	absence, err := a.absenceByID(w, r)
+	if err != nil {
+		return
+	}
+	if !context.Membership(r.Context()).CanManageAbsence(absence) {
+		forbidden(w, r)
+		return
+	}
+	if err := a.abs.Delete(absence.ID); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		a.render(w, r, a.EditView, vd, absence, AbsenceForm{
+			UserID:    absence.UserID,
+			StartDate: absence.StartDate.Format(views.DateLayout),
+			EndDate:   absence.EndDate.Format(views.DateLayout),
+			Reason:    absence.Reason,
+		})
+		return
+	}
+	a.redirectToIndex(w, r, "Absence deleted.")
+}
+
+// fill copies the form onto the absence, making sure it is for
+// someone in the absence's household.
+func (a *Absences) fill(absence *models.Absence, form AbsenceForm) error {
+	if form.UserID != 0 {
+		if _, err := a.hs.Membership(absence.HouseholdID, form.UserID); err != nil {
+			if err == models.ErrNotFound {
+				return models.ErrNotMember
+			}
+			return err
+		}
+	}
+	// Anything a date input can't parse is as good as missing.
+	start, err := parseDate(form.StartDate)
+	if err != nil || start == nil {
+		return models.ErrStartDateRequired
+	}
+	end, err := parseDate(form.EndDate)
+	if err != nil || end == nil {
+		return models.ErrEndDateRequired
+	}
+	absence.UserID = form.UserID
+	absence.StartDate = *start
+	absence.EndDate = *end
+	absence.Reason = form.Reason
+	return nil
+}
+
+// render renders the new or edit view with an alert that may
+// already be set on vd.
+func (a *Absences) render(w http.ResponseWriter, r *http.Request, view *views.View, vd views.Data, absence *models.Absence, form AbsenceForm) {
+	household := context.Household(r.Context())
+	members, err := a.hs.Members(household.ID)
+	if err != nil {
+		log.Println(err)
+	}
+	vd.Yield = AbsenceData{
+		Absence: absence,
+		Members: members,
+		Form:    form,
+	}
+	view.Render(w, r, vd)
+}
+
+func (a *Absences) redirectToIndex(w http.ResponseWriter, r *http.Request, message string) {
+	url, err := a.r.Get(IndexAbsences).URL()
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: message,
+	})
+}
+
+func (a *Absences) absenceByID(w http.ResponseWriter, r *http.Request) (*models.Absence, error) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Invalid absence ID", http.StatusNotFound)
+		return nil, err
+	}
+	absence, err := a.abs.ByID(uint(id))
+	household := context.Household(r.Context())
+	if err == nil && absence.HouseholdID != household.ID {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Absence not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went terribly wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return absence, nil
+}
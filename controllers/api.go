@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+const (
+	// APIPrefix is where version 1 of the JSON API is served.
+	APIPrefix = "/api/v1"
+
+	// DefaultPerPage is how many items an API list returns
+	// unless asked for another number, which can be at most
+	// MaxPerPage.
+	DefaultPerPage = 25
+	MaxPerPage     = 100
+
+	// maxAPIBody is the largest request body the API reads.
+	maxAPIBody = 1 << 20
+)
+
+// Pagination says which part of a list an API response holds.
+// Pages count from 1 and Total is the length of the whole list.
+type Pagination struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+	Total   int `json:"total"`
+}
+
+// ListResponse is what every API list looks like.
+type ListResponse struct {
+	Data       interface{} `json:"data"`
+	Pagination Pagination  `json:"pagination"`
+}
+
+// PageForm is used to pick a page of an API list.
+type PageForm struct {
+	Page    int `schema:"page"`
+	PerPage int `schema:"per_page"`
+}
+
+// parsePage reads the page asked for from the query string and
+// works out the part of a list of total items it covers, from
+// start up to but not including end.
+func parsePage(r *http.Request, total int) (p Pagination, start, end int, ok bool) {
+	var form PageForm
+	if err := parseURLParams(r, &form); err != nil {
+		return p, 0, 0, false
+	}
+	if form.Page == 0 {
+		form.Page = 1
+	}
+	if form.PerPage == 0 {
+		form.PerPage = DefaultPerPage
+	}
+	if form.Page < 1 || form.PerPage < 1 || form.PerPage > MaxPerPage {
+		return p, 0, 0, false
+	}
+	p = Pagination{Page: form.Page, PerPage: form.PerPage, Total: total}
+	start = (p.Page - 1) * p.PerPage
+	if start > total {
+		start = total
+	}
+	end = start + p.PerPage
+	if end > total {
+		end = total
+	}
+	return p, start, end, true
+}
+
+// renderPageError answers a request for a page that can't be
+// made sense of.
+func renderPageError(w http.ResponseWriter) {
+	views.RenderJSONMessage(w, http.StatusBadRequest,
+		"Page must be 1 or more and per_page between 1 and "+strconv.Itoa(MaxPerPage))
+}
+
+// decodeJSON reads the JSON body of an API request into dst,
+// answering with a 400 and returning false if it can't.
+// Unknown fields are an error so typos don't go unnoticed.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		views.RenderJSONMessage(w, http.StatusBadRequest, "Request body is not valid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// renderAPIError answers with the status that goes with err.
+// Our own errors are the client's fault, except for not found,
+// and anything else is ours.
+func renderAPIError(w http.ResponseWriter, err error) {
+	switch err {
+	case models.ErrNotFound:
+		views.RenderJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	if _, ok := err.(views.PublicError); ok {
+		views.RenderJSONError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	views.RenderJSONError(w, http.StatusInternalServerError, err)
+}
+
+// apiForbidden is forbidden for the API.
+func apiForbidden(w http.ResponseWriter) {
+	views.RenderJSONMessage(w, http.StatusForbidden, "Your role in this household doesn't allow that")
+}
+
+// apiID reads the ID in the route of an API request, answering
+// with a 404 and returning false if it isn't one.
+func apiID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id <= 0 {
+		views.RenderJSONError(w, http.StatusNotFound, models.ErrNotFound)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// apiDate writes a date out the way the API and date inputs
+// both take them, or nil for no date.
+func apiDate(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.Format(views.DateLayout)
+	return &s
+}
+
+// parseAPIDate parses a date given to the API, answering with
+// a 422 and returning false if it isn't one.
+func parseAPIDate(w http.ResponseWriter, field string, s *string) (*time.Time, bool) {
+	if s == nil {
+		return nil, true
+	}
+	t, err := parseDate(*s)
+	if err != nil {
+		views.RenderJSONMessage(w, http.StatusUnprocessableEntity, field+" must be a date like "+views.DateLayout)
+		return nil, false
+	}
+	return t, true
+}
@@ -0,0 +1,279 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+	"github.com/sirodoht/heartfort/webhook"
+)
+
+func NewAPIAssignments(as models.AssignmentService, js models.JobService, hs models.HouseholdService, dispatcher *webhook.Dispatcher) *APIAssignments {
+	return &APIAssignments{
+		as:         as,
+		js:         js,
+		hs:         hs,
+		dispatcher: dispatcher,
+	}
+}
+
+type APIAssignments struct {
+	as         models.AssignmentService
+	js         models.JobService
+	hs         models.HouseholdService
+	dispatcher *webhook.Dispatcher
+}
+
+// APIAssignment is how the API shows an assignment.
+type APIAssignment struct {
+	ID           uint       `json:"id"`
+	UserID       uint       `json:"user_id"`
+	UserName     string     `json:"user_name"`
+	JobID        uint       `json:"job_id"`
+	JobName      string     `json:"job_name"`
+	WeekStart    *string    `json:"week_start"`
+	Status       string     `json:"status"`
+	StartedAt    *time.Time `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at"`
+	FinishedByID *uint      `json:"finished_by_id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+func newAPIAssignment(a *models.Assignment) APIAssignment {
+	return APIAssignment{
+		ID:           a.ID,
+		UserID:       a.UserID,
+		UserName:     a.User.Name,
+		JobID:        a.JobID,
+		JobName:      a.Job.Name,
+		WeekStart:    apiDate(a.WeekStart),
+		Status:       a.Status,
+		StartedAt:    a.StartedAt,
+		FinishedAt:   a.FinishedAt,
+		FinishedByID: a.FinishedByID,
+		CreatedAt:    a.CreatedAt,
+		UpdatedAt:    a.UpdatedAt,
+	}
+}
+
+// APIAssignmentInput is the body of a request to create or
+// change an assignment. Fields left out keep their value.
+// Changing the status does what the status buttons do, so
+// members can finish their own assignments while everything
+// else takes someone who manages the rota.
+type APIAssignmentInput struct {
+	UserID    *uint   `json:"user_id"`
+	JobID     *uint   `json:"job_id"`
+	WeekStart *string `json:"week_start"`
+	Status    *string `json:"status"`
+}
+
+// AssignmentFilterForm narrows down the assignments the API
+// lists to one week, one user or both.
+type AssignmentFilterForm struct {
+	Week   string `schema:"week"`
+	UserID uint   `schema:"user_id"`
+}
+
+// GET /api/v1/households/:household_id/assignments
+func (a *APIAssignments) Index(w http.ResponseWriter, r *http.Request) {
+	var form AssignmentFilterForm
+	if err := parseURLParams(r, &form); err != nil {
+		views.RenderJSONMessage(w, http.StatusBadRequest, "Filters are not valid")
+		return
+	}
+	household := context.Household(r.Context())
+	var assignments []models.Assignment
+	var err error
+	if form.Week != "" {
+		weekStart, perr := parseWeekStart(form.Week)
+		if perr != nil {
+			views.RenderJSONMessage(w, http.StatusBadRequest, "week must be a date like "+views.DateLayout)
+			return
+		}
+		assignments, err = a.as.ByWeek(household.ID, *weekStart)
+	} else {
+		assignments, err = a.as.ByHouseholdID(household.ID)
+	}
+	if err != nil {
+		renderAPIError(w, err)
+		return
+	}
+	if form.UserID != 0 {
+		mine := assignments[:0]
+		for _, assignment := range assignments {
+			if assignment.UserID == form.UserID {
+				mine = append(mine, assignment)
+			}
+		}
+		assignments = mine
+	}
+	page, start, end, ok := parsePage(r, len(assignments))
+	if !ok {
+		renderPageError(w)
+		return
+	}
+	data := make([]APIAssignment, 0, end-start)
+	for i := start; i < end; i++ {
+		data = append(data, newAPIAssignment(&assignments[i]))
+	}
+	views.RenderJSON(w, http.StatusOK, ListResponse{Data: data, Pagination: page})
+}
+
+// GET /api/v1/households/:household_id/assignments/:id
+func (a *APIAssignments) Show(w http.ResponseWriter, r *http.Request) {
+	assignment, ok := a.assignmentByID(w, r)
+	if !ok {
+		return
+	}
+	views.RenderJSON(w, http.StatusOK, newAPIAssignment(assignment))
+}
+
+// POST /api/v1/households/:household_id/assignments
+func (a *APIAssignments) Create(w http.ResponseWriter, r *http.Request) {
+	if !context.Membership(r.Context()).CanManageRota() {
+		apiForbidden(w)
+		return
+	}
+	var in APIAssignmentInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if in.Status != nil {
+		views.RenderJSONMessage(w, http.StatusUnprocessableEntity, "New assignments are always pending")
+		return
+	}
+	household := context.Household(r.Context())
+	assignment := models.Assignment{HouseholdID: household.ID}
+	if !a.apply(w, &in, &assignment) {
+		return
+	}
+	if err := a.as.Create(&assignment); err != nil {
+		renderAPIError(w, err)
+		return
+	}
+	created, err := a.as.ByID(assignment.ID)
+	if err != nil {
+		renderAPIError(w, err)
+		return
+	}
+	emitEvent(a.dispatcher, household.ID, webhook.EventAssignmentCreated, newAPIAssignment(created))
+	w.Header().Set("Location", fmt.Sprintf("%s/households/%d/assignments/%d", APIPrefix, household.ID, created.ID))
+	views.RenderJSON(w, http.StatusCreated, newAPIAssignment(created))
+}
+
+// PATCH /api/v1/households/:household_id/assignments/:id
+func (a *APIAssignments) Update(w http.ResponseWriter, r *http.Request) {
+	assignment, ok := a.assignmentByID(w, r)
+	if !ok {
+		return
+	}
+	var in APIAssignmentInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	membership := context.Membership(r.Context())
+	rota := in.UserID != nil || in.JobID != nil || in.WeekStart != nil
+	if rota && !membership.CanManageRota() {
+		apiForbidden(w)
+		return
+	}
+	if in.Status != nil && !membership.CanSetStatus(assignment, *in.Status) {
+		apiForbidden(w)
+		return
+	}
+	if rota {
+		if !a.apply(w, &in, assignment) {
+			return
+		}
+		if err := a.as.Update(assignment); err != nil {
+			renderAPIError(w, err)
+			return
+		}
+	}
+	completed := false
+	if in.Status != nil && *in.Status != assignment.Status {
+		user := context.User(r.Context())
+		if err := a.as.SetStatus(assignment, *in.Status, user.ID); err != nil {
+			renderAPIError(w, err)
+			return
+		}
+		completed = assignment.Status == models.StatusDone
+	}
+	updated, err := a.as.ByID(assignment.ID)
+	if err != nil {
+		renderAPIError(w, err)
+		return
+	}
+	if completed {
+		emitEvent(a.dispatcher, updated.HouseholdID, webhook.EventAssignmentCompleted, newAPIAssignment(updated))
+	}
+	views.RenderJSON(w, http.StatusOK, newAPIAssignment(updated))
+}
+
+// DELETE /api/v1/households/:household_id/assignments/:id
+func (a *APIAssignments) Delete(w http.ResponseWriter, r *http.Request) {
+	assignment, ok := a.assignmentByID(w, r)
+	if !ok {
+		return
+	}
+	if !context.Membership(r.Context()).CanManageRota() {
+		apiForbidden(w)
+		return
+	}
+	if err := a.as.Delete(assignment.ID); err != nil {
+		renderAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apply copies the rota fields given in the input onto the
+// assignment, making sure its job and user are in the
+// household.
+func (a *APIAssignments) apply(w http.ResponseWriter, in *APIAssignmentInput, assignment *models.Assignment) bool {
+	if in.WeekStart != nil {
+		weekStart, ok := parseAPIDate(w, "week_start", in.WeekStart)
+		if !ok {
+			return false
+		}
+		if weekStart != nil {
+			ws := models.WeekOf(*weekStart)
+			weekStart = &ws
+		}
+		assignment.WeekStart = weekStart
+	}
+	if in.UserID != nil {
+		assignment.UserID = *in.UserID
+	}
+	if in.JobID != nil {
+		assignment.JobID = *in.JobID
+	}
+	err := checkAssignment(a.js, a.hs, assignment.HouseholdID, assignment.JobID, assignment.UserID)
+	if err != nil {
+		renderAPIError(w, err)
+		return false
+	}
+	return true
+}
+
+func (a *APIAssignments) assignmentByID(w http.ResponseWriter, r *http.Request) (*models.Assignment, bool) {
+	id, ok := apiID(w, r)
+	if !ok {
+		return nil, false
+	}
+	assignment, err := a.as.ByID(id)
+	household := context.Household(r.Context())
+	if err == nil && assignment.HouseholdID != household.ID {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		renderAPIError(w, err)
+		return nil, false
+	}
+	return assignment, true
+}
@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+	"github.com/sirodoht/heartfort/webhook"
+)
+
+func NewAPIJobs(js models.JobService, dispatcher *webhook.Dispatcher) *APIJobs {
+	return &APIJobs{
+		js:         js,
+		dispatcher: dispatcher,
+	}
+}
+
+type APIJobs struct {
+	js         models.JobService
+	dispatcher *webhook.Dispatcher
+}
+
+// APIJob is how the API shows a job.
+type APIJob struct {
+	ID            uint      `json:"id"`
+	Name          string    `json:"name"`
+	IntervalUnit  string    `json:"interval_unit"`
+	IntervalCount int       `json:"interval_count"`
+	AnchorDate    *string   `json:"anchor_date"`
+	RRule         string    `json:"rrule"`
+	Effort        int       `json:"effort"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func newAPIJob(job *models.Job) APIJob {
+	return APIJob{
+		ID:            job.ID,
+		Name:          job.Name,
+		IntervalUnit:  job.IntervalUnit,
+		IntervalCount: job.IntervalCount,
+		AnchorDate:    apiDate(job.AnchorDate),
+		RRule:         job.RRule,
+		Effort:        job.Effort,
+		CreatedAt:     job.CreatedAt,
+		UpdatedAt:     job.UpdatedAt,
+	}
+}
+
+// APIJobInput is the body of a request to create or change a
+// job. Fields left out keep their value, or their default when
+// creating.
+type APIJobInput struct {
+	Name          *string `json:"name"`
+	IntervalUnit  *string `json:"interval_unit"`
+	IntervalCount *int    `json:"interval_count"`
+	AnchorDate    *string `json:"anchor_date"`
+	RRule         *string `json:"rrule"`
+	Effort        *int    `json:"effort"`
+}
+
+// apply copies the fields given in the input onto the job.
+func (in *APIJobInput) apply(w http.ResponseWriter, job *models.Job) bool {
+	if in.AnchorDate != nil {
+		anchorDate, ok := parseAPIDate(w, "anchor_date", in.AnchorDate)
+		if !ok {
+			return false
+		}
+		job.AnchorDate = anchorDate
+	}
+	if in.Name != nil {
+		job.Name = *in.Name
+	}
+	if in.IntervalUnit != nil {
+		job.IntervalUnit = *in.IntervalUnit
+	}
+	if in.IntervalCount != nil {
+		job.IntervalCount = *in.IntervalCount
+	}
+	if in.RRule != nil {
+		job.RRule = *in.RRule
+	}
+	if in.Effort != nil {
+		job.Effort = *in.Effort
+	}
+	return true
+}
+
+// GET /api/v1/households/:household_id/jobs
+func (j *APIJobs) Index(w http.ResponseWriter, r *http.Request) {
+	household := context.Household(r.Context())
+	jobs, err := j.js.ByHouseholdID(household.ID)
+	if err != nil {
+		renderAPIError(w, err)
+		return
+	}
+	page, start, end, ok := parsePage(r, len(jobs))
+	if !ok {
+		renderPageError(w)
+		return
+	}
+	data := make([]APIJob, 0, end-start)
+	for i := start; i < end; i++ {
+		data = append(data, newAPIJob(&jobs[i]))
+	}
+	views.RenderJSON(w, http.StatusOK, ListResponse{Data: data, Pagination: page})
+}
+
+// GET /api/v1/households/:household_id/jobs/:id
+func (j *APIJobs) Show(w http.ResponseWriter, r *http.Request) {
+	job, ok := j.jobByID(w, r)
+	if !ok {
+		return
+	}
+	views.RenderJSON(w, http.StatusOK, newAPIJob(job))
+}
+
+// POST /api/v1/households/:household_id/jobs
+func (j *APIJobs) Create(w http.ResponseWriter, r *http.Request) {
+	if !context.Membership(r.Context()).CanManageJobs() {
+		apiForbidden(w)
+		return
+	}
+	var in APIJobInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	household := context.Household(r.Context())
+	job := models.Job{HouseholdID: household.ID}
+	if !in.apply(w, &job) {
+		return
+	}
+	if err := j.js.Create(&job); err != nil {
+		renderAPIError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s/households/%d/jobs/%d", APIPrefix, household.ID, job.ID))
+	views.RenderJSON(w, http.StatusCreated, newAPIJob(&job))
+}
+
+// PATCH /api/v1/households/:household_id/jobs/:id
+func (j *APIJobs) Update(w http.ResponseWriter, r *http.Request) {
+	job, ok := j.jobByID(w, r)
+	if !ok {
+		return
+	}
+	if !context.Membership(r.Context()).CanManageJobs() {
+		apiForbidden(w)
+		return
+	}
+	var in APIJobInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if !in.apply(w, job) {
+		return
+	}
+	if err := j.js.Update(job); err != nil {
+		renderAPIError(w, err)
+		return
+	}
+	emitEvent(j.dispatcher, job.HouseholdID, webhook.EventJobUpdated, newAPIJob(job))
+	views.RenderJSON(w, http.StatusOK, newAPIJob(job))
+}
+
+// DELETE /api/v1/households/:household_id/jobs/:id
+func (j *APIJobs) Delete(w http.ResponseWriter, r *http.Request) {
+	job, ok := j.jobByID(w, r)
+	if !ok {
+		return
+	}
+	if !context.Membership(r.Context()).CanManageJobs() {
+		apiForbidden(w)
+		return
+	}
+	if err := j.js.Delete(job.ID); err != nil {
+		renderAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (j *APIJobs) jobByID(w http.ResponseWriter, r *http.Request) (*models.Job, bool) {
+	id, ok := apiID(w, r)
+	if !ok {
+		return nil, false
+	}
+	job, err := j.js.ByID(id)
+	household := context.Household(r.Context())
+	if err == nil && job.HouseholdID != household.ID {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		renderAPIError(w, err)
+		return nil, false
+	}
+	return job, true
+}
@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/email"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+func NewAPIMates(ms models.MateService, ns models.NotificationService, emailer *email.Client) *APIMates {
+	return &APIMates{
+		ms:      ms,
+		ns:      ns,
+		emailer: emailer,
+	}
+}
+
+type APIMates struct {
+	ms      models.MateService
+	ns      models.NotificationService
+	emailer *email.Client
+}
+
+// APIMate is how the API shows a mate.
+type APIMate struct {
+	ID        uint      `json:"id"`
+	Email     string    `json:"email"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newAPIMate(mate *models.Mate) APIMate {
+	return APIMate{
+		ID:        mate.ID,
+		Email:     mate.Email,
+		Status:    mate.Status,
+		CreatedAt: mate.CreatedAt,
+		UpdatedAt: mate.UpdatedAt,
+	}
+}
+
+// APIMateInput is the body of a request to sign a mate up or
+// change their email address.
+type APIMateInput struct {
+	Email string `json:"email"`
+}
+
+// GET /api/v1/households/:household_id/mates
+func (m *APIMates) Index(w http.ResponseWriter, r *http.Request) {
+	household := context.Household(r.Context())
+	mates, err := m.ms.ByHouseholdID(household.ID)
+	if err != nil {
+		renderAPIError(w, err)
+		return
+	}
+	page, start, end, ok := parsePage(r, len(mates))
+	if !ok {
+		renderPageError(w)
+		return
+	}
+	data := make([]APIMate, 0, end-start)
+	for i := start; i < end; i++ {
+		data = append(data, newAPIMate(&mates[i]))
+	}
+	views.RenderJSON(w, http.StatusOK, ListResponse{Data: data, Pagination: page})
+}
+
+// GET /api/v1/households/:household_id/mates/:id
+func (m *APIMates) Show(w http.ResponseWriter, r *http.Request) {
+	mate, ok := m.mateByID(w, r)
+	if !ok {
+		return
+	}
+	views.RenderJSON(w, http.StatusOK, newAPIMate(mate))
+}
+
+// Create signs the address up like the notifications form
+// does, so the mate is pending until they confirm it.
+//
+// POST /api/v1/households/:household_id/mates
+func (m *APIMates) Create(w http.ResponseWriter, r *http.Request) {
+	var in APIMateInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	household := context.Household(r.Context())
+	mate, err := m.ms.Subscribe(household.ID, in.Email)
+	if err != nil {
+		renderAPIError(w, err)
+		return
+	}
+	if mate.Status != models.MateConfirmed {
+		sendMateConfirmation(m.ms, m.ns, m.emailer, mate, household)
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s/households/%d/mates/%d", APIPrefix, household.ID, mate.ID))
+	views.RenderJSON(w, http.StatusCreated, newAPIMate(mate))
+}
+
+// PATCH /api/v1/households/:household_id/mates/:id
+func (m *APIMates) Update(w http.ResponseWriter, r *http.Request) {
+	mate, ok := m.mateByID(w, r)
+	if !ok {
+		return
+	}
+	if !context.Membership(r.Context()).CanManageMates() {
+		apiForbidden(w)
+		return
+	}
+	var in APIMateInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	// A new address has to be confirmed by whoever owns it.
+	changed := mate.Email != in.Email
+	mate.Email = in.Email
+	if changed {
+		mate.Status = models.MatePending
+	}
+	if err := m.ms.Update(mate); err != nil {
+		renderAPIError(w, err)
+		return
+	}
+	if changed {
+		sendMateConfirmation(m.ms, m.ns, m.emailer, mate, context.Household(r.Context()))
+	}
+	views.RenderJSON(w, http.StatusOK, newAPIMate(mate))
+}
+
+// DELETE /api/v1/households/:household_id/mates/:id
+func (m *APIMates) Delete(w http.ResponseWriter, r *http.Request) {
+	mate, ok := m.mateByID(w, r)
+	if !ok {
+		return
+	}
+	if !context.Membership(r.Context()).CanManageMates() {
+		apiForbidden(w)
+		return
+	}
+	if err := m.ms.Delete(mate.ID); err != nil {
+		renderAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *APIMates) mateByID(w http.ResponseWriter, r *http.Request) (*models.Mate, bool) {
+	id, ok := apiID(w, r)
+	if !ok {
+		return nil, false
+	}
+	mate, err := m.ms.ByID(id)
+	household := context.Household(r.Context())
+	if err == nil && mate.HouseholdID != household.ID {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		renderAPIError(w, err)
+		return nil, false
+	}
+	return mate, true
+}
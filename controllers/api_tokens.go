@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+const (
+	IndexAPITokens = "index_api_tokens"
+)
+
+// tokenLifetimes are the choices for how long a new token
+// works, in days. Zero means until it is deleted.
+var tokenLifetimes = []int{30, 90, 365, 0}
+
+func NewAPITokens(ts models.APITokenService, hs models.HouseholdService, tfs models.TwoFactorService, r *mux.Router) *APITokens {
+	return &APITokens{
+		IndexView: views.NewView("layout", "api_tokens/index"),
+		ts:        ts,
+		hs:        hs,
+		tfs:       tfs,
+		r:         r,
+	}
+}
+
+type APITokens struct {
+	IndexView *views.View
+	ts        models.APITokenService
+	hs        models.HouseholdService
+	tfs       models.TwoFactorService
+	r         *mux.Router
+}
+
+// APITokenForm is used to make a new personal access token.
+type APITokenForm struct {
+	Name      string `schema:"name"`
+	Scope     string `schema:"scope"`
+	ExpiresIn int    `schema:"expires_in"`
+}
+
+// APITokensData is what the tokens view expects as its Yield.
+// Created is the token that was just made, the only time its
+// secret can be shown.
+type APITokensData struct {
+	Tokens    []models.APIToken
+	Created   *models.APIToken
+	Form      APITokenForm
+	Scopes    []string
+	Lifetimes []int
+}
+
+// GET /settings/tokens
+func (t *APITokens) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	t.render(w, r, vd, nil, APITokenForm{
+		Scope:     models.ScopeRead,
+		ExpiresIn: tokenLifetimes[0],
+	})
+}
+
+// POST /settings/tokens
+func (t *APITokens) Create(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form APITokenForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		t.render(w, r, vd, nil, form)
+		return
+	}
+	user := context.User(r.Context())
+	// A token gets in without a code, so nobody gets one while a
+	// household of theirs wants a code they don't have.
+	household, err := t.twoFactorMissing(user.ID)
+	if err != nil {
+		log.Println(err)
+		vd.SetAlert(err)
+		t.render(w, r, vd, nil, form)
+		return
+	}
+	if household != nil {
+		vd.Alert = &views.Alert{
+			Level:   views.AlertLvlWarning,
+			Message: household.Name + " requires two-factor authentication. Please turn it on before making a token.",
+		}
+		t.render(w, r, vd, nil, form)
+		return
+	}
+	apiToken := models.APIToken{
+		UserID: user.ID,
+		Name:   form.Name,
+		Scope:  form.Scope,
+	}
+	if form.ExpiresIn > 0 {
+		expiresAt := time.Now().AddDate(0, 0, form.ExpiresIn)
+		apiToken.ExpiresAt = &expiresAt
+	}
+	if err := t.ts.Create(&apiToken); err != nil {
+		vd.SetAlert(err)
+		t.render(w, r, vd, nil, form)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Token created. Copy it now, it won't be shown again.",
+	}
+	t.render(w, r, vd, &apiToken, APITokenForm{
+		Scope:     models.ScopeRead,
+		ExpiresIn: tokenLifetimes[0],
+	})
+}
+
+// POST /settings/tokens/:id/delete
+func (t *APITokens) Delete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Invalid token ID", http.StatusNotFound)
+		return
+	}
+	apiToken, err := t.ts.ByID(uint(id))
+	user := context.User(r.Context())
+	if err == nil && apiToken.UserID != user.ID {
+		err = models.ErrNotFound
+	}
+	if err == nil {
+		err = t.ts.Delete(apiToken.ID)
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Token not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went terribly wrong.", http.StatusInternalServerError)
+		}
+		return
+	}
+	url, err := t.r.Get(IndexAPITokens).URL()
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Token " + apiToken.Name + " deleted. Anything using it can't get in anymore.",
+	})
+}
+
+// twoFactorMissing returns the first of the user's households
+// that requires two-factor authentication they haven't turned
+// on, or nil if there is none.
+func (t *APITokens) twoFactorMissing(userID uint) (*models.Household, error) {
+	households, err := t.hs.ByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range households {
+		allowed, err := t.tfs.Allowed(&households[i], userID)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return &households[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (t *APITokens) render(w http.ResponseWriter, r *http.Request, vd views.Data, created *models.APIToken, form APITokenForm) {
+	user := context.User(r.Context())
+	tokens, err := t.ts.ByUserID(user.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	vd.Yield = APITokensData{
+		Tokens:    tokens,
+		Created:   created,
+		Form:      form,
+		Scopes:    models.Scopes,
+		Lifetimes: tokenLifetimes,
+	}
+	t.IndexView.Render(w, r, vd)
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/email"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+func NewAPIUsers(us models.UserService, hs models.HouseholdService, emailer *email.Client) *APIUsers {
+	return &APIUsers{
+		us:      us,
+		hs:      hs,
+		emailer: emailer,
+	}
+}
+
+// APIUsers only ever deals with the current user. Signing up,
+// logging in and resetting passwords stay on the site.
+type APIUsers struct {
+	us      models.UserService
+	hs      models.HouseholdService
+	emailer *email.Client
+}
+
+// APIUser is how the API shows the current user, along with
+// the households they belong to so clients know which ones
+// they can ask for.
+type APIUser struct {
+	ID         uint           `json:"id"`
+	Name       string         `json:"name"`
+	Email      string         `json:"email"`
+	Locale     string         `json:"locale"`
+	Households []APIHousehold `json:"households"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// APIHousehold is a household the current user belongs to and
+// their role in it.
+type APIHousehold struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// APIUserInput is the body of a request to change the current
+// user. Fields left out keep their value.
+type APIUserInput struct {
+	Name   *string `json:"name"`
+	Locale *string `json:"locale"`
+}
+
+// GET /api/v1/user
+func (u *APIUsers) Show(w http.ResponseWriter, r *http.Request) {
+	u.render(w, context.User(r.Context()))
+}
+
+// PATCH /api/v1/user
+func (u *APIUsers) Update(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var in APIUserInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if in.Locale != nil && !hasLocale(u.emailer, *in.Locale) {
+		renderAPIError(w, models.ErrLocaleInvalid)
+		return
+	}
+	if in.Name != nil {
+		user.Name = *in.Name
+	}
+	if in.Locale != nil {
+		user.Locale = *in.Locale
+	}
+	if err := u.us.Update(user); err != nil {
+		renderAPIError(w, err)
+		return
+	}
+	u.render(w, user)
+}
+
+func (u *APIUsers) render(w http.ResponseWriter, user *models.User) {
+	households, err := u.hs.ByUserID(user.ID)
+	if err != nil {
+		renderAPIError(w, err)
+		return
+	}
+	data := APIUser{
+		ID:         user.ID,
+		Name:       user.Name,
+		Email:      user.Email,
+		Locale:     user.Locale,
+		Households: make([]APIHousehold, 0, len(households)),
+		CreatedAt:  user.CreatedAt,
+		UpdatedAt:  user.UpdatedAt,
+	}
+	for _, household := range households {
+		membership, err := u.hs.Membership(household.ID, user.ID)
+		if err != nil {
+			renderAPIError(w, err)
+			return
+		}
+		data.Households = append(data.Households, APIHousehold{
+			ID:   household.ID,
+			Name: household.Name,
+			Role: membership.Role,
+		})
+	}
+	views.RenderJSON(w, http.StatusOK, data)
+}
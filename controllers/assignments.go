@@ -1,15 +1,18 @@
 package controllers
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/jinzhu/gorm"
 
+	"github.com/sirodoht/heartfort/context"
 	"github.com/sirodoht/heartfort/models"
 	"github.com/sirodoht/heartfort/views"
+	"github.com/sirodoht/heartfort/webhook"
 )
 
 const (
@@ -18,73 +21,148 @@ const (
 	EditAssignment   = "edit_assignment"
 )
 
-func NewAssignments(as models.AssignmentService, js models.JobService, db *gorm.DB, r *mux.Router) *Assignments {
+func NewAssignments(as models.AssignmentService, js models.JobService, rs models.RotaService, ss models.SwapService, abs models.AbsenceService, hs models.HouseholdService, dispatcher *webhook.Dispatcher, r *mux.Router) *Assignments {
 	return &Assignments{
-		New:       views.NewView("layout", "assignments/new"),
-		ShowView:  views.NewView("layout", "assignments/show"),
-		EditView:  views.NewView("layout", "assignments/edit"),
-		IndexView: views.NewView("layout", "assignments/index"),
-		as:        as,
-		js:        js,
-		db:        db,
-		r:         r,
+		New:        views.NewView("layout", "assignments/new"),
+		ShowView:   views.NewView("layout", "assignments/show"),
+		EditView:   views.NewView("layout", "assignments/edit"),
+		IndexView:  views.NewView("layout", "assignments/index"),
+		WeekView:   views.NewView("layout", "assignments/week"),
+		as:         as,
+		js:         js,
+		rs:         rs,
+		ss:         ss,
+		abs:        abs,
+		hs:         hs,
+		dispatcher: dispatcher,
+		r:          r,
 	}
 }
 
 type Assignments struct {
-	New       *views.View
-	ShowView  *views.View
-	EditView  *views.View
-	IndexView *views.View
-	as        models.AssignmentService
-	js        models.JobService
-	db        *gorm.DB
-	r         *mux.Router
+	New        *views.View
+	ShowView   *views.View
+	EditView   *views.View
+	IndexView  *views.View
+	WeekView   *views.View
+	as         models.AssignmentService
+	js         models.JobService
+	rs         models.RotaService
+	ss         models.SwapService
+	abs        models.AbsenceService
+	hs         models.HouseholdService
+	dispatcher *webhook.Dispatcher
+	r          *mux.Router
 }
 
 type AssignmentForm struct {
-	UserID uint `schema:"user_id"`
-	JobID  uint `schema:"job_id"`
+	UserID    uint   `schema:"user_id"`
+	JobID     uint   `schema:"job_id"`
+	WeekStart string `schema:"week_start"`
+}
+
+// GenerateForm is used to pick the week the rota is
+// generated for. An empty WeekStart means the current week.
+type GenerateForm struct {
+	WeekStart string `schema:"week_start"`
+}
+
+// AssignmentsData is what the assignments index view expects
+// as its Yield. UserID is the current user, who can answer
+// some of the pending swaps.
+type AssignmentsData struct {
+	Assignments []models.Assignment
+	Swaps       []models.Swap
+	UserID      uint
 }
 
 // GET /assignments
 func (a *Assignments) Index(w http.ResponseWriter, r *http.Request) {
-	assignments, err := a.as.List()
+	household := context.Household(r.Context())
+	assignments, err := a.as.ByHouseholdID(household.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	swaps, err := a.ss.Pending(household.ID)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
 		return
 	}
 	var vd views.Data
-	vd.Yield = assignments
+	vd.Yield = AssignmentsData{
+		Assignments: assignments,
+		Swaps:       swaps,
+		UserID:      context.User(r.Context()).ID,
+	}
 	a.IndexView.Render(w, r, vd)
 }
 
-// GET /assignments/:id
-func (a *Assignments) Show(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	idStr := vars["id"]
-	id, err := strconv.Atoi(idStr)
+// AssignmentWeekData is what the assignment week view expects
+// as its Yield.
+type AssignmentWeekData struct {
+	WeekStart   *time.Time
+	Previous    *time.Time
+	Next        *time.Time
+	Assignments []models.Assignment
+	Absences    []models.Absence
+}
+
+// Week shows the assignments of one week along with everyone
+// who is away that week, which is why the rota skipped them.
+// An empty week_start means the current week.
+//
+// GET /assignments/week
+func (a *Assignments) Week(w http.ResponseWriter, r *http.Request) {
+	var form GenerateForm
+	if err := parseURLParams(r, &form); err != nil {
+		log.Println(err)
+	}
+	week := models.WeekOf(time.Now())
+	if form.WeekStart != "" {
+		weekStart, err := parseWeekStart(form.WeekStart)
+		if err != nil {
+			http.Error(w, "Invalid week", http.StatusNotFound)
+			return
+		}
+		week = *weekStart
+	}
+	household := context.Household(r.Context())
+	assignments, err := a.as.ByWeek(household.ID, week)
 	if err != nil {
 		log.Println(err)
-		http.Error(w, "Invalid assignment ID", http.StatusNotFound)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
 	}
-
-	type Data struct {
-		AssignmentID string
-		UserID       string
-		Name         string
+	next := week.AddDate(0, 0, 7)
+	absences, err := a.abs.Overlapping(household.ID, week, next)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
 	}
-	var result Data
-	a.db.Raw("SELECT a.id AS assignment_id, a.user_id, j.name FROM assignments a JOIN jobs j ON j.id=a.job_id WHERE a.id = ?", uint(id)).Scan(&result)
-
-	// assignment, err := a.assignmentByID(w, r)
-	// if err != nil {
-	// 	return
-	// }
+	previous := week.AddDate(0, 0, -7)
+	var vd views.Data
+	vd.Yield = AssignmentWeekData{
+		WeekStart:   &week,
+		Previous:    &previous,
+		Next:        &next,
+		Assignments: assignments,
+		Absences:    absences,
+	}
+	a.WeekView.Render(w, r, vd)
+}
 
+// GET /assignments/:id
+func (a *Assignments) Show(w http.ResponseWriter, r *http.Request) {
+	assignment, err := a.assignmentByID(w, r)
+	if err != nil {
+		return
+	}
 	var vd views.Data
-	vd.Yield = result
+	vd.Yield = assignment
 	a.ShowView.Render(w, r, vd)
 }
 
@@ -94,6 +172,10 @@ func (a *Assignments) Edit(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	if !context.Membership(r.Context()).CanManageRota() {
+		forbidden(w, r)
+		return
+	}
 	var vd views.Data
 	vd.Yield = assignment
 	a.EditView.Render(w, r, vd)
@@ -105,6 +187,10 @@ func (a *Assignments) Update(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	if !context.Membership(r.Context()).CanManageRota() {
+		forbidden(w, r)
+		return
+	}
 	var vd views.Data
 	vd.Yield = assignment
 	var form AssignmentForm
@@ -113,19 +199,20 @@ func (a *Assignments) Update(w http.ResponseWriter, r *http.Request) {
 		a.EditView.Render(w, r, vd)
 		return
 	}
+	weekStart, err := parseWeekStart(form.WeekStart)
+	if err != nil {
+		vd.AlertError("Week start must be a date")
+		a.EditView.Render(w, r, vd)
+		return
+	}
+	if err := a.checkForm(assignment.HouseholdID, &form); err != nil {
+		vd.SetAlert(err)
+		a.EditView.Render(w, r, vd)
+		return
+	}
 	assignment.UserID = form.UserID
-
-	// job, err := a.js.ByID(form.JobID)
-	// if err != nil {
-	// 	switch err {
-	// 	case models.ErrNotFound:
-	// 		http.Error(w, "Assignment not found", http.StatusNotFound)
-	// 	default:
-	// 		log.Println(err)
-	// 		http.Error(w, "Something went terribly wrong.", http.StatusInternalServerError)
-	// 	}
-	// }
-	// assignment.Job = *job
+	assignment.JobID = form.JobID
+	assignment.WeekStart = weekStart
 
 	err = a.as.Update(assignment)
 	if err != nil {
@@ -141,6 +228,10 @@ func (a *Assignments) Update(w http.ResponseWriter, r *http.Request) {
 
 // POST /assignments
 func (a *Assignments) Create(w http.ResponseWriter, r *http.Request) {
+	if !context.Membership(r.Context()).CanManageRota() {
+		forbidden(w, r)
+		return
+	}
 	var vd views.Data
 	var form AssignmentForm
 	if err := parseForm(r, &form); err != nil {
@@ -149,26 +240,31 @@ func (a *Assignments) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	job, err := a.js.ByID(form.JobID)
+	household := context.Household(r.Context())
+	if err := a.checkForm(household.ID, &form); err != nil {
+		vd.SetAlert(err)
+		a.New.Render(w, r, vd)
+		return
+	}
+	weekStart, err := parseWeekStart(form.WeekStart)
 	if err != nil {
-		switch err {
-		case models.ErrNotFound:
-			http.Error(w, "Assignment not found", http.StatusNotFound)
-		default:
-			log.Println(err)
-			http.Error(w, "Something went terrible wrong.", http.StatusInternalServerError)
-		}
+		vd.AlertError("Week start must be a date")
+		a.New.Render(w, r, vd)
+		return
 	}
 
 	assignment := models.Assignment{
-		UserID: form.UserID,
-		Job:    *job,
+		HouseholdID: household.ID,
+		UserID:      form.UserID,
+		JobID:       form.JobID,
+		WeekStart:   weekStart,
 	}
 	if err := a.as.Create(&assignment); err != nil {
 		vd.SetAlert(err)
 		a.New.Render(w, r, vd)
 		return
 	}
+	EmitAssignment(a.dispatcher, a.as, webhook.EventAssignmentCreated, assignment.ID)
 
 	url, err := a.r.Get(EditAssignment).URL("id",
 		strconv.Itoa(int(assignment.ID)))
@@ -180,12 +276,155 @@ func (a *Assignments) Create(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, url.Path, http.StatusFound)
 }
 
+// Generate builds the rota for the requested week. Weeks that
+// have already been generated are left as they are.
+//
+// POST /assignments/generate
+func (a *Assignments) Generate(w http.ResponseWriter, r *http.Request) {
+	if !context.Membership(r.Context()).CanManageRota() {
+		forbidden(w, r)
+		return
+	}
+	var form GenerateForm
+	if err := parseForm(r, &form); err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusBadRequest)
+		return
+	}
+	week := time.Now()
+	if form.WeekStart != "" {
+		weekStart, err := parseWeekStart(form.WeekStart)
+		if err != nil {
+			views.RedirectAlert(w, r, "/assignments", http.StatusFound, views.Alert{
+				Level:   views.AlertLvlError,
+				Message: "Week start must be a date",
+			})
+			return
+		}
+		week = *weekStart
+	}
+	household := context.Household(r.Context())
+	assignments, created, err := a.rs.Generate(household.ID, week)
+	if err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		views.RedirectAlert(w, r, "/assignments", http.StatusFound, *vd.Alert)
+		return
+	}
+	// Weeks that were generated before have been announced
+	// already, so only a new week goes to the webhooks.
+	if created {
+		for _, assignment := range assignments {
+			EmitAssignment(a.dispatcher, a.as, webhook.EventAssignmentCreated, assignment.ID)
+		}
+	}
+	views.RedirectAlert(w, r, "/assignments", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: fmt.Sprintf("Rota for the week of %s has %d assignments.", models.WeekOf(week).Format(views.DateLayout), len(assignments)),
+	})
+}
+
+// StatusForm is used to move an assignment to a new status.
+type StatusForm struct {
+	Status string `schema:"status"`
+}
+
+// SetStatus starts, completes, skips, misses or reopens an
+// assignment on behalf of the current user.
+//
+// POST /assignments/:id/status
+func (a *Assignments) SetStatus(w http.ResponseWriter, r *http.Request) {
+	assignment, err := a.assignmentByID(w, r)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	vd.Yield = assignment
+	var form StatusForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		a.ShowView.Render(w, r, vd)
+		return
+	}
+	if !context.Membership(r.Context()).CanSetStatus(assignment, form.Status) {
+		forbidden(w, r)
+		return
+	}
+	user := context.User(r.Context())
+	if err := a.as.SetStatus(assignment, form.Status, user.ID); err != nil {
+		vd.SetAlert(err)
+		a.ShowView.Render(w, r, vd)
+		return
+	}
+	if assignment.Status == models.StatusDone {
+		EmitAssignment(a.dispatcher, a.as, webhook.EventAssignmentCompleted, assignment.ID)
+	}
+	a.redirectToShow(w, r, assignment)
+}
+
+// CheckForm is used to tick off or untick a checklist item.
+type CheckForm struct {
+	Checked bool `schema:"checked"`
+}
+
+// CheckItem ticks off or unticks one of the checklist items of
+// an assignment's job.
+//
+// POST /assignments/:id/items/:item_id/check
+func (a *Assignments) CheckItem(w http.ResponseWriter, r *http.Request) {
+	assignment, err := a.assignmentByID(w, r)
+	if err != nil {
+		return
+	}
+	if !context.Membership(r.Context()).CanWorkOn(assignment) {
+		forbidden(w, r)
+		return
+	}
+	var vd views.Data
+	vd.Yield = assignment
+	var form CheckForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		a.ShowView.Render(w, r, vd)
+		return
+	}
+	itemID, err := strconv.Atoi(mux.Vars(r)["item_id"])
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Invalid item ID", http.StatusNotFound)
+		return
+	}
+	user := context.User(r.Context())
+	err = a.as.CheckItem(assignment, uint(itemID), user.ID, form.Checked)
+	if err != nil {
+		vd.SetAlert(err)
+		a.ShowView.Render(w, r, vd)
+		return
+	}
+	a.redirectToShow(w, r, assignment)
+}
+
+func (a *Assignments) redirectToShow(w http.ResponseWriter, r *http.Request, assignment *models.Assignment) {
+	url, err := a.r.Get(ShowAssignment).URL("id",
+		strconv.Itoa(int(assignment.ID)))
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/assignments", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, url.Path, http.StatusFound)
+}
+
 // POST /assignments/:id/delete
 func (a *Assignments) Delete(w http.ResponseWriter, r *http.Request) {
 	assignment, err := a.assignmentByID(w, r)
 	if err != nil {
 		return
 	}
+	if !context.Membership(r.Context()).CanManageRota() {
+		forbidden(w, r)
+		return
+	}
 	var vd views.Data
 	err = a.as.Delete(assignment.ID)
 	if err != nil {
@@ -212,6 +451,10 @@ func (a *Assignments) assignmentByID(w http.ResponseWriter, r *http.Request) (*m
 		return nil, err
 	}
 	assignment, err := a.as.ByID(uint(id))
+	household := context.Household(r.Context())
+	if err == nil && assignment.HouseholdID != household.ID {
+		err = models.ErrNotFound
+	}
 	if err != nil {
 		switch err {
 		case models.ErrNotFound:
@@ -223,18 +466,39 @@ func (a *Assignments) assignmentByID(w http.ResponseWriter, r *http.Request) (*m
 		return nil, err
 	}
 
-	// job, err := a.as.Job(assignment)
-	// if err != nil {
-	// 	switch err {
-	// 	case models.ErrNotFound:
-	// 		http.Error(w, "Job (of assignemtn) not found", http.StatusNotFound)
-	// 	default:
-	// 		log.Println(err)
-	// 		http.Error(w, "Something went terribly wrong.", http.StatusInternalServerError)
-	// 	}
-	// 	return nil, err
-	// }
-	// assignment.Job = *job
-
 	return assignment, nil
 }
+
+// checkForm makes sure the job and the user posted in the form
+// both belong to the household.
+func (a *Assignments) checkForm(householdID uint, form *AssignmentForm) error {
+	return checkAssignment(a.js, a.hs, householdID, form.JobID, form.UserID)
+}
+
+// checkAssignment makes sure the job and the user of an
+// assignment both belong to the household.
+func checkAssignment(js models.JobService, hs models.HouseholdService, householdID, jobID, userID uint) error {
+	job, err := js.ByID(jobID)
+	if err == models.ErrNotFound || (err == nil && job.HouseholdID != householdID) {
+		return models.ErrJobInvalid
+	}
+	if err != nil {
+		return err
+	}
+	_, err = hs.Membership(householdID, userID)
+	if err == models.ErrNotFound {
+		return models.ErrNotMember
+	}
+	return err
+}
+
+// parseWeekStart parses a date from a form into the start of
+// the week it falls in. An empty string means no week at all.
+func parseWeekStart(s string) (*time.Time, error) {
+	t, err := parseDate(s)
+	if t == nil || err != nil {
+		return nil, err
+	}
+	weekStart := models.WeekOf(*t)
+	return &weekStart, nil
+}
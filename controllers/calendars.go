@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/ical"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+func NewCalendars(cs models.CalendarService, as models.AssignmentService, hs models.HouseholdService, tfs models.TwoFactorService, baseURL string) *Calendars {
+	return &Calendars{
+		NewView: views.NewView("layout", "calendars/new"),
+		cs:      cs,
+		as:      as,
+		hs:      hs,
+		tfs:     tfs,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+type Calendars struct {
+	NewView *views.View
+	cs      models.CalendarService
+	as      models.AssignmentService
+	hs      models.HouseholdService
+	tfs     models.TwoFactorService
+	baseURL string
+}
+
+// FeedLink is the address of one of the user's calendar feeds.
+type FeedLink struct {
+	Name string
+	URL  string
+}
+
+// CalendarLinksData is what the new calendar view expects as
+// its Yield.
+type CalendarLinksData struct {
+	Feeds []FeedLink
+}
+
+// POST /profile/calendar
+//
+// Only the hash of the token is stored, so the links are shown
+// once here and a new token is made every time.
+func (c *Calendars) Create(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	households, err := c.hs.ByUserID(user.ID)
+	if err != nil {
+		log.Println(err)
+		views.RedirectAlert(w, r, "/profile", http.StatusFound, views.Alert{
+			Level:   views.AlertLvlError,
+			Message: views.AlertMsgGeneric,
+		})
+		return
+	}
+	feed, err := c.cs.Regenerate(user.ID)
+	if err != nil {
+		log.Println(err)
+		views.RedirectAlert(w, r, "/profile", http.StatusFound, views.Alert{
+			Level:   views.AlertLvlError,
+			Message: views.AlertMsgGeneric,
+		})
+		return
+	}
+	data := CalendarLinksData{
+		Feeds: []FeedLink{{
+			Name: "Your assignments",
+			URL:  c.feedURL(feed.Token, ""),
+		}},
+	}
+	for _, household := range households {
+		data.Feeds = append(data.Feeds, FeedLink{
+			Name: "Everyone in " + household.Name,
+			URL:  c.feedURL(feed.Token, fmt.Sprintf("/households/%d", household.ID)),
+		})
+	}
+	var vd views.Data
+	vd.Yield = data
+	c.NewView.Render(w, r, vd)
+}
+
+// GET /calendar/{token}.ics
+func (c *Calendars) Show(w http.ResponseWriter, r *http.Request) {
+	feed, ok := c.feedByToken(w, r)
+	if !ok {
+		return
+	}
+	assignments, err := c.as.ByUserID(feed.UserID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	c.render(w, "Heartfort", assignments, false)
+}
+
+// GET /calendar/{token}/households/{id}.ics
+func (c *Calendars) Household(w http.ResponseWriter, r *http.Request) {
+	feed, ok := c.feedByToken(w, r)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid household ID", http.StatusNotFound)
+		return
+	}
+	// The feed only shows households its user is still in.
+	_, err = c.hs.Membership(uint(id), feed.UserID)
+	if err == models.ErrNotFound {
+		http.Error(w, "Household not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	household, err := c.hs.ByID(uint(id))
+	if err != nil {
+		http.Error(w, "Household not found", http.StatusNotFound)
+		return
+	}
+	allowed, err := c.tfs.Allowed(household, feed.UserID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, household.Name+" requires two-factor authentication.", http.StatusForbidden)
+		return
+	}
+	assignments, err := c.as.ByHouseholdID(household.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	c.render(w, household.Name, assignments, true)
+}
+
+// feedByToken looks up the feed from the token in the URL and
+// writes a 404 if there isn't one, so old links stop working
+// once the token is regenerated.
+func (c *Calendars) feedByToken(w http.ResponseWriter, r *http.Request) (*models.CalendarFeed, bool) {
+	token := mux.Vars(r)["token"]
+	feed, err := c.cs.ByToken(token)
+	if err == models.ErrNotFound {
+		http.Error(w, "Calendar not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return nil, false
+	}
+	return feed, true
+}
+
+// render writes the assignments out as a calendar with an
+// all-day event spanning the week of each one. The household
+// calendar names who has each job since it shows everyone's.
+func (c *Calendars) render(w http.ResponseWriter, name string, assignments []models.Assignment, withAssignee bool) {
+	calendar := ical.Calendar{Name: name}
+	host := "heartfort"
+	if u, err := url.Parse(c.baseURL); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	for _, a := range assignments {
+		if a.WeekStart == nil {
+			continue
+		}
+		event := ical.Event{
+			UID:         fmt.Sprintf("assignment-%d@%s", a.ID, host),
+			Summary:     a.Job.Name,
+			Description: a.StatusLabel(),
+			URL:         fmt.Sprintf("%s/assignments/%d", c.baseURL, a.ID),
+			Status:      ical.StatusConfirmed,
+			Start:       *a.WeekStart,
+			End:         a.WeekStart.AddDate(0, 0, 7),
+			Stamp:       a.UpdatedAt,
+		}
+		if withAssignee {
+			event.Summary += " (" + a.User.Name + ")"
+		}
+		if a.Status == models.StatusSkipped {
+			event.Status = ical.StatusCancelled
+		}
+		calendar.Events = append(calendar.Events, event)
+	}
+	w.Header().Set("Content-Type", ical.ContentType)
+	if _, err := calendar.WriteTo(w); err != nil {
+		log.Println(err)
+	}
+}
+
+func (c *Calendars) feedURL(token, path string) string {
+	return c.baseURL + "/calendar/" + url.PathEscape(token) + path + ".ics"
+}
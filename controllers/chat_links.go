@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+const (
+	IndexChatLink = "index_chat_link"
+)
+
+// NewChatLinks takes the bot's contact, who people message to
+// link their chat, which is empty when there is no bot.
+func NewChatLinks(cls models.ChatLinkService, contact string, r *mux.Router) *ChatLinks {
+	return &ChatLinks{
+		IndexView: views.NewView("layout", "chat_links/index"),
+		cls:       cls,
+		contact:   contact,
+		r:         r,
+	}
+}
+
+type ChatLinks struct {
+	IndexView *views.View
+	cls       models.ChatLinkService
+	contact   string
+	r         *mux.Router
+}
+
+// ChatLinkData is what the chat view expects as its Yield.
+// Created is the link with the code that was just made, the
+// only time the code can be shown.
+type ChatLinkData struct {
+	Contact string
+	Link    *models.ChatLink
+	Created *models.ChatLink
+}
+
+// GET /settings/chat
+func (cl *ChatLinks) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	cl.render(w, r, vd, nil)
+}
+
+// POST /settings/chat
+func (cl *ChatLinks) Create(w http.ResponseWriter, r *http.Request) {
+	if cl.contact == "" {
+		http.Error(w, "There is no chat bot", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	user := context.User(r.Context())
+	link, err := cl.cls.NewCode(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+		cl.render(w, r, vd, nil)
+		return
+	}
+	cl.render(w, r, vd, link)
+}
+
+// POST /settings/chat/unlink
+func (cl *ChatLinks) Delete(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if err := cl.cls.Unlink(user.ID); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		cl.render(w, r, vd, nil)
+		return
+	}
+	url, err := cl.r.Get(IndexChatLink).URL()
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Chat unlinked. The bot won't message you or take your commands anymore.",
+	})
+}
+
+func (cl *ChatLinks) render(w http.ResponseWriter, r *http.Request, vd views.Data, created *models.ChatLink) {
+	user := context.User(r.Context())
+	link, err := cl.cls.ByUserID(user.ID)
+	switch err {
+	case nil:
+	case models.ErrNotFound:
+		link = nil
+	default:
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	vd.Yield = ChatLinkData{
+		Contact: cl.contact,
+		Link:    link,
+		Created: created,
+	}
+	cl.IndexView.Render(w, r, vd)
+}
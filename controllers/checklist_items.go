@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+const (
+	IndexChecklistItems = "index_checklist_items"
+	EditChecklistItem   = "edit_checklist_item"
+)
+
+func NewChecklistItems(cs models.ChecklistItemService, js models.JobService, r *mux.Router) *ChecklistItems {
+	return &ChecklistItems{
+		IndexView: views.NewView("layout", "checklist_items/index"),
+		EditView:  views.NewView("layout", "checklist_items/edit"),
+		cs:        cs,
+		js:        js,
+		r:         r,
+	}
+}
+
+type ChecklistItems struct {
+	IndexView *views.View
+	EditView  *views.View
+	cs        models.ChecklistItemService
+	js        models.JobService
+	r         *mux.Router
+}
+
+type ChecklistItemForm struct {
+	Text     string `schema:"text"`
+	Note     string `schema:"note"`
+	Position int    `schema:"position"`
+}
+
+// ChecklistItemData is what the edit view expects as its
+// Yield.
+type ChecklistItemData struct {
+	Job  *models.Job
+	Item *models.ChecklistItem
+}
+
+// GET /jobs/:id/items
+func (c *ChecklistItems) Index(w http.ResponseWriter, r *http.Request) {
+	job, err := c.jobByID(w, r)
+	if err != nil {
+		return
+	}
+	if !context.Membership(r.Context()).CanManageJobs() {
+		forbidden(w, r)
+		return
+	}
+	var vd views.Data
+	vd.Yield = job
+	c.IndexView.Render(w, r, vd)
+}
+
+// POST /jobs/:id/items
+func (c *ChecklistItems) Create(w http.ResponseWriter, r *http.Request) {
+	job, err := c.jobByID(w, r)
+	if err != nil {
+		return
+	}
+	if !context.Membership(r.Context()).CanManageJobs() {
+		forbidden(w, r)
+		return
+	}
+	var vd views.Data
+	vd.Yield = job
+	var form ChecklistItemForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		c.IndexView.Render(w, r, vd)
+		return
+	}
+	item := models.ChecklistItem{
+		JobID:    job.ID,
+		Text:     form.Text,
+		Note:     form.Note,
+		Position: form.Position,
+	}
+	if err := c.cs.Create(&item); err != nil {
+		vd.SetAlert(err)
+		c.IndexView.Render(w, r, vd)
+		return
+	}
+	c.redirectToIndex(w, r, job)
+}
+
+// GET /jobs/:id/items/:item_id/edit
+func (c *ChecklistItems) Edit(w http.ResponseWriter, r *http.Request) {
+	job, item, err := c.itemByID(w, r)
+	if err != nil {
+		return
+	}
+	if !context.Membership(r.Context()).CanManageJobs() {
+		forbidden(w, r)
+		return
+	}
+	var vd views.Data
+	vd.Yield = ChecklistItemData{Job: job, Item: item}
+	c.EditView.Render(w, r, vd)
+}
+
+// POST /jobs/:id/items/:item_id/update
+func (c *ChecklistItems) Update(w http.ResponseWriter, r *http.Request) {
+	job, item, err := c.itemByID(w, r)
+	if err != nil {
+		return
+	}
+	if !context.Membership(r.Context()).CanManageJobs() {
+		forbidden(w, r)
+		return
+	}
+	var vd views.Data
+	vd.Yield = ChecklistItemData{Job: job, Item: item}
+	var form ChecklistItemForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		c.EditView.Render(w, r, vd)
+		return
+	}
+	item.Text = form.Text
+	item.Note = form.Note
+	item.Position = form.Position
+	if err := c.cs.Update(item); err != nil {
+		vd.SetAlert(err)
+		c.EditView.Render(w, r, vd)
+		return
+	}
+	c.redirectToIndex(w, r, job)
+}
+
+// POST /jobs/:id/items/:item_id/delete
+func (c *ChecklistItems) Delete(w http.ResponseWriter, r *http.Request) {
+	job, item, err := c.itemByID(w, r)
+	if err != nil {
+		return
+	}
+	if !context.Membership(r.Context()).CanManageJobs() {
+		forbidden(w, r)
+		return
+	}
+	if err := c.cs.Delete(item.ID); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		vd.Yield = ChecklistItemData{Job: job, Item: item}
+		c.EditView.Render(w, r, vd)
+		return
+	}
+	c.redirectToIndex(w, r, job)
+}
+
+func (c *ChecklistItems) redirectToIndex(w http.ResponseWriter, r *http.Request, job *models.Job) {
+	url, err := c.r.Get(IndexChecklistItems).URL("id",
+		strconv.Itoa(int(job.ID)))
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/jobs", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, url.Path, http.StatusFound)
+}
+
+func (c *ChecklistItems) jobByID(w http.ResponseWriter, r *http.Request) (*models.Job, error) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Invalid job ID", http.StatusNotFound)
+		return nil, err
+	}
+	job, err := c.js.ByID(uint(id))
+	household := context.Household(r.Context())
+	if err == nil && job.HouseholdID != household.ID {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Job not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went terribly wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// itemByID looks up the item in the URL and the job it belongs
+// to. Items are only found under their own job.
+func (c *ChecklistItems) itemByID(w http.ResponseWriter, r *http.Request) (*models.Job, *models.ChecklistItem, error) {
+	job, err := c.jobByID(w, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	vars := mux.Vars(r)
+	idStr := vars["item_id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Invalid item ID", http.StatusNotFound)
+		return nil, nil, err
+	}
+	item, err := c.cs.ByID(uint(id))
+	if err == nil && item.JobID != job.ID {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Item not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went terribly wrong.", http.StatusInternalServerError)
+		}
+		return nil, nil, err
+	}
+	return job, item, nil
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sirodoht/heartfort/email"
+	"github.com/sirodoht/heartfort/views"
+)
+
+// NewDev returns the controller for pages that only exist in
+// development, to help work on the site.
+func NewDev(emailer *email.Client) *Dev {
+	return &Dev{
+		EmailsView: views.NewView("layout", "dev/emails"),
+		emailer:    emailer,
+	}
+}
+
+type Dev struct {
+	EmailsView *views.View
+	emailer    *email.Client
+}
+
+// DevEmailsData is what the dev emails view expects as its
+// Yield.
+type DevEmailsData struct {
+	Names   []string
+	Locales []string
+}
+
+// EmailPreviewForm picks the locale and whether to show the
+// plain text or the HTML of a previewed email.
+type EmailPreviewForm struct {
+	Locale string `schema:"locale"`
+	Format string `schema:"format"`
+}
+
+// Emails lists every email that can be previewed.
+//
+// GET /dev/emails
+func (d *Dev) Emails(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	vd.Yield = DevEmailsData{
+		Names:   d.emailer.Previews(),
+		Locales: d.emailer.Locales(),
+	}
+	d.EmailsView.Render(w, r, vd)
+}
+
+// Email renders an email with made up data, as HTML or, with
+// format=text, as plain text.
+//
+// GET /dev/emails/:name
+func (d *Dev) Email(w http.ResponseWriter, r *http.Request) {
+	var form EmailPreviewForm
+	if err := parseURLParams(r, &form); err != nil {
+		log.Println(err)
+	}
+	message, ok, err := d.emailer.Preview(form.Locale, mux.Vars(r)["name"])
+	if !ok {
+		http.Error(w, "Email not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if form.Format == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "To: %s\nSubject: %s\n\n%s", message.To, message.Subject, message.Text)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<p><strong>To:</strong> %s<br>\n<strong>Subject:</strong> %s</p>\n<hr>\n%s",
+		html.EscapeString(message.To), html.EscapeString(message.Subject), message.HTML)
+}
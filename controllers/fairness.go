@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+// maxFairnessWeeks caps how far back the fairness page goes.
+const maxFairnessWeeks = 52
+
+func NewFairness(ls models.LedgerService, r *mux.Router) *Fairness {
+	return &Fairness{
+		IndexView: views.NewView("layout", "fairness/index"),
+		ls:        ls,
+		r:         r,
+	}
+}
+
+type Fairness struct {
+	IndexView *views.View
+	ls        models.LedgerService
+	r         *mux.Router
+}
+
+// FairnessForm is used to pick how many weeks of history the
+// fairness page shows.
+type FairnessForm struct {
+	Weeks int `schema:"weeks"`
+}
+
+// Index shows the points of everyone in the household so far
+// and over the last few weeks.
+//
+// GET /fairness
+func (f *Fairness) Index(w http.ResponseWriter, r *http.Request) {
+	var form FairnessForm
+	if err := parseURLParams(r, &form); err != nil {
+		log.Println(err)
+	}
+	if form.Weeks < 1 {
+		form.Weeks = models.FairnessWeeks
+	}
+	if form.Weeks > maxFairnessWeeks {
+		form.Weeks = maxFairnessWeeks
+	}
+	household := context.Household(r.Context())
+	fairness, err := f.ls.Fairness(household.ID, form.Weeks)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = fairness
+	f.IndexView.Render(w, r, vd)
+}
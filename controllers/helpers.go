@@ -3,8 +3,12 @@ package controllers
 import (
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
 	"github.com/gorilla/schema"
+
+	"github.com/sirodoht/heartfort/views"
 )
 
 func parseURLParams(r *http.Request, dst interface{}) error {
@@ -29,3 +33,30 @@ func parseValues(values url.Values, dst interface{}) error {
 	}
 	return nil
 }
+
+// parseDate parses a date posted by a date input. An empty
+// string means no date at all.
+func parseDate(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(views.DateLayout, s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+var (
+	forbiddenOnce sync.Once
+	forbiddenView *views.View
+)
+
+// forbidden renders the 403 page for when the current user's
+// role in the household doesn't allow what they tried to do.
+func forbidden(w http.ResponseWriter, r *http.Request) {
+	forbiddenOnce.Do(func() {
+		forbiddenView = views.NewView("layout", "static/forbidden")
+	})
+	forbiddenView.RenderStatus(w, r, http.StatusForbidden, nil)
+}
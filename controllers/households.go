@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/middleware"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+const (
+	IndexHouseholds = "index_households"
+)
+
+func NewHouseholds(hs models.HouseholdService, r *mux.Router) *Households {
+	return &Households{
+		IndexView: views.NewView("layout", "households/index"),
+		hs:        hs,
+		r:         r,
+	}
+}
+
+type Households struct {
+	IndexView *views.View
+	hs        models.HouseholdService
+	r         *mux.Router
+}
+
+type HouseholdForm struct {
+	Name string `schema:"name"`
+}
+
+// HouseholdsData is what the households index view expects as
+// its Yield.
+type HouseholdsData struct {
+	Households []models.Household
+	Active     *models.Household
+}
+
+// GET /households
+func (h *Households) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	if err := h.indexData(r, &vd); err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	h.IndexView.Render(w, r, vd)
+}
+
+// POST /households
+func (h *Households) Create(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form HouseholdForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		h.renderIndex(w, r, vd)
+		return
+	}
+	user := context.User(r.Context())
+	household := models.Household{
+		Name: form.Name,
+	}
+	if err := h.hs.CreateFor(&household, user.ID); err != nil {
+		vd.SetAlert(err)
+		h.renderIndex(w, r, vd)
+		return
+	}
+	setHouseholdCookie(w, household.ID)
+	http.Redirect(w, r, "/households", http.StatusFound)
+}
+
+// Switch makes the household in the URL the one the current
+// user is working in.
+//
+// POST /households/:id/switch
+func (h *Households) Switch(w http.ResponseWriter, r *http.Request) {
+	idStr := mux.Vars(r)["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Invalid household ID", http.StatusNotFound)
+		return
+	}
+	user := context.User(r.Context())
+	if _, err := h.hs.Membership(uint(id), user.ID); err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Household not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went terribly wrong.", http.StatusInternalServerError)
+		}
+		return
+	}
+	setHouseholdCookie(w, uint(id))
+	http.Redirect(w, r, "/assignments", http.StatusFound)
+}
+
+// renderIndex renders the index with an alert that is already
+// set on vd.
+func (h *Households) renderIndex(w http.ResponseWriter, r *http.Request, vd views.Data) {
+	if err := h.indexData(r, &vd); err != nil {
+		log.Println(err)
+	}
+	h.IndexView.Render(w, r, vd)
+}
+
+func (h *Households) indexData(r *http.Request, vd *views.Data) error {
+	user := context.User(r.Context())
+	households, err := h.hs.ByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	vd.Yield = HouseholdsData{
+		Households: households,
+		Active:     context.Household(r.Context()),
+	}
+	return nil
+}
+
+func setHouseholdCookie(w http.ResponseWriter, householdID uint) {
+	cookie := http.Cookie{
+		Name:     middleware.HouseholdCookie,
+		Value:    strconv.Itoa(int(householdID)),
+		Path:     "/",
+		HttpOnly: true,
+	}
+	http.SetCookie(w, &cookie)
+}
@@ -0,0 +1,305 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/email"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+const (
+	IndexMembers = "index_members"
+)
+
+func NewInvitations(is models.InvitationService, hs models.HouseholdService, tfs models.TwoFactorService, emailer *email.Client, r *mux.Router) *Invitations {
+	return &Invitations{
+		MembersView: views.NewView("layout", "invitations/members"),
+		is:          is,
+		hs:          hs,
+		tfs:         tfs,
+		emailer:     emailer,
+		r:           r,
+	}
+}
+
+type Invitations struct {
+	MembersView *views.View
+	is          models.InvitationService
+	hs          models.HouseholdService
+	tfs         models.TwoFactorService
+	emailer     *email.Client
+	r           *mux.Router
+}
+
+type InvitationForm struct {
+	Email string `schema:"email"`
+}
+
+// RoleForm is used to give a member of the household a new
+// role.
+type RoleForm struct {
+	Role string `schema:"role"`
+}
+
+// TwoFactorRequiredForm is used to make the members of the
+// household turn on two-factor authentication, or stop making
+// them.
+type TwoFactorRequiredForm struct {
+	Require bool `schema:"require"`
+}
+
+// MembersData is what the members view expects as its Yield.
+// Current is the membership of the user looking at the page.
+// TwoFactor holds the IDs of the members who have two-factor
+// authentication on.
+type MembersData struct {
+	Household   *models.Household
+	Members     []models.Membership
+	Invitations []models.Invitation
+	Current     *models.Membership
+	Roles       []string
+	Form        InvitationForm
+	TwoFactor   map[uint]bool
+}
+
+// Members lists the members of the current household along
+// with everyone who has been invited to it.
+//
+// GET /members
+func (i *Invitations) Members(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	if err := i.membersData(r, &vd, InvitationForm{}); err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	i.MembersView.Render(w, r, vd)
+}
+
+// POST /invitations
+func (i *Invitations) Create(w http.ResponseWriter, r *http.Request) {
+	if !context.Membership(r.Context()).CanManageMembers() {
+		forbidden(w, r)
+		return
+	}
+	var vd views.Data
+	var form InvitationForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		i.renderMembers(w, r, vd, form)
+		return
+	}
+	user := context.User(r.Context())
+	household := context.Household(r.Context())
+	invitation := models.Invitation{
+		HouseholdID: household.ID,
+		Email:       form.Email,
+		InvitedByID: user.ID,
+	}
+	if err := i.is.Create(&invitation); err != nil {
+		vd.SetAlert(err)
+		i.renderMembers(w, r, vd, form)
+		return
+	}
+	err := i.emailer.Invite(user.Locale, invitation.Email, user.Name, household.Name, invitation.Token)
+	if err != nil {
+		log.Println(err)
+		vd.AlertError("The invitation was created but we couldn't email it. Please revoke it and try again.")
+		i.renderMembers(w, r, vd, InvitationForm{})
+		return
+	}
+	i.redirectToMembers(w, r, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Invitation sent to " + invitation.Email + ".",
+	})
+}
+
+// POST /invitations/:id/revoke
+func (i *Invitations) Revoke(w http.ResponseWriter, r *http.Request) {
+	if !context.Membership(r.Context()).CanManageMembers() {
+		forbidden(w, r)
+		return
+	}
+	idStr := mux.Vars(r)["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Invalid invitation ID", http.StatusNotFound)
+		return
+	}
+	invitation, err := i.is.ByID(uint(id))
+	household := context.Household(r.Context())
+	if err == nil && invitation.HouseholdID != household.ID {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went terribly wrong.", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := i.is.Revoke(invitation); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		i.renderMembers(w, r, vd, InvitationForm{})
+		return
+	}
+	i.redirectToMembers(w, r, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Invitation revoked.",
+	})
+}
+
+// SetRole changes the role of a member of the current
+// household.
+//
+// POST /members/:user_id/role
+func (i *Invitations) SetRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Invalid user ID", http.StatusNotFound)
+		return
+	}
+	household := context.Household(r.Context())
+	membership, err := i.hs.Membership(household.ID, uint(userID))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Member not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went terribly wrong.", http.StatusInternalServerError)
+		}
+		return
+	}
+	if !context.Membership(r.Context()).CanChangeRole(membership) {
+		forbidden(w, r)
+		return
+	}
+	var vd views.Data
+	var form RoleForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		i.renderMembers(w, r, vd, InvitationForm{})
+		return
+	}
+	membership.Role = form.Role
+	if err := i.hs.UpdateMember(membership); err != nil {
+		vd.SetAlert(err)
+		i.renderMembers(w, r, vd, InvitationForm{})
+		return
+	}
+	i.redirectToMembers(w, r, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Role updated.",
+	})
+}
+
+// RequireTwoFactor makes the members of the current household
+// turn on two-factor authentication before they can use it, or
+// stops making them. Whoever requires it has to have it on
+// themselves, so they can't lock themselves out.
+//
+// POST /members/two-factor
+func (i *Invitations) RequireTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !context.Membership(r.Context()).CanRequireTwoFactor() {
+		forbidden(w, r)
+		return
+	}
+	var vd views.Data
+	var form TwoFactorRequiredForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		i.renderMembers(w, r, vd, InvitationForm{})
+		return
+	}
+	if form.Require {
+		user := context.User(r.Context())
+		enabled, err := i.tfs.Enabled(user.ID)
+		if err != nil {
+			vd.SetAlert(err)
+			i.renderMembers(w, r, vd, InvitationForm{})
+			return
+		}
+		if !enabled {
+			vd.AlertError("Turn on two-factor authentication for yourself first.")
+			i.renderMembers(w, r, vd, InvitationForm{})
+			return
+		}
+	}
+	household := context.Household(r.Context())
+	household.RequireTwoFactor = form.Require
+	if err := i.hs.Update(household); err != nil {
+		vd.SetAlert(err)
+		i.renderMembers(w, r, vd, InvitationForm{})
+		return
+	}
+	message := "Members no longer need two-factor authentication."
+	if form.Require {
+		message = "Members now need two-factor authentication to use the household."
+	}
+	i.redirectToMembers(w, r, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: message,
+	})
+}
+
+func (i *Invitations) redirectToMembers(w http.ResponseWriter, r *http.Request, alert views.Alert) {
+	url, err := i.r.Get(IndexMembers).URL()
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, alert)
+}
+
+// renderMembers renders the members page with an alert that is
+// already set on vd.
+func (i *Invitations) renderMembers(w http.ResponseWriter, r *http.Request, vd views.Data, form InvitationForm) {
+	if err := i.membersData(r, &vd, form); err != nil {
+		log.Println(err)
+	}
+	i.MembersView.Render(w, r, vd)
+}
+
+func (i *Invitations) membersData(r *http.Request, vd *views.Data, form InvitationForm) error {
+	household := context.Household(r.Context())
+	members, err := i.hs.Memberships(household.ID)
+	if err != nil {
+		return err
+	}
+	invitations, err := i.is.ByHouseholdID(household.ID)
+	if err != nil {
+		return err
+	}
+	twoFactor := map[uint]bool{}
+	for _, m := range members {
+		enabled, err := i.tfs.Enabled(m.UserID)
+		if err != nil {
+			return err
+		}
+		twoFactor[m.UserID] = enabled
+	}
+	vd.Yield = MembersData{
+		Household:   household,
+		Members:     members,
+		Invitations: invitations,
+		Current:     context.Membership(r.Context()),
+		Roles:       models.Roles,
+		Form:        form,
+		TwoFactor:   twoFactor,
+	}
+	return nil
+}
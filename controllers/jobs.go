@@ -7,8 +7,10 @@ import (
 
 	"github.com/gorilla/mux"
 
+	"github.com/sirodoht/heartfort/context"
 	"github.com/sirodoht/heartfort/models"
 	"github.com/sirodoht/heartfort/views"
+	"github.com/sirodoht/heartfort/webhook"
 )
 
 const (
@@ -17,33 +19,43 @@ const (
 	EditJob   = "edit_job"
 )
 
-func NewJobs(js models.JobService, r *mux.Router) *Jobs {
+func NewJobs(js models.JobService, dispatcher *webhook.Dispatcher, r *mux.Router) *Jobs {
 	return &Jobs{
-		New:       views.NewView("layout", "jobs/new"),
-		ShowView:  views.NewView("layout", "jobs/show"),
-		EditView:  views.NewView("layout", "jobs/edit"),
-		IndexView: views.NewView("layout", "jobs/index"),
-		js:        js,
-		r:         r,
+		New:        views.NewView("layout", "jobs/new"),
+		ShowView:   views.NewView("layout", "jobs/show"),
+		EditView:   views.NewView("layout", "jobs/edit"),
+		IndexView:  views.NewView("layout", "jobs/index"),
+		SpecsView:  views.NewView("layout", "static/specs"),
+		js:         js,
+		dispatcher: dispatcher,
+		r:          r,
 	}
 }
 
 type Jobs struct {
-	New       *views.View
-	ShowView  *views.View
-	EditView  *views.View
-	IndexView *views.View
-	js        models.JobService
-	r         *mux.Router
+	New        *views.View
+	ShowView   *views.View
+	EditView   *views.View
+	IndexView  *views.View
+	SpecsView  *views.View
+	js         models.JobService
+	dispatcher *webhook.Dispatcher
+	r          *mux.Router
 }
 
 type JobForm struct {
-	Name string `schema:"name"`
+	Name          string `schema:"name"`
+	IntervalUnit  string `schema:"interval_unit"`
+	IntervalCount int    `schema:"interval_count"`
+	AnchorDate    string `schema:"anchor_date"`
+	RRule         string `schema:"rrule"`
+	Effort        int    `schema:"effort"`
 }
 
 // GET /jobs
 func (j *Jobs) Index(w http.ResponseWriter, r *http.Request) {
-	jobs, err := j.js.List()
+	household := context.Household(r.Context())
+	jobs, err := j.js.ByHouseholdID(household.ID)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
@@ -54,6 +66,22 @@ func (j *Jobs) Index(w http.ResponseWriter, r *http.Request) {
 	j.IndexView.Render(w, r, vd)
 }
 
+// Specs shows every job along with its checklist.
+//
+// GET /specs
+func (j *Jobs) Specs(w http.ResponseWriter, r *http.Request) {
+	household := context.Household(r.Context())
+	jobs, err := j.js.ByHouseholdID(household.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = jobs
+	j.SpecsView.Render(w, r, vd)
+}
+
 // GET /jobs/:id
 func (j *Jobs) Show(w http.ResponseWriter, r *http.Request) {
 	job, err := j.jobByID(w, r)
@@ -71,6 +99,10 @@ func (j *Jobs) Edit(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	if !context.Membership(r.Context()).CanManageJobs() {
+		forbidden(w, r)
+		return
+	}
 	var vd views.Data
 	vd.Yield = job
 	j.EditView.Render(w, r, vd)
@@ -82,6 +114,10 @@ func (j *Jobs) Update(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	if !context.Membership(r.Context()).CanManageJobs() {
+		forbidden(w, r)
+		return
+	}
 	var vd views.Data
 	vd.Yield = job
 	var form JobForm
@@ -90,11 +126,23 @@ func (j *Jobs) Update(w http.ResponseWriter, r *http.Request) {
 		j.EditView.Render(w, r, vd)
 		return
 	}
+	anchorDate, err := parseDate(form.AnchorDate)
+	if err != nil {
+		vd.AlertError("Starting date must be a date")
+		j.EditView.Render(w, r, vd)
+		return
+	}
 	job.Name = form.Name
+	job.IntervalUnit = form.IntervalUnit
+	job.IntervalCount = form.IntervalCount
+	job.AnchorDate = anchorDate
+	job.RRule = form.RRule
+	job.Effort = form.Effort
 	err = j.js.Update(job)
 	if err != nil {
 		vd.SetAlert(err)
 	} else {
+		emitEvent(j.dispatcher, job.HouseholdID, webhook.EventJobUpdated, newAPIJob(job))
 		vd.Alert = &views.Alert{
 			Level:   views.AlertLvlSuccess,
 			Message: "Job successfully updated!",
@@ -105,6 +153,10 @@ func (j *Jobs) Update(w http.ResponseWriter, r *http.Request) {
 
 // POST /jobs
 func (j *Jobs) Create(w http.ResponseWriter, r *http.Request) {
+	if !context.Membership(r.Context()).CanManageJobs() {
+		forbidden(w, r)
+		return
+	}
 	var vd views.Data
 	var form JobForm
 	if err := parseForm(r, &form); err != nil {
@@ -112,8 +164,21 @@ func (j *Jobs) Create(w http.ResponseWriter, r *http.Request) {
 		j.New.Render(w, r, vd)
 		return
 	}
+	anchorDate, err := parseDate(form.AnchorDate)
+	if err != nil {
+		vd.AlertError("Starting date must be a date")
+		j.New.Render(w, r, vd)
+		return
+	}
+	household := context.Household(r.Context())
 	job := models.Job{
-		Name: form.Name,
+		HouseholdID:   household.ID,
+		Name:          form.Name,
+		IntervalUnit:  form.IntervalUnit,
+		IntervalCount: form.IntervalCount,
+		AnchorDate:    anchorDate,
+		RRule:         form.RRule,
+		Effort:        form.Effort,
 	}
 	if err := j.js.Create(&job); err != nil {
 		vd.SetAlert(err)
@@ -137,6 +202,10 @@ func (j *Jobs) Delete(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	if !context.Membership(r.Context()).CanManageJobs() {
+		forbidden(w, r)
+		return
+	}
 	var vd views.Data
 	err = j.js.Delete(job.ID)
 	if err != nil {
@@ -164,6 +233,10 @@ func (j *Jobs) jobByID(w http.ResponseWriter,
 		return nil, err
 	}
 	job, err := j.js.ByID(uint(id))
+	household := context.Household(r.Context())
+	if err == nil && job.HouseholdID != household.ID {
+		err = models.ErrNotFound
+	}
 	if err != nil {
 		switch err {
 		case models.ErrNotFound:
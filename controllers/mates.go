@@ -1,14 +1,19 @@
 package controllers
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/email"
 	"github.com/sirodoht/heartfort/models"
 	"github.com/sirodoht/heartfort/views"
+	"github.com/sirodoht/heartfort/webhook"
 )
 
 const (
@@ -17,33 +22,100 @@ const (
 	EditMate   = "edit_mate"
 )
 
-func NewMates(ms models.MateService, r *mux.Router) *Mates {
+// KindMateConfirmation is the kind of notification recorded
+// for confirmation emails, so signing up over and over doesn't
+// flood someone's inbox.
+const KindMateConfirmation = "mate_confirmation"
+
+func NewMates(ms models.MateService, hs models.HouseholdService, ns models.NotificationService, emailer *email.Client, dispatcher *webhook.Dispatcher, r *mux.Router) *Mates {
 	return &Mates{
-		New:       views.NewView("layout", "mates/new"),
-		ShowView:  views.NewView("layout", "mates/show"),
-		EditView:  views.NewView("layout", "mates/edit"),
-		IndexView: views.NewView("layout", "mates/index"),
-		ms:        ms,
-		r:         r,
+		NewView:         views.NewView("layout", "mates/new"),
+		ShowView:        views.NewView("layout", "mates/show"),
+		EditView:        views.NewView("layout", "mates/edit"),
+		IndexView:       views.NewView("layout", "mates/index"),
+		ConfirmedView:   views.NewView("layout", "mates/confirmed"),
+		UnsubscribeView: views.NewView("layout", "mates/unsubscribe"),
+		ms:              ms,
+		hs:              hs,
+		ns:              ns,
+		emailer:         emailer,
+		dispatcher:      dispatcher,
+		r:               r,
 	}
 }
 
 type Mates struct {
-	New       *views.View
-	ShowView  *views.View
-	EditView  *views.View
-	IndexView *views.View
-	ms        models.MateService
-	r         *mux.Router
+	NewView         *views.View
+	ShowView        *views.View
+	EditView        *views.View
+	IndexView       *views.View
+	ConfirmedView   *views.View
+	UnsubscribeView *views.View
+	ms              models.MateService
+	hs              models.HouseholdService
+	ns              models.NotificationService
+	emailer         *email.Client
+	dispatcher      *webhook.Dispatcher
+	r               *mux.Router
 }
 
+// MateForm is used to sign a mate up, to the household whose
+// signup token it has, or to change their email.
 type MateForm struct {
-	Email string `schema:"email"`
+	SignupToken string `schema:"signup_token"`
+	Email       string `schema:"email"`
+}
+
+// MateTokenForm carries the signed token from a confirm or
+// unsubscribe link.
+type MateTokenForm struct {
+	Token string `schema:"token"`
+}
+
+// UnsubscribeData is what the unsubscribe view expects as its
+// Yield. Until Done it asks to confirm unsubscribing with Token.
+type UnsubscribeData struct {
+	Household *models.Household
+	Token     string
+	Done      bool
+}
+
+// NotificationsForm is used to pick the household whose
+// notifications are being signed up for, by its signup token
+// rather than its ID so that households can't be guessed.
+type NotificationsForm struct {
+	SignupToken string `schema:"signup"`
+}
+
+// New shows the notifications sign up form of the household
+// whose signup token is in the URL, or the current user's
+// household.
+//
+// GET /notifications
+func (m *Mates) New(w http.ResponseWriter, r *http.Request) {
+	var form NotificationsForm
+	if err := parseURLParams(r, &form); err != nil {
+		log.Println(err)
+	}
+	if form.SignupToken == "" {
+		if household := context.Household(r.Context()); household != nil {
+			form.SignupToken = household.SignupToken
+		}
+	}
+	household, err := m.hs.BySignupToken(form.SignupToken)
+	if err != nil {
+		http.Error(w, "Household not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	vd.Yield = household
+	m.NewView.Render(w, r, vd)
 }
 
 // GET /mates
 func (m *Mates) Index(w http.ResponseWriter, r *http.Request) {
-	mates, err := m.ms.List()
+	household := context.Household(r.Context())
+	mates, err := m.ms.ByHouseholdID(household.ID)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
@@ -71,6 +143,10 @@ func (m *Mates) Edit(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	if !context.Membership(r.Context()).CanManageMates() {
+		forbidden(w, r)
+		return
+	}
 	var vd views.Data
 	vd.Yield = mate
 	m.EditView.Render(w, r, vd)
@@ -82,6 +158,10 @@ func (m *Mates) Update(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	if !context.Membership(r.Context()).CanManageMates() {
+		forbidden(w, r)
+		return
+	}
 	var vd views.Data
 	vd.Yield = mate
 	var form MateForm
@@ -90,11 +170,19 @@ func (m *Mates) Update(w http.ResponseWriter, r *http.Request) {
 		m.EditView.Render(w, r, vd)
 		return
 	}
+	// A new address has to be confirmed by whoever owns it.
+	changed := mate.Email != form.Email
 	mate.Email = form.Email
+	if changed {
+		mate.Status = models.MatePending
+	}
 	err = m.ms.Update(mate)
 	if err != nil {
 		vd.SetAlert(err)
 	} else {
+		if changed {
+			sendMateConfirmation(m.ms, m.ns, m.emailer, mate, context.Household(r.Context()))
+		}
 		vd.Alert = &views.Alert{
 			Level:   views.AlertLvlSuccess,
 			Message: "Mate successfully updated!",
@@ -108,25 +196,133 @@ func (m *Mates) Create(w http.ResponseWriter, r *http.Request) {
 	var vd views.Data
 	var form MateForm
 	if err := parseForm(r, &form); err != nil {
-		vd.SetAlert(err)
-		m.New.Render(w, r, vd)
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusBadRequest)
 		return
 	}
-	mate := models.Mate{
-		Email: form.Email,
+	household, err := m.hs.BySignupToken(form.SignupToken)
+	if err != nil {
+		http.Error(w, "Household not found", http.StatusNotFound)
+		return
 	}
-	if err := m.ms.Create(&mate); err != nil {
+	vd.Yield = household
+	mate, err := m.ms.Subscribe(household.ID, form.Email)
+	if err != nil {
 		vd.SetAlert(err)
-		m.New.Render(w, r, vd)
+		m.NewView.Render(w, r, vd)
 		return
 	}
+	// Someone already confirmed gets the same answer as anyone
+	// else so the form doesn't give away who is signed up.
+	if mate.Status != models.MateConfirmed {
+		sendMateConfirmation(m.ms, m.ns, m.emailer, mate, household)
+	}
 
 	vd.Alert = &views.Alert{
 		Level:   views.AlertLvlSuccess,
-		Message: "Email registered.",
+		Message: "Almost there! Please follow the link we emailed you to confirm.",
 	}
 
-	m.New.Render(w, r, vd)
+	m.NewView.Render(w, r, vd)
+}
+
+// GET /mates/confirm
+func (m *Mates) Confirm(w http.ResponseWriter, r *http.Request) {
+	var form MateTokenForm
+	if err := parseURLParams(r, &form); err != nil {
+		log.Println(err)
+	}
+	mate, confirmed, err := m.ms.Confirm(form.Token)
+	if err != nil {
+		m.tokenError(w, err)
+		return
+	}
+	if confirmed {
+		emitEvent(m.dispatcher, mate.HouseholdID, webhook.EventMateSubscribed, newAPIMate(mate))
+	}
+	household, err := m.hs.ByID(mate.HouseholdID)
+	if err != nil {
+		http.Error(w, "Household not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	vd.Yield = household
+	m.ConfirmedView.Render(w, r, vd)
+}
+
+// Unsubscribe asks to confirm unsubscribing, so that link
+// scanners opening the link in an email don't unsubscribe
+// anyone.
+//
+// GET /mates/unsubscribe
+func (m *Mates) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	var form MateTokenForm
+	if err := parseURLParams(r, &form); err != nil {
+		log.Println(err)
+	}
+	var vd views.Data
+	vd.Yield = UnsubscribeData{Token: form.Token}
+	m.UnsubscribeView.Render(w, r, vd)
+}
+
+// DoUnsubscribe is both the form on the unsubscribe page and
+// the one-click unsubscribe of the List-Unsubscribe header,
+// which posts to the link in the email without a CSRF token.
+//
+// POST /mates/unsubscribe
+func (m *Mates) DoUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	var form MateTokenForm
+	if err := parseURLParams(r, &form); err != nil {
+		log.Println(err)
+	}
+	mate, _, err := m.ms.Unsubscribe(form.Token)
+	if err != nil {
+		m.tokenError(w, err)
+		return
+	}
+	data := UnsubscribeData{Done: true}
+	if household, err := m.hs.ByID(mate.HouseholdID); err == nil {
+		data.Household = household
+	}
+	var vd views.Data
+	vd.Yield = data
+	m.UnsubscribeView.Render(w, r, vd)
+}
+
+// sendMateConfirmation emails the mate a link to confirm their
+// sign up, at most once a day. Failing to email is only
+// logged, signing up again sends another one.
+func sendMateConfirmation(ms models.MateService, ns models.NotificationService, emailer *email.Client, mate *models.Mate, household *models.Household) {
+	notification := models.Notification{
+		Kind:  KindMateConfirmation,
+		Key:   fmt.Sprintf("mate:%d:%s:%s", mate.ID, mate.Email, time.Now().UTC().Format(views.DateLayout)),
+		Email: mate.Email,
+	}
+	switch err := ns.Claim(&notification); err {
+	case nil:
+	case models.ErrAlreadySent:
+		return
+	default:
+		log.Println(err)
+		return
+	}
+	err := emailer.ConfirmMate(emailer.DefaultLocale(), mate.Email, household.Name, ms.ConfirmToken(mate))
+	if err != nil {
+		log.Println(err)
+		if err := ns.Release(&notification); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (m *Mates) tokenError(w http.ResponseWriter, err error) {
+	switch err {
+	case models.ErrTokenInvalid:
+		http.Error(w, "This link is not valid. It may be out of date.", http.StatusNotFound)
+	default:
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+	}
 }
 
 // POST /mates/:id/delete
@@ -135,6 +331,10 @@ func (m *Mates) Delete(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	if !context.Membership(r.Context()).CanManageMates() {
+		forbidden(w, r)
+		return
+	}
 	var vd views.Data
 	err = m.ms.Delete(mate.ID)
 	if err != nil {
@@ -161,6 +361,10 @@ func (m *Mates) mateById(w http.ResponseWriter, r *http.Request) (*models.Mate,
 		return nil, err
 	}
 	mate, err := m.ms.ByID(uint(id))
+	household := context.Household(r.Context())
+	if err == nil && mate.HouseholdID != household.ID {
+		err = models.ErrNotFound
+	}
 	if err != nil {
 		switch err {
 		case models.ErrNotFound:
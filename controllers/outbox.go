@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+const (
+	IndexOutbox = "index_outbox"
+)
+
+func NewOutbox(obs models.OutboxService, r *mux.Router) *Outbox {
+	return &Outbox{
+		IndexView: views.NewView("layout", "outbox/index"),
+		obs:       obs,
+		r:         r,
+	}
+}
+
+type Outbox struct {
+	IndexView *views.View
+	obs       models.OutboxService
+	r         *mux.Router
+}
+
+// Index lists the emails the outbox gave up on.
+//
+// GET /admin/outbox
+func (o *Outbox) Index(w http.ResponseWriter, r *http.Request) {
+	messages, err := o.obs.Dead()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	var vd views.Data
+	vd.Yield = messages
+	o.IndexView.Render(w, r, vd)
+}
+
+// POST /admin/outbox/:id/resend
+func (o *Outbox) Resend(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Invalid message ID", http.StatusNotFound)
+		return
+	}
+	if err := o.obs.Resend(uint(id)); err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Message not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went terribly wrong.", http.StatusInternalServerError)
+		}
+		return
+	}
+	url, err := o.r.Get(IndexOutbox).URL()
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Message queued to be sent again.",
+	})
+}
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/middleware"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+const (
+	IndexSessions = "index_sessions"
+)
+
+// NewSessions takes whether the site is served over HTTPS only,
+// so the session cookie is never sent without it.
+func NewSessions(ss models.SessionService, secure bool, r *mux.Router) *Sessions {
+	return &Sessions{
+		IndexView: views.NewView("layout", "sessions/index"),
+		ss:        ss,
+		secure:    secure,
+		r:         r,
+	}
+}
+
+type Sessions struct {
+	IndexView *views.View
+	ss        models.SessionService
+	secure    bool
+	r         *mux.Router
+}
+
+// SessionsData is what the devices view expects as its Yield.
+// CurrentID is the session of the device looking at the page.
+type SessionsData struct {
+	Sessions  []models.Session
+	CurrentID uint
+}
+
+// GET /settings/sessions
+func (s *Sessions) Index(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	sessions, err := s.ss.ByUserID(user.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	data := SessionsData{Sessions: sessions}
+	if session := context.Session(r.Context()); session != nil {
+		data.CurrentID = session.ID
+	}
+	var vd views.Data
+	vd.Yield = data
+	s.IndexView.Render(w, r, vd)
+}
+
+// POST /settings/sessions/:id/revoke
+func (s *Sessions) Revoke(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Invalid session ID", http.StatusNotFound)
+		return
+	}
+	session, err := s.ss.ByID(uint(id))
+	user := context.User(r.Context())
+	if err == nil && session.UserID != user.ID {
+		err = models.ErrNotFound
+	}
+	if err == nil {
+		err = s.ss.Delete(session.ID)
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Session not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went terribly wrong.", http.StatusInternalServerError)
+		}
+		return
+	}
+	if current := context.Session(r.Context()); current != nil && current.ID == session.ID {
+		clearSessionCookie(w, s.secure)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	url, err := s.r.Get(IndexSessions).URL()
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Device logged out.",
+	})
+}
+
+// LogoutAll logs the current user out on every device, this one
+// included.
+//
+// POST /settings/sessions/logout
+func (s *Sessions) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if err := s.ss.DeleteByUserID(user.ID); err != nil {
+		log.Println(err)
+		http.Error(w, "Something went terribly wrong.", http.StatusInternalServerError)
+		return
+	}
+	clearSessionCookie(w, s.secure)
+	views.RedirectAlert(w, r, "/login", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "You have been logged out everywhere.",
+	})
+}
+
+// setSessionCookie logs the browser in with the session until
+// the session expires.
+func setSessionCookie(w http.ResponseWriter, session *models.Session, secure bool) {
+	cookie := http.Cookie{
+		Name:     middleware.SessionCookie,
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, &cookie)
+}
+
+func clearSessionCookie(w http.ResponseWriter, secure bool) {
+	cookie := http.Cookie{
+		Name:     middleware.SessionCookie,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, &cookie)
+}
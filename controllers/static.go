@@ -4,12 +4,10 @@ import "github.com/sirodoht/heartfort/views"
 
 func NewStatic() *Static {
 	return &Static{
-		Home:  views.NewView("layout", "static/home"),
-		Specs: views.NewView("layout", "static/specs"),
+		Home: views.NewView("layout", "static/home"),
 	}
 }
 
 type Static struct {
-	Home  *views.View
-	Specs *views.View
+	Home *views.View
 }
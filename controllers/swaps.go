@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/email"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+	"github.com/sirodoht/heartfort/webhook"
+)
+
+func NewSwaps(ss models.SwapService, as models.AssignmentService, hs models.HouseholdService, emailer *email.Client, dispatcher *webhook.Dispatcher, r *mux.Router) *Swaps {
+	return &Swaps{
+		NewView:    views.NewView("layout", "swaps/new"),
+		ss:         ss,
+		as:         as,
+		hs:         hs,
+		emailer:    emailer,
+		dispatcher: dispatcher,
+		r:          r,
+	}
+}
+
+type Swaps struct {
+	NewView    *views.View
+	ss         models.SwapService
+	as         models.AssignmentService
+	hs         models.HouseholdService
+	emailer    *email.Client
+	dispatcher *webhook.Dispatcher
+	r          *mux.Router
+}
+
+// SwapForm is used to offer an assignment up. An empty
+// WithAssignmentID asks anyone in the household to take it.
+type SwapForm struct {
+	WithAssignmentID uint `schema:"with_assignment_id"`
+}
+
+// SwappedEvent is the data of the assignment.swapped webhook
+// event, with the assignments as they are after the swap.
+// WithAssignment is only there for a trade.
+type SwappedEvent struct {
+	SwapID         uint           `json:"swap_id"`
+	Assignment     APIAssignment  `json:"assignment"`
+	WithAssignment *APIAssignment `json:"with_assignment"`
+}
+
+// SwapData is what the new swap view expects as its Yield.
+// Candidates are the assignments the swap could be a trade for.
+type SwapData struct {
+	Assignment *models.Assignment
+	Candidates []models.Assignment
+}
+
+// GET /assignments/:id/swap
+func (s *Swaps) New(w http.ResponseWriter, r *http.Request) {
+	assignment, err := s.assignmentByID(w, r)
+	if err != nil {
+		return
+	}
+	if !context.Membership(r.Context()).CanSwap(assignment) {
+		forbidden(w, r)
+		return
+	}
+	var vd views.Data
+	s.renderNew(w, r, vd, assignment)
+}
+
+// POST /assignments/:id/swap
+func (s *Swaps) Create(w http.ResponseWriter, r *http.Request) {
+	assignment, err := s.assignmentByID(w, r)
+	if err != nil {
+		return
+	}
+	if !context.Membership(r.Context()).CanSwap(assignment) {
+		forbidden(w, r)
+		return
+	}
+	var vd views.Data
+	var form SwapForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		s.renderNew(w, r, vd, assignment)
+		return
+	}
+	user := context.User(r.Context())
+	swap := models.Swap{
+		AssignmentID:  assignment.ID,
+		RequestedByID: user.ID,
+	}
+	if form.WithAssignmentID != 0 {
+		swap.WithAssignmentID = &form.WithAssignmentID
+	}
+	if err := s.ss.Propose(&swap); err != nil {
+		vd.SetAlert(err)
+		s.renderNew(w, r, vd, assignment)
+		return
+	}
+	s.notifyProposed(swap.ID)
+	views.RedirectAlert(w, r, "/assignments", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Swap requested.",
+	})
+}
+
+// POST /swaps/:id/accept
+func (s *Swaps) Accept(w http.ResponseWriter, r *http.Request) {
+	s.answer(w, r, s.ss.Accept, "Swap accepted.")
+}
+
+// POST /swaps/:id/decline
+func (s *Swaps) Decline(w http.ResponseWriter, r *http.Request) {
+	s.answer(w, r, s.ss.Decline, "Swap declined.")
+}
+
+// POST /swaps/:id/cancel
+func (s *Swaps) Cancel(w http.ResponseWriter, r *http.Request) {
+	s.answer(w, r, s.ss.Cancel, "Swap cancelled.")
+}
+
+// answer runs one of the SwapService answers for the current
+// user on the swap in the URL and lets everyone involved know.
+func (s *Swaps) answer(w http.ResponseWriter, r *http.Request, fn func(*models.Swap, uint) error, message string) {
+	swap, err := s.swapByID(w, r)
+	if err != nil {
+		return
+	}
+	if !context.Membership(r.Context()).CanAnswerSwaps() {
+		forbidden(w, r)
+		return
+	}
+	user := context.User(r.Context())
+	if err := fn(swap, user.ID); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		views.RedirectAlert(w, r, "/assignments", http.StatusFound, *vd.Alert)
+		return
+	}
+	s.notifyAnswered(swap, user)
+	if swap.Status == models.SwapAccepted {
+		s.emitSwapped(swap)
+	}
+	views.RedirectAlert(w, r, "/assignments", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: message,
+	})
+}
+
+// notifyProposed emails whoever can answer the swap: the person
+// asked to trade, or everyone else in the household who can
+// take the assignment on. Failing to email doesn't undo the
+// swap so errors are only logged.
+func (s *Swaps) notifyProposed(id uint) {
+	swap, err := s.ss.ByID(id)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	week := weekLabel(&swap.Assignment)
+	var withJobName string
+	var recipients []models.User
+	if swap.WithAssignment != nil {
+		withJobName = swap.WithAssignment.Job.Name
+		recipients = append(recipients, *swap.ToUser)
+	} else {
+		memberships, err := s.hs.Memberships(swap.HouseholdID)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		for _, m := range memberships {
+			if m.UserID != swap.RequestedByID && m.CanAnswerSwaps() {
+				recipients = append(recipients, m.User)
+			}
+		}
+	}
+	for _, to := range recipients {
+		err := s.emailer.SwapProposed(to.Locale, to.Name, to.Email, swap.RequestedBy.Name,
+			swap.Assignment.Job.Name, withJobName, week)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// notifyAnswered emails both the person who asked for the swap
+// and the other party about how it was answered.
+func (s *Swaps) notifyAnswered(swap *models.Swap, answeredBy *models.User) {
+	recipients := []models.User{swap.RequestedBy}
+	switch {
+	case answeredBy.ID != swap.RequestedByID:
+		recipients = append(recipients, *answeredBy)
+	case swap.ToUser != nil:
+		recipients = append(recipients, *swap.ToUser)
+	}
+	week := weekLabel(&swap.Assignment)
+	for _, to := range recipients {
+		err := s.emailer.SwapAnswered(to.Locale, to.Name, to.Email, answeredBy.Name,
+			swap.Status, swap.Assignment.Job.Name, week)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// emitSwapped tells the household's webhooks who has which
+// assignment now that the swap went through.
+func (s *Swaps) emitSwapped(swap *models.Swap) {
+	assignment, err := s.as.ByID(swap.AssignmentID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	event := SwappedEvent{
+		SwapID:     swap.ID,
+		Assignment: newAPIAssignment(assignment),
+	}
+	if swap.WithAssignmentID != nil {
+		with, err := s.as.ByID(*swap.WithAssignmentID)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		apiWith := newAPIAssignment(with)
+		event.WithAssignment = &apiWith
+	}
+	emitEvent(s.dispatcher, swap.HouseholdID, webhook.EventAssignmentSwapped, event)
+}
+
+// renderNew renders the new swap form with an alert that may
+// already be set on vd.
+func (s *Swaps) renderNew(w http.ResponseWriter, r *http.Request, vd views.Data, assignment *models.Assignment) {
+	data := SwapData{Assignment: assignment}
+	if assignment.WeekStart != nil {
+		week, err := s.as.ByWeek(assignment.HouseholdID, *assignment.WeekStart)
+		if err != nil {
+			log.Println(err)
+		}
+		for _, candidate := range week {
+			if candidate.UserID != assignment.UserID && candidate.Swappable() {
+				data.Candidates = append(data.Candidates, candidate)
+			}
+		}
+	}
+	vd.Yield = data
+	s.NewView.Render(w, r, vd)
+}
+
+func (s *Swaps) assignmentByID(w http.ResponseWriter, r *http.Request) (*models.Assignment, error) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Invalid assignment ID", http.StatusNotFound)
+		return nil, err
+	}
+	assignment, err := s.as.ByID(uint(id))
+	household := context.Household(r.Context())
+	if err == nil && assignment.HouseholdID != household.ID {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Assignment not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went terribly wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return assignment, nil
+}
+
+func (s *Swaps) swapByID(w http.ResponseWriter, r *http.Request) (*models.Swap, error) {
+	vars := mux.Vars(r)
+	idStr := vars["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Invalid swap ID", http.StatusNotFound)
+		return nil, err
+	}
+	swap, err := s.ss.ByID(uint(id))
+	household := context.Household(r.Context())
+	if err == nil && swap.HouseholdID != household.ID {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Swap not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went terribly wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return swap, nil
+}
+
+// weekLabel is the date the week of an assignment starts on
+// for emails, or empty if it isn't in any particular week.
+func weekLabel(a *models.Assignment) string {
+	if a.WeekStart == nil {
+		return ""
+	}
+	return a.WeekStart.Format(views.DateLayout)
+}
@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"rsc.io/qr"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+const (
+	IndexTwoFactor = "index_two_factor"
+)
+
+func NewTwoFactors(tfs models.TwoFactorService, r *mux.Router) *TwoFactors {
+	return &TwoFactors{
+		IndexView: views.NewView("layout", "two_factors/index"),
+		tfs:       tfs,
+		r:         r,
+	}
+}
+
+type TwoFactors struct {
+	IndexView *views.View
+	tfs       models.TwoFactorService
+	r         *mux.Router
+}
+
+// TwoFactorForm is used to enter a code from the user's app, or
+// one of their recovery codes.
+type TwoFactorForm struct {
+	Code string `schema:"code"`
+}
+
+// TwoFactorData is what the two-factor view expects as its
+// Yield. TwoFactor is nil until the user starts setting it up.
+// RecoveryCodes are the codes that were just made, the only
+// time they can be shown.
+type TwoFactorData struct {
+	TwoFactor         *models.TwoFactor
+	RecoveryCodes     []string
+	RecoveryCodesLeft int
+}
+
+// GET /settings/2fa
+func (tf *TwoFactors) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	tf.render(w, r, vd, nil)
+}
+
+// Create starts setting up an authenticator app with a new
+// secret.
+//
+// POST /settings/2fa
+func (tf *TwoFactors) Create(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if _, err := tf.tfs.Begin(user.ID); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		tf.render(w, r, vd, nil)
+		return
+	}
+	tf.redirectToIndex(w, r, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Scan the QR code with your authenticator app, then enter the code it shows.",
+	})
+}
+
+// QR is the QR code an authenticator app scans to set itself
+// up with the secret the user is setting up.
+//
+// GET /settings/2fa/qr.png
+func (tf *TwoFactors) QR(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	twoFactor, err := tf.tfs.ByUserID(user.ID)
+	if err == nil && twoFactor.Enabled() {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.NotFound(w, r)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		}
+		return
+	}
+	code, err := qr.Encode(twoFactor.URI(user.Email), qr.M)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	// The QR code is the secret, so it mustn't be kept anywhere.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(code.PNG())
+}
+
+// Enable turns two-factor authentication on once the user has
+// entered the first code from their app, and shows their
+// recovery codes.
+//
+// POST /settings/2fa/enable
+func (tf *TwoFactors) Enable(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form TwoFactorForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		tf.render(w, r, vd, nil)
+		return
+	}
+	user := context.User(r.Context())
+	codes, err := tf.tfs.Enable(user.ID, form.Code)
+	if err != nil {
+		vd.SetAlert(err)
+		tf.render(w, r, vd, nil)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Two-factor authentication is on. Save your recovery codes now, they won't be shown again.",
+	}
+	tf.render(w, r, vd, codes)
+}
+
+// RecoveryCodes replaces the user's recovery codes once they
+// have entered a code.
+//
+// POST /settings/2fa/recovery
+func (tf *TwoFactors) RecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form TwoFactorForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		tf.render(w, r, vd, nil)
+		return
+	}
+	user := context.User(r.Context())
+	if err := tf.tfs.Verify(user.ID, form.Code); err != nil {
+		vd.SetAlert(err)
+		tf.render(w, r, vd, nil)
+		return
+	}
+	codes, err := tf.tfs.NewRecoveryCodes(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+		tf.render(w, r, vd, nil)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Here are your new recovery codes. The old ones don't work anymore.",
+	}
+	tf.render(w, r, vd, codes)
+}
+
+// Disable turns two-factor authentication off once the user has
+// entered a code.
+//
+// POST /settings/2fa/disable
+func (tf *TwoFactors) Disable(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form TwoFactorForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		tf.render(w, r, vd, nil)
+		return
+	}
+	user := context.User(r.Context())
+	err := tf.tfs.Verify(user.ID, form.Code)
+	if err == nil {
+		err = tf.tfs.Disable(user.ID)
+	}
+	if err != nil {
+		vd.SetAlert(err)
+		tf.render(w, r, vd, nil)
+		return
+	}
+	tf.redirectToIndex(w, r, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Two-factor authentication is off.",
+	})
+}
+
+func (tf *TwoFactors) redirectToIndex(w http.ResponseWriter, r *http.Request, alert views.Alert) {
+	url, err := tf.r.Get(IndexTwoFactor).URL()
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, alert)
+}
+
+func (tf *TwoFactors) render(w http.ResponseWriter, r *http.Request, vd views.Data, codes []string) {
+	user := context.User(r.Context())
+	data := TwoFactorData{RecoveryCodes: codes}
+	twoFactor, err := tf.tfs.ByUserID(user.ID)
+	switch err {
+	case nil:
+		data.TwoFactor = twoFactor
+	case models.ErrNotFound:
+	default:
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	if data.TwoFactor != nil && data.TwoFactor.Enabled() {
+		data.RecoveryCodesLeft, err = tf.tfs.RecoveryCodesLeft(user.ID)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+			return
+		}
+	}
+	vd.Yield = data
+	tf.IndexView.Render(w, r, vd)
+}
@@ -2,34 +2,62 @@ package controllers
 
 import (
 	"fmt"
+	"log"
+	"math"
 	"net/http"
 	"time"
 
 	"github.com/sirodoht/heartfort/context"
 	"github.com/sirodoht/heartfort/email"
+	"github.com/sirodoht/heartfort/middleware"
 	"github.com/sirodoht/heartfort/models"
-	"github.com/sirodoht/heartfort/rand"
 	"github.com/sirodoht/heartfort/views"
 )
 
-func NewUsers(us models.UserService, emailer *email.Client) *Users {
+// loginChallengeCookie holds the token of a login that is
+// waiting for the user's second factor.
+const loginChallengeCookie = "login_challenge"
+
+// NewUsers takes whether the site is served over HTTPS only, so
+// session cookies are never sent without it.
+func NewUsers(us models.UserService, ss models.SessionService, tfs models.TwoFactorService, las models.LoginAttemptService, hs models.HouseholdService, is models.InvitationService, emailer *email.Client, secure bool) *Users {
 	return &Users{
-		NewView:      views.NewView("layout", "users/new"),
-		LoginView:    views.NewView("layout", "users/login"),
-		ForgotPwView: views.NewView("layout", "users/forgot_pw"),
-		ResetPwView:  views.NewView("layout", "users/reset_pw"),
-		us:           us,
-		emailer:      emailer,
+		NewView:       views.NewView("layout", "users/new"),
+		LoginView:     views.NewView("layout", "users/login"),
+		ForgotPwView:  views.NewView("layout", "users/forgot_pw"),
+		ResetPwView:   views.NewView("layout", "users/reset_pw"),
+		JoinView:      views.NewView("layout", "users/join"),
+		ProfileView:   views.NewView("layout", "users/profile"),
+		TwoFactorView: views.NewView("layout", "users/two_factor"),
+		LoginLinkView: views.NewView("layout", "users/login_link"),
+		us:            us,
+		ss:            ss,
+		tfs:           tfs,
+		las:           las,
+		hs:            hs,
+		is:            is,
+		emailer:       emailer,
+		secure:        secure,
 	}
 }
 
 type Users struct {
-	NewView      *views.View
-	LoginView    *views.View
-	ForgotPwView *views.View
-	ResetPwView  *views.View
-	us           models.UserService
-	emailer      *email.Client
+	NewView       *views.View
+	LoginView     *views.View
+	ForgotPwView  *views.View
+	ResetPwView   *views.View
+	JoinView      *views.View
+	ProfileView   *views.View
+	TwoFactorView *views.View
+	LoginLinkView *views.View
+	us            models.UserService
+	ss            models.SessionService
+	tfs           models.TwoFactorService
+	las           models.LoginAttemptService
+	hs            models.HouseholdService
+	is            models.InvitationService
+	emailer       *email.Client
+	secure        bool
 }
 
 // GET /signup
@@ -66,8 +94,21 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 		u.NewView.Render(w, r, vd)
 		return
 	}
-	u.emailer.Welcome(user.Name, user.Email)
-	err := u.signIn(w, &user)
+	// Every user starts out with a household of their own, they
+	// can be added to others or start more later.
+	household := models.Household{
+		Name: user.Name + "'s household",
+	}
+	if user.Name == "" {
+		household.Name = "My household"
+	}
+	if err := u.hs.CreateFor(&household, user.ID); err != nil {
+		log.Println(err)
+	}
+	if err := u.emailer.Welcome(user.Locale, user.Name, user.Email); err != nil {
+		log.Println(err)
+	}
+	err := u.signIn(w, r, &user)
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
@@ -92,6 +133,7 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 	}
 	user, err := u.us.Authenticate(form.Email, form.Password)
 	if err != nil {
+		err = u.loginFailed(r, form.Email, err)
 		switch err {
 		case models.ErrNotFound:
 			vd.AlertError("No user exists with that email address")
@@ -102,30 +144,187 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = u.signIn(w, user)
+	challenged, err := u.logIn(w, r, user)
+	if err != nil {
+		vd.SetAlert(err)
+		u.LoginView.Render(w, r, vd)
+		return
+	}
+	if challenged {
+		http.Redirect(w, r, "/login/2fa", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/jobs", http.StatusFound)
+}
+
+// LoginLinkForm is used to ask for a login link and to log in
+// with one.
+type LoginLinkForm struct {
+	Email string `schema:"email"`
+	Token string `schema:"token"`
+}
+
+// SendLoginLink emails the user a link that logs them in
+// without their password.
+//
+// POST /login/email
+func (u *Users) SendLoginLink(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form LoginLinkForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.LoginView.Render(w, r, vd)
+		return
+	}
+	token, err := u.us.InitiateLoginLink(form.Email)
 	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			vd.AlertError("No user exists with that email address")
+		default:
+			vd.SetAlert(err)
+		}
+		u.LoginView.Render(w, r, vd)
+		return
+	}
+	locale := u.emailer.DefaultLocale()
+	if user, err := u.us.ByEmail(form.Email); err == nil {
+		locale = user.Locale
+	}
+	if err := u.emailer.LoginLink(locale, form.Email, token); err != nil {
 		vd.SetAlert(err)
 		u.LoginView.Render(w, r, vd)
 		return
 	}
+	views.RedirectAlert(w, r, "/login", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "A login link has been emailed to you. It works for 15 minutes.",
+	})
+}
+
+// LoginLink asks the user to confirm they are logging in with
+// the link they were emailed. The link itself doesn't log them
+// in, or the email scanners that open links would use it up.
+//
+// GET /login/link
+func (u *Users) LoginLink(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form LoginLinkForm
+	vd.Yield = &form
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetAlert(err)
+	}
+	u.LoginLinkView.Render(w, r, vd)
+}
+
+// CompleteLoginLink uses up the token from a login link and
+// signs its user in.
+//
+// POST /login/link
+func (u *Users) CompleteLoginLink(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form LoginLinkForm
+	vd.Yield = &form
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.LoginLinkView.Render(w, r, vd)
+		return
+	}
+	user, err := u.us.CompleteLoginLink(form.Token)
+	if err != nil {
+		vd.SetAlert(err)
+		u.LoginLinkView.Render(w, r, vd)
+		return
+	}
+	challenged, err := u.logIn(w, r, user)
+	if err != nil {
+		vd.SetAlert(err)
+		u.LoginLinkView.Render(w, r, vd)
+		return
+	}
+	if challenged {
+		http.Redirect(w, r, "/login/2fa", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/jobs", http.StatusFound)
+}
+
+// TwoFactorLoginForm is used to enter the second factor when
+// logging in.
+type TwoFactorLoginForm struct {
+	Code string `schema:"code"`
+}
+
+// TwoFactor asks for the code of a user whose password has
+// been accepted.
+//
+// GET /login/2fa
+func (u *Users) TwoFactor(w http.ResponseWriter, r *http.Request) {
+	if _, err := r.Cookie(loginChallengeCookie); err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	u.TwoFactorView.Render(w, r, nil)
+}
+
+// CompleteTwoFactor checks the code and, if it is right,
+// finally signs the user in.
+//
+// POST /login/2fa
+func (u *Users) CompleteTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form TwoFactorLoginForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+	cookie, err := r.Cookie(loginChallengeCookie)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	userID, err := u.tfs.FinishLogin(cookie.Value, form.Code)
+	switch err {
+	case nil:
+	case models.ErrTokenInvalid:
+		u.clearLoginChallenge(w)
+		views.RedirectAlert(w, r, "/login", http.StatusFound, views.Alert{
+			Level:   views.AlertLvlError,
+			Message: "Your login has expired or had too many wrong codes. Please log in again.",
+		})
+		return
+	default:
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+	user, err := u.us.ByID(userID)
+	if err == nil {
+		err = u.signIn(w, r, user)
+	}
+	if err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+	u.clearLoginChallenge(w)
 	http.Redirect(w, r, "/jobs", http.StatusFound)
 }
 
+// Logout logs out this device only, the user stays logged in
+// everywhere else.
+//
 // GET /logout
 func (u *Users) Logout(w http.ResponseWriter, r *http.Request) {
-	// First expire the user's cookie
-	cookie := http.Cookie{
-		Name:     "remember_token",
-		Value:    "",
-		Expires:  time.Now(),
-		HttpOnly: true,
+	// First expire the device's cookie
+	clearSessionCookie(w, u.secure)
+	// Then end its session so the token can't be used again
+	if session := context.Session(r.Context()); session != nil {
+		if err := u.ss.Delete(session.ID); err != nil {
+			log.Println(err)
+		}
 	}
-	http.SetCookie(w, &cookie)
-	// Then we update the user with a new remember token
-	user := context.User(r.Context())
-	token, _ := rand.RememberToken()
-	user.Remember = token
-	u.us.Update(user)
 	// Finally send the user to the home page
 	http.Redirect(w, r, "/", http.StatusFound)
 }
@@ -156,7 +355,11 @@ func (u *Users) InitiateReset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = u.emailer.ResetPw(form.Email, token)
+	locale := u.emailer.DefaultLocale()
+	if user, err := u.us.ByEmail(form.Email); err == nil {
+		locale = user.Locale
+	}
+	err = u.emailer.ResetPw(locale, form.Email, token)
 	if err != nil {
 		vd.SetAlert(err)
 		u.ForgotPwView.Render(w, r, vd)
@@ -204,46 +407,376 @@ func (u *Users) CompleteReset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	u.signIn(w, user)
+	// Whoever else may have had the old password is logged out.
+	if err := u.ss.DeleteByUserID(user.ID); err != nil {
+		log.Println(err)
+	}
+	challenged, err := u.logIn(w, r, user)
+	if err != nil {
+		log.Println(err)
+		views.RedirectAlert(w, r, "/login", http.StatusFound, views.Alert{
+			Level:   views.AlertLvlSuccess,
+			Message: "Your password has been reset, please log in.",
+		})
+		return
+	}
+	if challenged {
+		views.RedirectAlert(w, r, "/login/2fa", http.StatusFound, views.Alert{
+			Level:   views.AlertLvlSuccess,
+			Message: "Your password has been reset, enter your code to log in.",
+		})
+		return
+	}
 	views.RedirectAlert(w, r, "/jobs", http.StatusFound, views.Alert{
 		Level:   views.AlertLvlSuccess,
 		Message: "Your password has been reset and you have been logged in!",
 	})
 }
 
-// Cookies is used to display cookies set on the current user
-func (u *Users) Cookies(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("remember_token")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// JoinForm is used to accept an invitation. Visitors who are
+// not logged in either log in with an existing account or sign
+// up with a new one at the same time.
+type JoinForm struct {
+	Token    string `schema:"token"`
+	Name     string `schema:"name"`
+	Email    string `schema:"email"`
+	Password string `schema:"password"`
+}
+
+// JoinData is what the join view expects as its Yield.
+type JoinData struct {
+	Form       JoinForm
+	Invitation *models.Invitation
+	LoggedIn   bool
+}
+
+// Join displays the invitation matching the token in the URL
+// so it can be accepted.
+//
+// GET /join
+func (u *Users) Join(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form JoinForm
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.JoinView.Render(w, r, vd)
 		return
 	}
-	user, err := u.us.ByRemember(cookie.Value)
+	invitation, err := u.pendingInvitation(form.Token)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		vd.SetAlert(err)
+		u.JoinView.Render(w, r, vd)
 		return
 	}
-	fmt.Fprintln(w, user)
+	form.Email = invitation.Email
+	vd.Yield = JoinData{
+		Form:       form,
+		Invitation: invitation,
+		LoggedIn:   context.User(r.Context()) != nil,
+	}
+	u.JoinView.Render(w, r, vd)
 }
 
-// signIn is used to sign the given user in
-func (u *Users) signIn(w http.ResponseWriter, user *models.User) error {
-	if user.Remember == "" {
-		token, err := rand.RememberToken()
-		if err != nil {
-			return err
+// AcceptInvite adds the current user to the household they
+// were invited to. If nobody is logged in, the email and
+// password posted are used to log in, or to sign up if there
+// is no account with that email address yet.
+//
+// POST /join
+func (u *Users) AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form JoinForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.JoinView.Render(w, r, vd)
+		return
+	}
+	invitation, err := u.pendingInvitation(form.Token)
+	if err != nil {
+		vd.SetAlert(err)
+		u.JoinView.Render(w, r, vd)
+		return
+	}
+	// Don't send the password back if we render the form again.
+	password := form.Password
+	form.Password = ""
+	user := context.User(r.Context())
+	vd.Yield = JoinData{
+		Form:       form,
+		Invitation: invitation,
+		LoggedIn:   user != nil,
+	}
+
+	signIn := user == nil
+	if user == nil {
+		user, err = u.us.Authenticate(form.Email, password)
+		switch err {
+		case nil:
+		case models.ErrNotFound:
+			user = &models.User{
+				Name:     form.Name,
+				Email:    form.Email,
+				Password: password,
+			}
+			if err := u.us.Create(user); err != nil {
+				vd.SetAlert(err)
+				u.JoinView.Render(w, r, vd)
+				return
+			}
+			if err := u.emailer.Welcome(user.Locale, user.Name, user.Email); err != nil {
+				log.Println(err)
+			}
+		default:
+			vd.SetAlert(u.loginFailed(r, form.Email, err))
+			u.JoinView.Render(w, r, vd)
+			return
 		}
-		user.Remember = token
-		err = u.us.Update(user)
+	}
+
+	invitation, err = u.is.Accept(form.Token, user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+		u.JoinView.Render(w, r, vd)
+		return
+	}
+	setHouseholdCookie(w, invitation.HouseholdID)
+	if signIn {
+		challenged, err := u.logIn(w, r, user)
 		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		if challenged {
+			http.Redirect(w, r, "/login/2fa", http.StatusFound)
+			return
+		}
+	}
+	views.RedirectAlert(w, r, "/assignments", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Welcome to " + invitation.Household.Name + "!",
+	})
+}
+
+// localeNames are the names of the languages emails can be
+// written in, in that language.
+var localeNames = map[string]string{
+	"de": "Deutsch",
+	"en": "English",
+}
+
+// ProfileForm is used to change the current user's details.
+type ProfileForm struct {
+	Name          string `schema:"name"`
+	Locale        string `schema:"locale"`
+	LinkLoginOnly bool   `schema:"link_login_only"`
+}
+
+// Locale is a language the user can pick for their emails.
+type Locale struct {
+	Code string
+	Name string
+}
+
+// ProfileData is what the profile view expects as its Yield.
+type ProfileData struct {
+	User    *models.User
+	Form    ProfileForm
+	Locales []Locale
+}
+
+// GET /profile
+func (u *Users) Profile(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var vd views.Data
+	u.renderProfile(w, r, vd, user, ProfileForm{
+		Name:          user.Name,
+		Locale:        user.Locale,
+		LinkLoginOnly: user.LinkLoginOnly,
+	})
+}
+
+// POST /profile
+func (u *Users) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var vd views.Data
+	var form ProfileForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.renderProfile(w, r, vd, user, form)
+		return
+	}
+	if !hasLocale(u.emailer, form.Locale) {
+		vd.SetAlert(models.ErrLocaleInvalid)
+		u.renderProfile(w, r, vd, user, form)
+		return
+	}
+	user.Name = form.Name
+	user.Locale = form.Locale
+	user.LinkLoginOnly = form.LinkLoginOnly
+	if err := u.us.Update(user); err != nil {
+		vd.SetAlert(err)
+		u.renderProfile(w, r, vd, user, form)
+		return
+	}
+	views.RedirectAlert(w, r, "/profile", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Profile updated.",
+	})
+}
+
+// hasLocale reports whether emails can be written in the
+// locale.
+func hasLocale(emailer *email.Client, locale string) bool {
+	for _, l := range emailer.Locales() {
+		if l == locale {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *Users) renderProfile(w http.ResponseWriter, r *http.Request, vd views.Data, user *models.User, form ProfileForm) {
+	data := ProfileData{
+		User: user,
+		Form: form,
+	}
+	for _, code := range u.emailer.Locales() {
+		name, ok := localeNames[code]
+		if !ok {
+			name = code
+		}
+		data.Locales = append(data.Locales, Locale{Code: code, Name: name})
+	}
+	vd.Yield = data
+	u.ProfileView.Render(w, r, vd)
+}
+
+// pendingInvitation looks up the invitation matching the token
+// and makes sure it can still be accepted.
+func (u *Users) pendingInvitation(token string) (*models.Invitation, error) {
+	invitation, err := u.is.ByToken(token)
+	if err == models.ErrNotFound || (err == nil && !invitation.Pending()) {
+		return nil, models.ErrTokenInvalid
+	}
+	return invitation, err
+}
+
+// Cookies is used to display the session the current device
+// is logged in with
+func (u *Users) Cookies(w http.ResponseWriter, r *http.Request) {
+	session := context.Session(r.Context())
+	if session == nil {
+		http.Error(w, "Not logged in", http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, context.User(r.Context()))
+	fmt.Fprintln(w, session)
+}
+
+// loginFailed records a login that failed with err for the
+// audit trail and returns the error to show for it. When it is
+// the wrong password that locks the user's account, they are
+// emailed a link that unlocks it and the lock is shown instead.
+func (u *Users) loginFailed(r *http.Request, address string, err error) error {
+	var reason string
+	switch err {
+	case models.ErrNotFound:
+		reason = models.LoginNoUser
+	case models.ErrPasswordIncorrect:
+		reason = models.LoginPassword
+	case models.ErrAccountLocked:
+		reason = models.LoginLocked
+	case models.ErrPasswordLoginOff:
+		reason = models.LoginLinkOnly
+	default:
+		return err
+	}
+	var user *models.User
+	if reason != models.LoginNoUser {
+		found, lookupErr := u.us.ByEmail(address)
+		if lookupErr != nil {
+			log.Println(lookupErr)
 			return err
 		}
+		user = found
+	}
+	attempt := models.LoginAttempt{
+		Email:  address,
+		IP:     middleware.ClientIP(r),
+		Reason: reason,
+	}
+	locked, lockErr := u.las.Failed(&attempt, user)
+	if lockErr != nil {
+		log.Println(lockErr)
+		return err
+	}
+	if !locked {
+		return err
+	}
+	token, lockErr := u.us.InitiateLoginLink(user.Email)
+	if lockErr == nil {
+		minutes := int(math.Ceil(time.Until(*user.LockedUntil).Minutes()))
+		lockErr = u.emailer.AccountLocked(user.Locale, user.Email, token, minutes)
+	}
+	if lockErr != nil {
+		log.Println(lockErr)
+	}
+	return models.ErrAccountLocked
+}
+
+// logIn signs the user in once their password has been
+// accepted, unless they have two-factor authentication on. Then
+// it starts a login that waits for their code instead, and
+// reports that it was challenged so they can be sent to enter
+// it.
+func (u *Users) logIn(w http.ResponseWriter, r *http.Request, user *models.User) (challenged bool, err error) {
+	enabled, err := u.tfs.Enabled(user.ID)
+	if err != nil {
+		return false, err
+	}
+	if !enabled {
+		return false, u.signIn(w, r, user)
+	}
+	token, err := u.tfs.StartLogin(user.ID)
+	if err != nil {
+		return false, err
 	}
 	cookie := http.Cookie{
-		Name:     "remember_token",
-		Value:    user.Remember,
+		Name:     loginChallengeCookie,
+		Value:    token,
+		Path:     "/login",
 		HttpOnly: true,
+		Secure:   u.secure,
+		SameSite: http.SameSiteLaxMode,
 	}
 	http.SetCookie(w, &cookie)
+	return true, nil
+}
+
+func (u *Users) clearLoginChallenge(w http.ResponseWriter) {
+	cookie := http.Cookie{
+		Name:     loginChallengeCookie,
+		Value:    "",
+		Path:     "/login",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   u.secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, &cookie)
+}
+
+// signIn is used to sign the given user in on the device the
+// request came from, with a session of its own.
+func (u *Users) signIn(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	session := models.Session{
+		UserID:    user.ID,
+		UserAgent: r.UserAgent(),
+		IP:        middleware.ClientIP(r),
+	}
+	if err := u.ss.Create(&session); err != nil {
+		return err
+	}
+	setSessionCookie(w, &session, u.secure)
 	return nil
 }
@@ -0,0 +1,297 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+	"github.com/sirodoht/heartfort/webhook"
+)
+
+const (
+	IndexWebhooks = "index_webhooks"
+	ShowWebhook   = "show_webhook"
+)
+
+// webhookDeliveriesShown is how many of a webhook's latest
+// deliveries its page lists.
+const webhookDeliveriesShown = 50
+
+func NewWebhooks(whs models.WebhookService, dispatcher *webhook.Dispatcher, r *mux.Router) *Webhooks {
+	return &Webhooks{
+		IndexView:  views.NewView("layout", "webhooks/index"),
+		ShowView:   views.NewView("layout", "webhooks/show"),
+		whs:        whs,
+		dispatcher: dispatcher,
+		r:          r,
+	}
+}
+
+type Webhooks struct {
+	IndexView  *views.View
+	ShowView   *views.View
+	whs        models.WebhookService
+	dispatcher *webhook.Dispatcher
+	r          *mux.Router
+}
+
+// WebhookForm is used to subscribe a URL to some of the
+// household's events.
+type WebhookForm struct {
+	URL    string   `schema:"url"`
+	Events []string `schema:"events"`
+}
+
+// WebhooksData is what the webhooks index view expects as its
+// Yield.
+type WebhooksData struct {
+	Webhooks []models.Webhook
+	Form     WebhookForm
+	Events   []string
+}
+
+// WebhookData is what the webhook view expects as its Yield.
+type WebhookData struct {
+	Webhook    *models.Webhook
+	Form       WebhookForm
+	Events     []string
+	Deliveries []models.WebhookDelivery
+}
+
+// GET /webhooks
+func (wc *Webhooks) Index(w http.ResponseWriter, r *http.Request) {
+	if !context.Membership(r.Context()).CanManageWebhooks() {
+		forbidden(w, r)
+		return
+	}
+	var vd views.Data
+	wc.renderIndex(w, r, vd, WebhookForm{Events: webhook.Events})
+}
+
+// POST /webhooks
+func (wc *Webhooks) Create(w http.ResponseWriter, r *http.Request) {
+	if !context.Membership(r.Context()).CanManageWebhooks() {
+		forbidden(w, r)
+		return
+	}
+	var vd views.Data
+	var form WebhookForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		wc.renderIndex(w, r, vd, form)
+		return
+	}
+	household := context.Household(r.Context())
+	wh := models.Webhook{
+		HouseholdID: household.ID,
+		URL:         form.URL,
+		Events:      strings.Join(form.Events, ","),
+	}
+	if err := wc.whs.Create(&wh); err != nil {
+		vd.SetAlert(err)
+		wc.renderIndex(w, r, vd, form)
+		return
+	}
+	wc.redirectToShow(w, r, &wh, "Webhook added. Check its signatures with the secret below.")
+}
+
+// GET /webhooks/:id
+func (wc *Webhooks) Show(w http.ResponseWriter, r *http.Request) {
+	wh, err := wc.webhookByID(w, r)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	wc.renderShow(w, r, vd, wh, WebhookForm{
+		URL:    wh.URL,
+		Events: wh.EventList(),
+	})
+}
+
+// POST /webhooks/:id/update
+func (wc *Webhooks) Update(w http.ResponseWriter, r *http.Request) {
+	wh, err := wc.webhookByID(w, r)
+	if err != nil {
+		return
+	}
+	var vd views.Data
+	var form WebhookForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		wc.renderShow(w, r, vd, wh, form)
+		return
+	}
+	wh.URL = form.URL
+	wh.Events = strings.Join(form.Events, ",")
+	if err := wc.whs.Update(wh); err != nil {
+		vd.SetAlert(err)
+		wc.renderShow(w, r, vd, wh, form)
+		return
+	}
+	wc.redirectToShow(w, r, wh, "Webhook updated.")
+}
+
+// POST /webhooks/:id/delete
+func (wc *Webhooks) Delete(w http.ResponseWriter, r *http.Request) {
+	wh, err := wc.webhookByID(w, r)
+	if err != nil {
+		return
+	}
+	if err := wc.whs.Delete(wh.ID); err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		wc.renderShow(w, r, vd, wh, WebhookForm{URL: wh.URL, Events: wh.EventList()})
+		return
+	}
+	url, err := wc.r.Get(IndexWebhooks).URL()
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Webhook deleted.",
+	})
+}
+
+// Redeliver posts a delivery to the webhook again, whether it
+// went through the first time or not, say after fixing the
+// receiving end.
+//
+// POST /webhooks/:id/deliveries/:delivery_id/redeliver
+func (wc *Webhooks) Redeliver(w http.ResponseWriter, r *http.Request) {
+	wh, err := wc.webhookByID(w, r)
+	if err != nil {
+		return
+	}
+	deliveryID, err := strconv.Atoi(mux.Vars(r)["delivery_id"])
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusNotFound)
+		return
+	}
+	delivery, err := wc.whs.DeliveryByID(uint(deliveryID))
+	if err == nil && delivery.WebhookID != wh.ID {
+		err = models.ErrNotFound
+	}
+	if err == nil {
+		err = wc.whs.Redeliver(delivery.ID)
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Delivery not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went terribly wrong.", http.StatusInternalServerError)
+		}
+		return
+	}
+	wc.dispatcher.Wake()
+	wc.redirectToShow(w, r, wh, "Delivery queued to be sent again.")
+}
+
+func (wc *Webhooks) renderIndex(w http.ResponseWriter, r *http.Request, vd views.Data, form WebhookForm) {
+	household := context.Household(r.Context())
+	webhooks, err := wc.whs.ByHouseholdID(household.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	vd.Yield = WebhooksData{
+		Webhooks: webhooks,
+		Form:     form,
+		Events:   webhook.Events,
+	}
+	wc.IndexView.Render(w, r, vd)
+}
+
+func (wc *Webhooks) renderShow(w http.ResponseWriter, r *http.Request, vd views.Data, wh *models.Webhook, form WebhookForm) {
+	deliveries, err := wc.whs.Deliveries(wh.ID, webhookDeliveriesShown)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	vd.Yield = WebhookData{
+		Webhook:    wh,
+		Form:       form,
+		Events:     webhook.Events,
+		Deliveries: deliveries,
+	}
+	wc.ShowView.Render(w, r, vd)
+}
+
+func (wc *Webhooks) redirectToShow(w http.ResponseWriter, r *http.Request, wh *models.Webhook, message string) {
+	url, err := wc.r.Get(ShowWebhook).URL("id", strconv.Itoa(int(wh.ID)))
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/webhooks", http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: message,
+	})
+}
+
+// webhookByID looks up the webhook in the URL, making sure it
+// belongs to the current household and that the current user
+// can manage it.
+func (wc *Webhooks) webhookByID(w http.ResponseWriter, r *http.Request) (*models.Webhook, error) {
+	if !context.Membership(r.Context()).CanManageWebhooks() {
+		forbidden(w, r)
+		return nil, models.ErrNotFound
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Invalid webhook ID", http.StatusNotFound)
+		return nil, err
+	}
+	wh, err := wc.whs.ByID(uint(id))
+	household := context.Household(r.Context())
+	if err == nil && wh.HouseholdID != household.ID {
+		err = models.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+		default:
+			log.Println(err)
+			http.Error(w, "Something went terribly wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	return wh, nil
+}
+
+// emitEvent queues an event for the household's webhooks.
+// Webhooks are a side effect of whatever happened, so failing
+// to queue one is only logged.
+func emitEvent(dispatcher *webhook.Dispatcher, householdID uint, event string, data interface{}) {
+	if err := dispatcher.Emit(householdID, event, data); err != nil {
+		log.Println(err)
+	}
+}
+
+// EmitAssignment reloads the assignment, so its job and user
+// are in the payload, and queues the event for it. It is
+// exported for things that change assignments outside of the
+// site, like the chat bot.
+func EmitAssignment(dispatcher *webhook.Dispatcher, as models.AssignmentService, event string, id uint) {
+	assignment, err := as.ByID(id)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	emitEvent(dispatcher, assignment.HouseholdID, event, newAPIAssignment(assignment))
+}
@@ -0,0 +1,280 @@
+package email
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+func WithSender(name, email string) ClientConfig {
+	return func(c *Client) {
+		c.from = buildEmail(name, email)
+	}
+}
+
+// WithTransport sends email through any Transport, like a
+// Recorder in tests.
+func WithTransport(t Transport) ClientConfig {
+	return func(c *Client) {
+		c.transport = t
+	}
+}
+
+// WithBaseURL sets the address of the site that links in
+// emails point to, eg "https://heartfort.com".
+func WithBaseURL(baseURL string) ClientConfig {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithTemplateDir loads the email templates from dir instead
+// of TemplateDir.
+func WithTemplateDir(dir string) ClientConfig {
+	return func(c *Client) {
+		c.templateDir = dir
+	}
+}
+
+// WithDefaultLocale sets the locale emails are written in when
+// there are no templates for the recipient's, which needs
+// templates of its own.
+func WithDefaultLocale(locale string) ClientConfig {
+	return func(c *Client) {
+		c.defaultLocale = locale
+	}
+}
+
+type ClientConfig func(*Client)
+
+// NewClient panics if the email templates can't be loaded, just
+// like views.NewView does for pages.
+func NewClient(opts ...ClientConfig) *Client {
+	client := Client{
+		// default email address
+		from:          "foundation@heartfort.com",
+		baseURL:       "https://heartfort.com",
+		templateDir:   TemplateDir,
+		defaultLocale: "en",
+	}
+	for _, opt := range opts {
+		opt(&client)
+	}
+	templates, err := loadTemplates(client.templateDir, client.defaultLocale)
+	if err != nil {
+		panic(err)
+	}
+	client.templates = templates
+	return &client
+}
+
+type Client struct {
+	from          string
+	transport     Transport
+	baseURL       string
+	templateDir   string
+	defaultLocale string
+	templates     templates
+}
+
+// DefaultLocale is the locale for whoever we don't know the
+// language of, like mates without an account.
+func (c *Client) DefaultLocale() string {
+	return c.defaultLocale
+}
+
+// Locales returns the locales there are email templates for.
+func (c *Client) Locales() []string {
+	return c.templates.locales()
+}
+
+// Every method below takes the locale of whoever the email is
+// to first. Unknown and empty locales get the default locale.
+
+func (c *Client) Welcome(locale, toName, toEmail string) error {
+	return c.sendTemplate(locale, "welcome", toName, toEmail, Data{})
+}
+
+func (c *Client) ResetPw(locale, toEmail, token string) error {
+	return c.sendTemplate(locale, "reset", "", toEmail, Data{
+		Yield: resetData{
+			URL:   c.url("/reset", url.Values{"token": {token}}),
+			Token: token,
+		},
+	})
+}
+
+// LoginLink sends a link that logs the user in without their
+// password.
+func (c *Client) LoginLink(locale, toEmail, token string) error {
+	return c.sendTemplate(locale, "login_link", "", toEmail, Data{
+		Yield: loginLinkData{
+			URL: c.url("/login/link", url.Values{"token": {token}}),
+		},
+	})
+}
+
+// AccountLocked tells the user their account has been locked
+// for minutes after too many wrong passwords, with a login link
+// that unlocks it.
+func (c *Client) AccountLocked(locale, toEmail, token string, minutes int) error {
+	return c.sendTemplate(locale, "account_locked", "", toEmail, Data{
+		Yield: accountLockedData{
+			Minutes: minutes,
+			URL:     c.url("/login/link", url.Values{"token": {token}}),
+		},
+	})
+}
+
+func (c *Client) Invite(locale, toEmail, fromName, householdName, token string) error {
+	return c.sendTemplate(locale, "invite", "", toEmail, Data{
+		Yield: inviteData{
+			FromName:  fromName,
+			Household: householdName,
+			URL:       c.url("/join", url.Values{"token": {token}}),
+		},
+	})
+}
+
+// SwapProposed tells someone about a swap they can answer.
+// withJobName is empty when the assignment is up for anyone
+// to take, otherwise it is the job they are asked to trade.
+// week is the date the assignment's week starts on, or empty.
+func (c *Client) SwapProposed(locale, toName, toEmail, fromName, jobName, withJobName, week string) error {
+	return c.sendTemplate(locale, "swap_proposed", toName, toEmail, Data{
+		Yield: swapProposedData{
+			FromName:    fromName,
+			JobName:     jobName,
+			WithJobName: withJobName,
+			Week:        week,
+			URL:         c.url("/assignments", nil),
+		},
+	})
+}
+
+// SwapAnswered tells someone involved in a swap that it was
+// accepted, declined or cancelled by answeredByName.
+func (c *Client) SwapAnswered(locale, toName, toEmail, answeredByName, status, jobName, week string) error {
+	return c.sendTemplate(locale, "swap_answered", toName, toEmail, Data{
+		Yield: swapAnsweredData{
+			AnsweredBy: answeredByName,
+			Status:     status,
+			JobName:    jobName,
+			Week:       week,
+			URL:        c.url("/assignments", nil),
+		},
+	})
+}
+
+// Reminder tells the assignee that their assignment is due by
+// the end of dueDate.
+func (c *Client) Reminder(locale, toName, toEmail, jobName, dueDate string, assignmentID uint) error {
+	return c.sendTemplate(locale, "reminder", toName, toEmail, Data{
+		Yield: reminderData{
+			JobName: jobName,
+			DueDate: dueDate,
+			URL:     c.url(fmt.Sprintf("/assignments/%d", assignmentID), nil),
+		},
+	})
+}
+
+// Overdue nags the assignee about an assignment whose week is
+// over but which is still not finished.
+func (c *Client) Overdue(locale, toName, toEmail, jobName, week string, assignmentID uint) error {
+	return c.sendTemplate(locale, "overdue", toName, toEmail, Data{
+		Yield: overdueData{
+			JobName: jobName,
+			Week:    week,
+			URL:     c.url(fmt.Sprintf("/assignments/%d", assignmentID), nil),
+		},
+	})
+}
+
+// ConfirmMate asks someone who signed up for a household's
+// notifications to confirm it was them.
+func (c *Client) ConfirmMate(locale, toEmail, householdName, token string) error {
+	return c.sendTemplate(locale, "confirm_mate", "", toEmail, Data{
+		Yield: confirmMateData{
+			Household: householdName,
+			URL:       c.url("/mates/confirm", url.Values{"token": {token}}),
+		},
+	})
+}
+
+// Digest sends the weekly digest of who is doing what in the
+// household during the week starting on week. unsubscribeToken
+// is put in the link, and the List-Unsubscribe header, that
+// lets the mate stop the emails.
+func (c *Client) Digest(locale, toEmail, householdName, week string, entries []DigestEntry, unsubscribeToken string) error {
+	return c.sendTemplate(locale, "digest", "", toEmail, Data{
+		UnsubscribeURL: c.url("/mates/unsubscribe", url.Values{"token": {unsubscribeToken}}),
+		Yield: digestData{
+			Household: householdName,
+			Week:      week,
+			Entries:   entries,
+			URL:       c.url("/assignments", nil),
+		},
+	})
+}
+
+// sendTemplate renders the named email for the recipient and
+// sends it.
+func (c *Client) sendTemplate(locale, name, toName, toEmail string, data Data) error {
+	data.Name = toName
+	message, err := c.render(locale, name, data)
+	if err != nil {
+		return err
+	}
+	message.To = buildEmail(toName, toEmail)
+	return c.send(message)
+}
+
+// render fills in the parts of data every email has and renders
+// the named email into a message with no recipient yet.
+func (c *Client) render(locale, name string, data Data) (*Message, error) {
+	data.BaseURL = c.baseURL
+	subject, text, html, err := c.templates.render(locale, c.defaultLocale, name, data)
+	if err != nil {
+		return nil, err
+	}
+	message := NewMessage(c.from, subject, text, "")
+	message.SetHTML(html)
+	if data.UnsubscribeURL != "" {
+		setUnsubscribe(message, data.UnsubscribeURL)
+	}
+	return message, nil
+}
+
+func (c *Client) send(message *Message) error {
+	if c.transport == nil {
+		return ErrNoTransport
+	}
+	return c.transport.Send(message)
+}
+
+// url is the link to path on the site with the query, if any.
+func (c *Client) url(path string, query url.Values) string {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// setUnsubscribe adds the List-Unsubscribe headers so mail
+// clients can offer a one-click unsubscribe (RFC 8058).
+func setUnsubscribe(message *Message, unsubscribeURL string) {
+	message.AddHeader("List-Unsubscribe", "<"+unsubscribeURL+">")
+	message.AddHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+}
+
+// buildEmail formats the address for the From and To headers,
+// quoting and encoding the name as needed so that names like
+// "Smith, John" parse back as one address.
+func buildEmail(name, email string) string {
+	if name == "" {
+		return email
+	}
+	return (&mail.Address{Name: name, Address: email}).String()
+}
@@ -0,0 +1,39 @@
+package email
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWelcomeToNameWithComma(t *testing.T) {
+	recorder := &Recorder{}
+	client := NewClient(
+		WithTransport(recorder),
+		WithTemplateDir("../views/email/"),
+		WithSender("Heartfort", "foundation@heartfort.com"),
+	)
+	if err := client.Welcome("en", "Smith, John", "john@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	messages := recorder.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(messages))
+	}
+	message := messages[0]
+	from, to, err := message.envelope()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != "foundation@heartfort.com" || to != "john@example.com" {
+		t.Errorf("envelope is from %q to %q", from, to)
+	}
+	var buf bytes.Buffer
+	if _, err := message.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "To: \"Smith, John\" <john@example.com>\r\n"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("headers don't have %q:\n%s", want, buf.String())
+	}
+}
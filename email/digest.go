@@ -0,0 +1,8 @@
+package email
+
+// DigestEntry is one line of the weekly digest: a job and who
+// is doing it.
+type DigestEntry struct {
+	Job      string
+	Assignee string
+}
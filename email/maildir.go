@@ -0,0 +1,62 @@
+package email
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// WithMaildir writes every email into a maildir instead of
+// sending it.
+func WithMaildir(dir string) ClientConfig {
+	return WithTransport(NewMaildirTransport(dir))
+}
+
+// NewMaildirTransport returns a Transport that writes every
+// email into a maildir, which is handy in development. Any mail
+// client that reads maildirs can open it, or the files in its
+// new directory can be read as they are.
+func NewMaildirTransport(dir string) Transport {
+	return &maildirTransport{dir: dir}
+}
+
+var _ Transport = &maildirTransport{}
+
+type maildirTransport struct {
+	// count comes first so it is 64-bit aligned for atomic.
+	count uint64
+	dir   string
+}
+
+// Send writes the message into tmp and then moves it into new,
+// as maildir readers expect, so they never see half a message.
+func (mt *maildirTransport) Send(message *Message) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(mt.dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	name := fmt.Sprintf("%d.%d_%d.%s", time.Now().Unix(), os.Getpid(),
+		atomic.AddUint64(&mt.count, 1), host)
+	tmp := filepath.Join(mt.dir, "tmp", name)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := message.WriteTo(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(mt.dir, "new", name))
+}
@@ -0,0 +1,182 @@
+package email
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// outboxLease is how long a claimed message is left alone
+	// by other workers. A worker that dies mid-send leaves it to
+	// be picked up again once the lease runs out.
+	outboxLease = 10 * time.Minute
+	// maxBackoff caps the wait between attempts.
+	maxBackoff = 24 * time.Hour
+)
+
+// Queued is a message waiting in the outbox. Attempts counts
+// every time it was claimed for delivery, including this one.
+type Queued struct {
+	ID       uint
+	Attempts int
+	Message  Message
+}
+
+// OutboxStore is where the outbox keeps messages between them
+// being sent and delivered, so they survive restarts.
+type OutboxStore interface {
+	Enqueue(message *Message) error
+	// Claim takes up to limit messages that are due by now and
+	// hides them from other claims for the length of the lease.
+	Claim(now time.Time, lease time.Duration, limit int) ([]Queued, error)
+	Delivered(id uint) error
+	// Retry records why delivery failed and when to try again.
+	Retry(id uint, reason string, at time.Time) error
+	// DeadLetter records why delivery failed and gives up on it.
+	DeadLetter(id uint, reason string) error
+}
+
+// OutboxConfig is how hard the outbox tries. Workers is how
+// many messages are delivered at once, the first retry waits
+// Backoff and every one after that twice as long as the last,
+// up to MaxAttempts in total. Poll is how often the workers look
+// for messages that are due a retry.
+type OutboxConfig struct {
+	Workers     int
+	MaxAttempts int
+	Backoff     time.Duration
+	Poll        time.Duration
+}
+
+// Outbox is a Transport that queues messages in a store and
+// has a pool of workers deliver them through another Transport
+// in the background, so sending never waits on, or fails
+// because of, the mail provider.
+type Outbox struct {
+	store     OutboxStore
+	transport Transport
+	cfg       OutboxConfig
+	wake      chan struct{}
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+var _ Transport = &Outbox{}
+
+func NewOutbox(store OutboxStore, transport Transport, cfg OutboxConfig) *Outbox {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	if cfg.Poll <= 0 {
+		cfg.Poll = time.Minute
+	}
+	return &Outbox{
+		store:     store,
+		transport: transport,
+		cfg:       cfg,
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+	}
+}
+
+// Send queues the message and wakes a worker up to deliver it.
+func (o *Outbox) Send(message *Message) error {
+	if err := o.store.Enqueue(message); err != nil {
+		return err
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start runs the workers in the background until Stop is
+// called.
+func (o *Outbox) Start() {
+	for i := 0; i < o.cfg.Workers; i++ {
+		o.wg.Add(1)
+		go o.work()
+	}
+}
+
+// Stop waits for the workers to finish what they are sending
+// and stops them.
+func (o *Outbox) Stop() {
+	close(o.stop)
+	o.wg.Wait()
+}
+
+func (o *Outbox) work() {
+	defer o.wg.Done()
+	ticker := time.NewTicker(o.cfg.Poll)
+	defer ticker.Stop()
+	for {
+		// Keep going while there is work so a burst of messages
+		// doesn't wait for the next tick.
+		for o.deliverNext() {
+			select {
+			case <-o.stop:
+				return
+			default:
+			}
+		}
+		select {
+		case <-o.stop:
+			return
+		case <-o.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverNext delivers one due message and reports whether
+// there was one.
+func (o *Outbox) deliverNext() bool {
+	claimed, err := o.store.Claim(time.Now(), outboxLease, 1)
+	if err != nil {
+		log.Println("outbox:", err)
+		return false
+	}
+	if len(claimed) == 0 {
+		return false
+	}
+	o.deliver(claimed[0])
+	return true
+}
+
+func (o *Outbox) deliver(q Queued) {
+	err := o.transport.Send(&q.Message)
+	if err == nil {
+		err = o.store.Delivered(q.ID)
+		if err != nil {
+			log.Println("outbox:", err)
+		}
+		return
+	}
+	log.Printf("outbox: message %d, attempt %d: %v", q.ID, q.Attempts, err)
+	if q.Attempts >= o.cfg.MaxAttempts {
+		err = o.store.DeadLetter(q.ID, err.Error())
+	} else {
+		err = o.store.Retry(q.ID, err.Error(), time.Now().Add(o.backoff(q.Attempts)))
+	}
+	if err != nil {
+		log.Println("outbox:", err)
+	}
+}
+
+// backoff is how long to wait after the given failed attempt.
+func (o *Outbox) backoff(attempts int) time.Duration {
+	wait := o.cfg.Backoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
@@ -0,0 +1,94 @@
+package email
+
+// previews is made up data for every email, to see what they
+// look like without sending them.
+func previews(baseURL string) map[string]Data {
+	return map[string]Data{
+		"welcome": {Name: "Alex"},
+		"reset": {
+			Yield: resetData{URL: baseURL + "/reset?token=sample-token", Token: "sample-token"},
+		},
+		"login_link": {
+			Yield: loginLinkData{URL: baseURL + "/login/link?token=sample-token"},
+		},
+		"account_locked": {
+			Yield: accountLockedData{Minutes: 30, URL: baseURL + "/login/link?token=sample-token"},
+		},
+		"invite": {
+			Yield: inviteData{FromName: "Alex", Household: "Flat 4B", URL: baseURL + "/join?token=sample-token"},
+		},
+		"swap_proposed": {
+			Name: "Sam",
+			Yield: swapProposedData{
+				FromName:    "Alex",
+				JobName:     "Kitchen",
+				WithJobName: "Bathroom",
+				Week:        "2019-03-04",
+				URL:         baseURL + "/assignments",
+			},
+		},
+		"swap_answered": {
+			Name: "Alex",
+			Yield: swapAnsweredData{
+				AnsweredBy: "Sam",
+				Status:     "accepted",
+				JobName:    "Kitchen",
+				Week:       "2019-03-04",
+				URL:        baseURL + "/assignments",
+			},
+		},
+		"reminder": {
+			Name:  "Sam",
+			Yield: reminderData{JobName: "Kitchen", DueDate: "2019-03-10", URL: baseURL + "/assignments/1"},
+		},
+		"overdue": {
+			Name:  "Sam",
+			Yield: overdueData{JobName: "Kitchen", Week: "2019-03-04", URL: baseURL + "/assignments/1"},
+		},
+		"confirm_mate": {
+			Yield: confirmMateData{Household: "Flat 4B", URL: baseURL + "/mates/confirm?token=sample-token"},
+		},
+		"digest": {
+			UnsubscribeURL: baseURL + "/mates/unsubscribe?token=sample-token",
+			Yield: digestData{
+				Household: "Flat 4B",
+				Week:      "2019-03-04",
+				Entries: []DigestEntry{
+					{Job: "Kitchen", Assignee: "Alex"},
+					{Job: "Bathroom", Assignee: "Sam"},
+					{Job: "Bins", Assignee: "Kim"},
+				},
+				URL: baseURL + "/assignments",
+			},
+		},
+	}
+}
+
+// Previews returns the names of the emails that can be
+// previewed.
+func (c *Client) Previews() []string {
+	all := previews(c.baseURL)
+	var names []string
+	for _, name := range c.templates.names(c.defaultLocale) {
+		if _, ok := all[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Preview renders the named email in the locale with made up
+// data, without sending it. ok is false when there is no such
+// email.
+func (c *Client) Preview(locale, name string) (message *Message, ok bool, err error) {
+	data, ok := previews(c.baseURL)[name]
+	if !ok {
+		return nil, false, nil
+	}
+	message, err = c.render(locale, name, data)
+	if err != nil {
+		return nil, true, err
+	}
+	message.To = buildEmail(data.Name, "someone@example.com")
+	return message, true, nil
+}
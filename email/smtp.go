@@ -0,0 +1,81 @@
+package email
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+)
+
+// WithSMTP sends email through a mail server.
+func WithSMTP(host string, port int, username, password string) ClientConfig {
+	return WithTransport(NewSMTPTransport(host, port, username, password))
+}
+
+// NewSMTPTransport returns a Transport that sends email through
+// a mail server. The connection is upgraded with STARTTLS
+// whenever the server offers it, and when a username is given
+// the client logs in with it, which net/smtp only allows over
+// TLS or to localhost.
+func NewSMTPTransport(host string, port int, username, password string) Transport {
+	return &smtpTransport{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+	}
+}
+
+var _ Transport = &smtpTransport{}
+
+type smtpTransport struct {
+	host     string
+	port     int
+	username string
+	password string
+}
+
+func (st *smtpTransport) Send(message *Message) error {
+	from, to, err := message.envelope()
+	if err != nil {
+		return err
+	}
+	c, err := smtp.Dial(net.JoinHostPort(st.host, strconv.Itoa(st.port)))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: st.host}); err != nil {
+			return err
+		}
+	}
+	if st.username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("email: %s doesn't support AUTH", st.host)
+		}
+		auth := smtp.PlainAuth("", st.username, st.password, st.host)
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := message.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
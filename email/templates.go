@@ -0,0 +1,181 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+)
+
+// TemplateDir is where the email templates are kept, with a
+// directory per locale. Each locale has a layout.txt and a
+// layout.html, and every email has a .txt file defining its
+// "subject" and plain text "body" and an .html file defining
+// its HTML "body".
+var TemplateDir = "views/email/"
+
+// Data is what every email template is executed with. Name is
+// who the email is to, when we know it, and Yield holds what
+// the email is about, just like views.Data does for pages.
+type Data struct {
+	Name           string
+	BaseURL        string
+	UnsubscribeURL string
+	Yield          interface{}
+}
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// templates holds the emails of every locale by locale and then
+// by name.
+type templates map[string]map[string]emailTemplate
+
+func loadTemplates(dir, defaultLocale string) (templates, error) {
+	layouts, err := filepath.Glob(filepath.Join(dir, "*", "layout.txt"))
+	if err != nil {
+		return nil, err
+	}
+	ts := make(templates, len(layouts))
+	for _, layout := range layouts {
+		localeDir := filepath.Dir(layout)
+		files, err := filepath.Glob(filepath.Join(localeDir, "*.txt"))
+		if err != nil {
+			return nil, err
+		}
+		emails := make(map[string]emailTemplate, len(files))
+		for _, file := range files {
+			name := strings.TrimSuffix(filepath.Base(file), ".txt")
+			if name == "layout" {
+				continue
+			}
+			text, err := texttemplate.ParseFiles(layout, file)
+			if err != nil {
+				return nil, err
+			}
+			html, err := htmltemplate.ParseFiles(
+				filepath.Join(localeDir, "layout.html"),
+				filepath.Join(localeDir, name+".html"),
+			)
+			if err != nil {
+				return nil, err
+			}
+			emails[name] = emailTemplate{text: text, html: html}
+		}
+		ts[filepath.Base(localeDir)] = emails
+	}
+	if _, ok := ts[defaultLocale]; !ok {
+		return nil, fmt.Errorf("email: no %s templates in %s", defaultLocale, dir)
+	}
+	return ts, nil
+}
+
+// render executes the named email in the locale, or in
+// defaultLocale if the locale doesn't have it.
+func (ts templates) render(locale, defaultLocale, name string, data Data) (subject, text, html string, err error) {
+	t, ok := ts[locale][name]
+	if !ok {
+		t, ok = ts[defaultLocale][name]
+	}
+	if !ok {
+		return "", "", "", fmt.Errorf("email: no %q template", name)
+	}
+	var buf bytes.Buffer
+	if err := t.text.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return "", "", "", err
+	}
+	subject = strings.TrimSpace(buf.String())
+	buf.Reset()
+	if err := t.text.ExecuteTemplate(&buf, "layout", data); err != nil {
+		return "", "", "", err
+	}
+	text = buf.String()
+	buf.Reset()
+	if err := t.html.ExecuteTemplate(&buf, "layout", data); err != nil {
+		return "", "", "", err
+	}
+	return subject, text, buf.String(), nil
+}
+
+func (ts templates) locales() []string {
+	locales := make([]string, 0, len(ts))
+	for locale := range ts {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+func (ts templates) names(locale string) []string {
+	names := make([]string, 0, len(ts[locale]))
+	for name := range ts[locale] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type resetData struct {
+	URL   string
+	Token string
+}
+
+type loginLinkData struct {
+	URL string
+}
+
+type accountLockedData struct {
+	Minutes int
+	URL     string
+}
+
+type inviteData struct {
+	FromName  string
+	Household string
+	URL       string
+}
+
+type swapProposedData struct {
+	FromName    string
+	JobName     string
+	WithJobName string
+	Week        string
+	URL         string
+}
+
+type swapAnsweredData struct {
+	AnsweredBy string
+	Status     string
+	JobName    string
+	Week       string
+	URL        string
+}
+
+type reminderData struct {
+	JobName string
+	DueDate string
+	URL     string
+}
+
+type overdueData struct {
+	JobName string
+	Week    string
+	URL     string
+}
+
+type confirmMateData struct {
+	Household string
+	URL       string
+}
+
+type digestData struct {
+	Household string
+	Week      string
+	Entries   []DigestEntry
+	URL       string
+}
@@ -0,0 +1,197 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirodoht/heartfort/rand"
+)
+
+// ErrNoTransport is returned when sending with a Client that
+// wasn't given a Transport.
+var ErrNoTransport = errors.New("email: no transport configured")
+
+// Transport delivers a message, to an email provider, a mail
+// server, a directory or just memory.
+type Transport interface {
+	Send(message *Message) error
+}
+
+// Message is an email with a plain text body and an optional
+// HTML alternative. From and To can either be bare addresses
+// or include a name, eg "Jon <jon@example.com>".
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string
+}
+
+// NewMessage takes the same arguments as the mailgun package's
+// NewMessage so building messages reads the same.
+func NewMessage(from, subject, text, to string) *Message {
+	return &Message{
+		From:    from,
+		To:      to,
+		Subject: subject,
+		Text:    text,
+	}
+}
+
+func (m *Message) SetHTML(html string) {
+	m.HTML = html
+}
+
+// AddHeader sets an extra header such as List-Unsubscribe.
+func (m *Message) AddHeader(name, value string) {
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[textproto.CanonicalMIMEHeaderKey(name)] = value
+}
+
+// headerNames returns the names of the extra headers in order
+// so messages always come out the same.
+func (m *Message) headerNames() []string {
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// envelope returns the bare addresses the message is from and
+// to, for transports that talk SMTP.
+func (m *Message) envelope() (from, to string, err error) {
+	f, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return "", "", fmt.Errorf("email: from address: %v", err)
+	}
+	t, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return "", "", fmt.Errorf("email: to address: %v", err)
+	}
+	return f.Address, t.Address, nil
+}
+
+// WriteTo writes the message out in RFC 5322 format, as a
+// multipart/alternative message when it has an HTML body.
+func (m *Message) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	from, _, err := m.envelope()
+	if err != nil {
+		return 0, err
+	}
+	domain := from[strings.LastIndex(from, "@")+1:]
+	id, err := rand.String(18)
+	if err != nil {
+		return 0, err
+	}
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", m.From)
+	header("To", m.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%s@%s>", id, domain))
+	header("MIME-Version", "1.0")
+	for _, name := range m.headerNames() {
+		header(name, m.Headers[name])
+	}
+
+	if m.HTML == "" {
+		header("Content-Type", `text/plain; charset="utf-8"`)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, m.Text); err != nil {
+			return 0, err
+		}
+		return buf.WriteTo(w)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	header("Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", mw.Boundary()))
+	buf.WriteString("\r\n")
+	parts := []struct{ contentType, content string }{
+		{`text/plain; charset="utf-8"`, m.Text},
+		{`text/html; charset="utf-8"`, m.HTML},
+	}
+	for _, p := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return 0, err
+		}
+		if err := writeQuotedPrintable(pw, p.content); err != nil {
+			return 0, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return 0, err
+	}
+	buf.Write(body.Bytes())
+	return buf.WriteTo(w)
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := io.WriteString(qw, s); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+var _ Transport = &Recorder{}
+
+// Recorder is a Transport that keeps every message in memory
+// instead of sending it, so tests can check what was sent.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (r *Recorder) Send(message *Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := *message
+	if message.Headers != nil {
+		m.Headers = make(map[string]string, len(message.Headers))
+		for name, value := range message.Headers {
+			m.Headers[name] = value
+		}
+	}
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (r *Recorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Message(nil), r.messages...)
+}
+
+// Reset forgets every message sent so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = nil
+}
@@ -21,4 +21,5 @@ require (
 	github.com/pilu/fresh v0.0.0-20190826141211-0fa698148017 // indirect
 	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
 	gopkg.in/mailgun/mailgun-go.v1 v1.1.1
+	rsc.io/qr v0.2.0
 )
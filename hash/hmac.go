@@ -4,27 +4,28 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
-	"hash"
 )
 
 // NewHMAC creates and returns a new HMAC object
 func NewHMAC(key string) HMAC {
-	h := hmac.New(sha256.New, []byte(key))
 	return HMAC{
-		hmac: h}
+		key: []byte(key)}
 }
 
 // HMAC is a wrapper around the crypto/hmac package making
-// it a little easier to use in our code.
+// it a little easier to use in our code. It is safe to use
+// from several goroutines at once.
 type HMAC struct {
-	hmac hash.Hash
+	key []byte
 }
 
 // Hash will hash the provided input string using HMAC with
 // the secret key provided when the HMAC object was created
 func (h HMAC) Hash(input string) string {
-	h.hmac.Reset()
-	h.hmac.Write([]byte(input))
-	b := h.hmac.Sum(nil)
+	// hash.Hash keeps state between writes, so every call gets
+	// its own rather than sharing one between goroutines.
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(input))
+	b := mac.Sum(nil)
 	return base64.URLEncoding.EncodeToString(b)
 }
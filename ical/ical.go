@@ -0,0 +1,144 @@
+// Package ical writes calendars in the iCalendar format of
+// RFC 5545 so calendar apps can subscribe to them.
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+)
+
+// ContentType is what calendars are served as.
+const ContentType = "text/calendar; charset=utf-8"
+
+const (
+	prodID = "-//Heartfort//Assignments//EN"
+	// lineLength is how many octets a line may have before it
+	// has to be folded, not counting the line break.
+	lineLength = 75
+
+	dateFormat     = "20060102"
+	dateTimeFormat = "20060102T150405Z"
+)
+
+// The statuses an event can have.
+const (
+	StatusConfirmed = "CONFIRMED"
+	StatusCancelled = "CANCELLED"
+)
+
+// Calendar is a VCALENDAR. Name is what calendar apps show for
+// it when someone subscribes.
+type Calendar struct {
+	Name   string
+	Events []Event
+}
+
+// Event is a VEVENT that lasts whole days, from the day Start
+// falls on up to but not including the day End falls on.
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	URL         string
+	Status      string
+	Start       time.Time
+	End         time.Time
+	// Stamp is when the event last changed.
+	Stamp time.Time
+}
+
+// WriteTo writes the calendar out with CRLF line breaks and
+// long lines folded, as RFC 5545 asks for.
+func (c *Calendar) WriteTo(w io.Writer) (int64, error) {
+	cw := &writer{w: bufio.NewWriter(w)}
+	cw.line("BEGIN", "VCALENDAR")
+	cw.line("VERSION", "2.0")
+	cw.line("PRODID", prodID)
+	cw.line("CALSCALE", "GREGORIAN")
+	cw.line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		cw.line("X-WR-CALNAME", escape(c.Name))
+	}
+	for _, event := range c.Events {
+		cw.line("BEGIN", "VEVENT")
+		cw.line("UID", escape(event.UID))
+		cw.line("DTSTAMP", event.Stamp.UTC().Format(dateTimeFormat))
+		cw.line("DTSTART;VALUE=DATE", event.Start.Format(dateFormat))
+		cw.line("DTEND;VALUE=DATE", event.End.Format(dateFormat))
+		cw.line("SUMMARY", escape(event.Summary))
+		if event.Description != "" {
+			cw.line("DESCRIPTION", escape(event.Description))
+		}
+		if event.URL != "" {
+			cw.line("URL", event.URL)
+		}
+		if event.Status != "" {
+			cw.line("STATUS", event.Status)
+		}
+		cw.line("TRANSP", "TRANSPARENT")
+		cw.line("END", "VEVENT")
+	}
+	cw.line("END", "VCALENDAR")
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// writer keeps the first error so WriteTo doesn't have to check
+// after every line.
+type writer struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *writer) line(name, value string) {
+	if cw.err != nil {
+		return
+	}
+	n, err := cw.w.WriteString(fold(name + ":" + value))
+	cw.n += int64(n)
+	cw.err = err
+}
+
+// fold breaks a content line into lines of at most lineLength
+// octets, each following line starting with a space, without
+// splitting a UTF-8 character in two.
+func fold(line string) string {
+	var b strings.Builder
+	limit := lineLength
+	for len(line) > limit {
+		i := limit
+		for i > 0 && !isCharStart(line[i]) {
+			i--
+		}
+		b.WriteString(line[:i])
+		b.WriteString("\r\n ")
+		line = line[i:]
+		// The space starting the next line counts too.
+		limit = lineLength - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+func isCharStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+var escaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+// escape makes text safe to use as a TEXT value.
+func escape(text string) string {
+	return escaper.Replace(text)
+}
@@ -4,11 +4,15 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/sirodoht/heartfort/chat"
 	"github.com/sirodoht/heartfort/controllers"
 	"github.com/sirodoht/heartfort/email"
 	"github.com/sirodoht/heartfort/middleware"
 	"github.com/sirodoht/heartfort/models"
 	"github.com/sirodoht/heartfort/rand"
+	"github.com/sirodoht/heartfort/ratelimit"
+	"github.com/sirodoht/heartfort/scheduler"
+	"github.com/sirodoht/heartfort/webhook"
 
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
@@ -19,10 +23,27 @@ func main() {
 	services, err := models.NewServices(
 		models.WithGorm(cfg.ConnectionInfo()),
 		models.WithLogMode(!cfg.IsProd()),
-		models.WithUser(cfg.Pepper, cfg.HMACKey),
+		models.WithUser(cfg.Pepper, cfg.HMACKey, cfg.DefaultLocale),
+		models.WithHousehold(),
+		models.WithInvitation(cfg.HMACKey),
 		models.WithJob(),
+		models.WithChecklistItem(),
 		models.WithAssignment(),
-		models.WithMate(),
+		models.WithRota(),
+		models.WithSwap(),
+		models.WithAbsence(),
+		models.WithLedger(),
+		models.WithNotification(),
+		models.WithOutbox(),
+		models.WithMate(cfg.HMACKey),
+		models.WithCalendar(cfg.HMACKey),
+		models.WithAPIToken(cfg.HMACKey),
+		models.WithWebhook(),
+		models.WithChatLink(cfg.HMACKey),
+		models.WithSession(cfg.HMACKey),
+		models.WithTwoFactor(cfg.HMACKey),
+		models.WithRateLimit(),
+		models.WithLoginAttempt(cfg.RateLimit.LockAfter, cfg.RateLimit.LockFor),
 	)
 	if err != nil {
 		panic(err)
@@ -30,79 +51,348 @@ func main() {
 	defer services.Close()
 	services.AutoMigrate()
 
-	mgCfg := cfg.Mailgun
+	fromAddress := cfg.Email.FromAddress
+	if fromAddress == "" {
+		fromAddress = "support@" + cfg.Mailgun.Domain
+	}
+	// Emails go into the outbox and its workers deliver them, so
+	// pages never wait on the mail provider.
+	outboxCfg := cfg.Outbox
+	outbox := email.NewOutbox(services.Outbox, emailTransport(cfg), email.OutboxConfig{
+		Workers:     outboxCfg.Workers,
+		MaxAttempts: outboxCfg.MaxAttempts,
+		Backoff:     outboxCfg.Backoff,
+		Poll:        outboxCfg.Poll,
+	})
+	outbox.Start()
+	defer outbox.Stop()
 	emailer := email.NewClient(
-		email.WithSender("Heartfort Support", "support@"+mgCfg.Domain),
-		email.WithMailgun(mgCfg.Domain, mgCfg.APIKey, mgCfg.PublicAPIKey),
+		email.WithSender("Heartfort Support", fromAddress),
+		email.WithBaseURL(cfg.BaseURL),
+		email.WithDefaultLocale(cfg.DefaultLocale),
+		email.WithTransport(outbox),
 	)
 
+	// Webhook deliveries are queued alongside whatever triggered
+	// them and posted by the dispatcher's workers.
+	webhooksCfg := cfg.Webhooks
+	dispatcher := webhook.NewDispatcher(services.Webhook, webhook.Config{
+		Workers:     webhooksCfg.Workers,
+		MaxAttempts: webhooksCfg.MaxAttempts,
+		Backoff:     webhooksCfg.Backoff,
+		Poll:        webhooksCfg.Poll,
+		Timeout:     webhooksCfg.Timeout,
+	})
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	// People who linked a chat get their reminders there too, and
+	// can send the bot commands.
+	var chatter scheduler.Chat
+	chatContact := ""
+	if service := chatService(cfg); service != nil {
+		chatter = scheduler.Chat{
+			Notifier: chat.NewClient(service, cfg.BaseURL),
+			Links:    services.ChatLink,
+		}
+		chatContact = cfg.Chat.Contact
+		if chatContact == "" {
+			chatContact = "the bot"
+		}
+		bot := chat.NewBot(service, services.ChatLink, services.Assignment, services.Household, services.TwoFactor, chat.BotConfig{
+			Timeout: cfg.Chat.Timeout,
+			Completed: func(assignment *models.Assignment) {
+				controllers.EmitAssignment(dispatcher, services.Assignment, webhook.EventAssignmentCompleted, assignment.ID)
+			},
+		})
+		bot.Start()
+		defer bot.Stop()
+	}
+
+	schedCfg := cfg.Scheduler
+	sched := scheduler.New(schedCfg.Interval,
+		scheduler.Reminders(services.Assignment, services.Notification, emailer, chatter, scheduler.RemindersConfig{
+			DaysBefore: schedCfg.ReminderDaysBefore,
+			NagEvery:   schedCfg.NagEveryDays,
+			MaxNags:    schedCfg.MaxNags,
+		}),
+		scheduler.Digest(services.Household, services.Assignment, services.Mate, services.Notification, emailer, chatter, scheduler.DigestConfig{
+			Weekday: schedCfg.Weekday(),
+			Hour:    schedCfg.DigestHour,
+		}),
+		scheduler.Sessions(services.Session),
+		scheduler.Outbox(services.Outbox, outboxCfg.Retention),
+		scheduler.RateLimits(services.RateLimit, cfg.RateLimit.Window),
+	)
+	sched.Start()
+	defer sched.Stop()
+
 	r := mux.NewRouter()
 	staticC := controllers.NewStatic()
-	usersC := controllers.NewUsers(services.User, emailer)
-	jobsC := controllers.NewJobs(services.Job, r)
-	assignmentsC := controllers.NewAssignments(services.Assignment, services.Job, services.DB, r)
-	matesC := controllers.NewMates(services.Mate, r)
+	usersC := controllers.NewUsers(services.User, services.Session, services.TwoFactor, services.LoginAttempt, services.Household, services.Invitation, emailer, cfg.IsProd())
+	householdsC := controllers.NewHouseholds(services.Household, r)
+	invitationsC := controllers.NewInvitations(services.Invitation, services.Household, services.TwoFactor, emailer, r)
+	jobsC := controllers.NewJobs(services.Job, dispatcher, r)
+	checklistItemsC := controllers.NewChecklistItems(services.ChecklistItem, services.Job, r)
+	assignmentsC := controllers.NewAssignments(services.Assignment, services.Job, services.Rota, services.Swap, services.Absence, services.Household, dispatcher, r)
+	fairnessC := controllers.NewFairness(services.Ledger, r)
+	absencesC := controllers.NewAbsences(services.Absence, services.Household, r)
+	swapsC := controllers.NewSwaps(services.Swap, services.Assignment, services.Household, emailer, dispatcher, r)
+	outboxC := controllers.NewOutbox(services.Outbox, r)
+	matesC := controllers.NewMates(services.Mate, services.Household, services.Notification, emailer, dispatcher, r)
+	apiTokensC := controllers.NewAPITokens(services.APIToken, services.Household, services.TwoFactor, r)
+	webhooksC := controllers.NewWebhooks(services.Webhook, dispatcher, r)
+	chatLinksC := controllers.NewChatLinks(services.ChatLink, chatContact, r)
+	sessionsC := controllers.NewSessions(services.Session, cfg.IsProd(), r)
+	twoFactorsC := controllers.NewTwoFactors(services.TwoFactor, r)
+	apiUsersC := controllers.NewAPIUsers(services.User, services.Household, emailer)
+	apiJobsC := controllers.NewAPIJobs(services.Job, dispatcher)
+	apiAssignmentsC := controllers.NewAPIAssignments(services.Assignment, services.Job, services.Household, dispatcher)
+	apiMatesC := controllers.NewAPIMates(services.Mate, services.Notification, emailer)
+	calendarsC := controllers.NewCalendars(services.Calendar, services.Assignment, services.Household, services.TwoFactor, cfg.BaseURL)
 
 	userMw := middleware.User{
-		UserService: services.User,
+		UserService:      services.User,
+		SessionService:   services.Session,
+		HouseholdService: services.Household,
 	}
 	requireUserMw := middleware.RequireUser{}
+	requireHouseholdMw := middleware.RequireHousehold{TwoFactorService: services.TwoFactor}
+	requireAdminMw := middleware.RequireAdmin{Emails: cfg.AdminEmails}
+	requireAPIUserMw := middleware.RequireAPIUser{}
+	apiHouseholdMw := middleware.APIHousehold{
+		HouseholdService: services.Household,
+		TwoFactorService: services.TwoFactor,
+	}
+	// Logging in and the forms that send emails are limited
+	// separately, so being throttled from one doesn't stop
+	// anyone resetting their password.
+	rlCfg := cfg.RateLimit
+	rlStore := rateLimitStore(cfg, services)
+	loginThrottleMw := middleware.Throttle{
+		ByIP:    ratelimit.NewLimiter(rlStore, "login:ip", ratelimit.PerWindow(rlCfg.PerIP, rlCfg.Window)),
+		ByEmail: ratelimit.NewLimiter(rlStore, "login:email", ratelimit.PerWindow(rlCfg.PerEmail, rlCfg.Window)),
+	}
+	emailThrottleMw := middleware.Throttle{
+		ByIP:    ratelimit.NewLimiter(rlStore, "email:ip", ratelimit.PerWindow(rlCfg.PerIP, rlCfg.Window)),
+		ByEmail: ratelimit.NewLimiter(rlStore, "email:email", ratelimit.PerWindow(rlCfg.PerEmail, rlCfg.Window)),
+	}
 
 	r.Handle("/", staticC.Home).Methods("GET")
-	r.Handle("/specs", staticC.Specs).Methods("GET")
+	r.HandleFunc("/specs", requireHouseholdMw.ApplyFn(jobsC.Specs)).Methods("GET")
 	r.HandleFunc("/signup", usersC.New).Methods("GET")
 	r.HandleFunc("/signup", usersC.Create).Methods("POST")
 	r.Handle("/login", usersC.LoginView).Methods("GET")
-	r.HandleFunc("/login", usersC.Login).Methods("POST")
+	r.HandleFunc("/login", loginThrottleMw.ApplyFn(usersC.Login)).Methods("POST")
+	r.HandleFunc("/login/email", emailThrottleMw.ApplyFn(usersC.SendLoginLink)).Methods("POST")
+	r.HandleFunc("/login/link", usersC.LoginLink).Methods("GET")
+	r.HandleFunc("/login/link", usersC.CompleteLoginLink).Methods("POST")
+	r.HandleFunc("/login/2fa", usersC.TwoFactor).Methods("GET")
+	r.HandleFunc("/login/2fa", loginThrottleMw.ApplyFn(usersC.CompleteTwoFactor)).Methods("POST")
 	r.Handle("/logout", requireUserMw.ApplyFn(usersC.Logout)).Methods("GET")
 	r.Handle("/forgot", usersC.ForgotPwView).Methods("GET")
-	r.HandleFunc("/forgot", usersC.InitiateReset).Methods("POST")
+	r.HandleFunc("/forgot", emailThrottleMw.ApplyFn(usersC.InitiateReset)).Methods("POST")
 	r.HandleFunc("/reset", usersC.ResetPw).Methods("GET")
 	r.HandleFunc("/reset", usersC.CompleteReset).Methods("POST")
 	r.HandleFunc("/cookies", usersC.Cookies).Methods("GET")
+	r.HandleFunc("/profile", requireUserMw.ApplyFn(usersC.Profile)).Methods("GET")
+	r.HandleFunc("/profile", requireUserMw.ApplyFn(usersC.UpdateProfile)).Methods("POST")
+	r.HandleFunc("/profile/calendar", requireUserMw.ApplyFn(calendarsC.Create)).Methods("POST")
+	r.HandleFunc("/settings/tokens", requireUserMw.ApplyFn(apiTokensC.Index)).Methods("GET").Name(controllers.IndexAPITokens)
+	r.HandleFunc("/settings/tokens", requireUserMw.ApplyFn(apiTokensC.Create)).Methods("POST")
+	r.HandleFunc("/settings/tokens/{id:[0-9]+}/delete", requireUserMw.ApplyFn(apiTokensC.Delete)).Methods("POST")
+	r.HandleFunc("/settings/chat", requireUserMw.ApplyFn(chatLinksC.Index)).Methods("GET").Name(controllers.IndexChatLink)
+	r.HandleFunc("/settings/chat", requireUserMw.ApplyFn(chatLinksC.Create)).Methods("POST")
+	r.HandleFunc("/settings/chat/unlink", requireUserMw.ApplyFn(chatLinksC.Delete)).Methods("POST")
+	r.HandleFunc("/settings/sessions", requireUserMw.ApplyFn(sessionsC.Index)).Methods("GET").Name(controllers.IndexSessions)
+	r.HandleFunc("/settings/sessions/{id:[0-9]+}/revoke", requireUserMw.ApplyFn(sessionsC.Revoke)).Methods("POST")
+	r.HandleFunc("/settings/sessions/logout", requireUserMw.ApplyFn(sessionsC.LogoutAll)).Methods("POST")
+	r.HandleFunc("/settings/2fa", requireUserMw.ApplyFn(twoFactorsC.Index)).Methods("GET").Name(controllers.IndexTwoFactor)
+	r.HandleFunc("/settings/2fa", requireUserMw.ApplyFn(twoFactorsC.Create)).Methods("POST")
+	r.HandleFunc("/settings/2fa/qr.png", requireUserMw.ApplyFn(twoFactorsC.QR)).Methods("GET")
+	r.HandleFunc("/settings/2fa/enable", requireUserMw.ApplyFn(twoFactorsC.Enable)).Methods("POST")
+	r.HandleFunc("/settings/2fa/recovery", requireUserMw.ApplyFn(twoFactorsC.RecoveryCodes)).Methods("POST")
+	r.HandleFunc("/settings/2fa/disable", requireUserMw.ApplyFn(twoFactorsC.Disable)).Methods("POST")
+	r.HandleFunc("/join", usersC.Join).Methods("GET")
+	r.HandleFunc("/join", loginThrottleMw.ApplyFn(usersC.AcceptInvite)).Methods("POST")
+
+	// Household routes
+	r.HandleFunc("/households", requireUserMw.ApplyFn(householdsC.Index)).
+		Methods("GET").
+		Name(controllers.IndexHouseholds)
+	r.HandleFunc("/households", requireUserMw.ApplyFn(householdsC.Create)).
+		Methods("POST")
+	r.HandleFunc("/households/{id:[0-9]+}/switch", requireUserMw.ApplyFn(householdsC.Switch)).
+		Methods("POST")
+
+	// Member and invitation routes
+	r.HandleFunc("/members", requireHouseholdMw.ApplyFn(invitationsC.Members)).
+		Methods("GET").
+		Name(controllers.IndexMembers)
+	r.HandleFunc("/invitations", requireHouseholdMw.ApplyFn(invitationsC.Create)).
+		Methods("POST")
+	r.HandleFunc("/invitations/{id:[0-9]+}/revoke", requireHouseholdMw.ApplyFn(invitationsC.Revoke)).
+		Methods("POST")
+	r.HandleFunc("/members/{user_id:[0-9]+}/role", requireHouseholdMw.ApplyFn(invitationsC.SetRole)).
+		Methods("POST")
+	r.HandleFunc("/members/two-factor", requireHouseholdMw.ApplyFn(invitationsC.RequireTwoFactor)).
+		Methods("POST")
+
+	// Webhook routes
+	r.HandleFunc("/webhooks", requireHouseholdMw.ApplyFn(webhooksC.Index)).
+		Methods("GET").
+		Name(controllers.IndexWebhooks)
+	r.HandleFunc("/webhooks", requireHouseholdMw.ApplyFn(webhooksC.Create)).
+		Methods("POST")
+	r.HandleFunc("/webhooks/{id:[0-9]+}", requireHouseholdMw.ApplyFn(webhooksC.Show)).
+		Methods("GET").
+		Name(controllers.ShowWebhook)
+	r.HandleFunc("/webhooks/{id:[0-9]+}/update", requireHouseholdMw.ApplyFn(webhooksC.Update)).
+		Methods("POST")
+	r.HandleFunc("/webhooks/{id:[0-9]+}/delete", requireHouseholdMw.ApplyFn(webhooksC.Delete)).
+		Methods("POST")
+	r.HandleFunc("/webhooks/{id:[0-9]+}/deliveries/{delivery_id:[0-9]+}/redeliver", requireHouseholdMw.ApplyFn(webhooksC.Redeliver)).
+		Methods("POST")
 
 	// Job routes
-	r.Handle("/jobs", requireUserMw.ApplyFn(jobsC.Index)).
+	r.Handle("/jobs", requireHouseholdMw.ApplyFn(jobsC.Index)).
 		Methods("GET").
 		Name(controllers.IndexJobs)
-	r.Handle("/jobs/new", requireUserMw.Apply(jobsC.New)).
+	r.Handle("/jobs/new", requireHouseholdMw.Apply(jobsC.New)).
 		Methods("GET")
-	r.Handle("/jobs", requireUserMw.ApplyFn(jobsC.Create)).
+	r.Handle("/jobs", requireHouseholdMw.ApplyFn(jobsC.Create)).
 		Methods("POST")
-	r.HandleFunc("/jobs/{id:[0-9]+}", jobsC.Show).
+	r.HandleFunc("/jobs/{id:[0-9]+}", requireHouseholdMw.ApplyFn(jobsC.Show)).
 		Methods("GET").
 		Name(controllers.ShowJob)
-	r.HandleFunc("/jobs/{id:[0-9]+}/edit", requireUserMw.ApplyFn(jobsC.Edit)).
+	r.HandleFunc("/jobs/{id:[0-9]+}/edit", requireHouseholdMw.ApplyFn(jobsC.Edit)).
 		Methods("GET").
 		Name(controllers.EditJob)
-	r.HandleFunc("/jobs/{id:[0-9]+}/update", requireUserMw.ApplyFn(jobsC.Update)).
+	r.HandleFunc("/jobs/{id:[0-9]+}/update", requireHouseholdMw.ApplyFn(jobsC.Update)).
 		Methods("POST")
-	r.HandleFunc("/jobs/{id:[0-9]+}/delete", requireUserMw.ApplyFn(jobsC.Delete)).
+	r.HandleFunc("/jobs/{id:[0-9]+}/delete", requireHouseholdMw.ApplyFn(jobsC.Delete)).
+		Methods("POST")
+
+	// Checklist item routes
+	r.HandleFunc("/jobs/{id:[0-9]+}/items", requireHouseholdMw.ApplyFn(checklistItemsC.Index)).
+		Methods("GET").
+		Name(controllers.IndexChecklistItems)
+	r.HandleFunc("/jobs/{id:[0-9]+}/items", requireHouseholdMw.ApplyFn(checklistItemsC.Create)).
+		Methods("POST")
+	r.HandleFunc("/jobs/{id:[0-9]+}/items/{item_id:[0-9]+}/edit", requireHouseholdMw.ApplyFn(checklistItemsC.Edit)).
+		Methods("GET").
+		Name(controllers.EditChecklistItem)
+	r.HandleFunc("/jobs/{id:[0-9]+}/items/{item_id:[0-9]+}/update", requireHouseholdMw.ApplyFn(checklistItemsC.Update)).
+		Methods("POST")
+	r.HandleFunc("/jobs/{id:[0-9]+}/items/{item_id:[0-9]+}/delete", requireHouseholdMw.ApplyFn(checklistItemsC.Delete)).
 		Methods("POST")
 
 	// Assignment routes
-	r.Handle("/assignments", requireUserMw.ApplyFn(assignmentsC.Index)).
+	r.Handle("/assignments", requireHouseholdMw.ApplyFn(assignmentsC.Index)).
 		Methods("GET").
 		Name(controllers.IndexAssignments)
-	r.Handle("/assignments/new", requireUserMw.Apply(assignmentsC.New)).
+	r.Handle("/assignments/new", requireHouseholdMw.Apply(assignmentsC.New)).
 		Methods("GET")
-	r.Handle("/assignments", requireUserMw.ApplyFn(assignmentsC.Create)).
+	r.Handle("/assignments", requireHouseholdMw.ApplyFn(assignmentsC.Create)).
+		Methods("POST")
+	r.HandleFunc("/assignments/generate", requireHouseholdMw.ApplyFn(assignmentsC.Generate)).
 		Methods("POST")
-	r.HandleFunc("/assignments/{id:[0-9]+}", assignmentsC.Show).
+	r.HandleFunc("/assignments/week", requireHouseholdMw.ApplyFn(assignmentsC.Week)).
+		Methods("GET")
+	r.HandleFunc("/assignments/{id:[0-9]+}", requireHouseholdMw.ApplyFn(assignmentsC.Show)).
 		Methods("GET").
 		Name(controllers.ShowAssignment)
-	r.HandleFunc("/assignments/{id:[0-9]+}/edit", requireUserMw.ApplyFn(assignmentsC.Edit)).
+	r.HandleFunc("/assignments/{id:[0-9]+}/edit", requireHouseholdMw.ApplyFn(assignmentsC.Edit)).
 		Methods("GET").
 		Name(controllers.EditAssignment)
-	r.HandleFunc("/assignments/{id:[0-9]+}/update", requireUserMw.ApplyFn(assignmentsC.Update)).
+	r.HandleFunc("/assignments/{id:[0-9]+}/update", requireHouseholdMw.ApplyFn(assignmentsC.Update)).
+		Methods("POST")
+	r.HandleFunc("/assignments/{id:[0-9]+}/delete", requireHouseholdMw.ApplyFn(assignmentsC.Delete)).
 		Methods("POST")
-	r.HandleFunc("/assignments/{id:[0-9]+}/delete", requireUserMw.ApplyFn(assignmentsC.Delete)).
+	r.HandleFunc("/assignments/{id:[0-9]+}/status", requireHouseholdMw.ApplyFn(assignmentsC.SetStatus)).
+		Methods("POST")
+	r.HandleFunc("/assignments/{id:[0-9]+}/items/{item_id:[0-9]+}/check", requireHouseholdMw.ApplyFn(assignmentsC.CheckItem)).
+		Methods("POST")
+
+	// Fairness routes
+	r.HandleFunc("/fairness", requireHouseholdMw.ApplyFn(fairnessC.Index)).
+		Methods("GET")
+
+	// Absence routes
+	r.HandleFunc("/absences", requireHouseholdMw.ApplyFn(absencesC.Index)).
+		Methods("GET").
+		Name(controllers.IndexAbsences)
+	r.HandleFunc("/absences/new", requireHouseholdMw.ApplyFn(absencesC.New)).
+		Methods("GET")
+	r.HandleFunc("/absences", requireHouseholdMw.ApplyFn(absencesC.Create)).
+		Methods("POST")
+	r.HandleFunc("/absences/{id:[0-9]+}/edit", requireHouseholdMw.ApplyFn(absencesC.Edit)).
+		Methods("GET").
+		Name(controllers.EditAbsence)
+	r.HandleFunc("/absences/{id:[0-9]+}/update", requireHouseholdMw.ApplyFn(absencesC.Update)).
+		Methods("POST")
+	r.HandleFunc("/absences/{id:[0-9]+}/delete", requireHouseholdMw.ApplyFn(absencesC.Delete)).
+		Methods("POST")
+
+	// Swap routes
+	r.HandleFunc("/assignments/{id:[0-9]+}/swap", requireHouseholdMw.ApplyFn(swapsC.New)).
+		Methods("GET")
+	r.HandleFunc("/assignments/{id:[0-9]+}/swap", requireHouseholdMw.ApplyFn(swapsC.Create)).
+		Methods("POST")
+	r.HandleFunc("/swaps/{id:[0-9]+}/accept", requireHouseholdMw.ApplyFn(swapsC.Accept)).
+		Methods("POST")
+	r.HandleFunc("/swaps/{id:[0-9]+}/decline", requireHouseholdMw.ApplyFn(swapsC.Decline)).
+		Methods("POST")
+	r.HandleFunc("/swaps/{id:[0-9]+}/cancel", requireHouseholdMw.ApplyFn(swapsC.Cancel)).
 		Methods("POST")
 
 	// mates routes
-	r.Handle("/notifications", matesC.New).Methods("GET")
+	r.HandleFunc("/notifications", matesC.New).Methods("GET")
 	r.HandleFunc("/mates", matesC.Create).Methods("POST")
-	r.HandleFunc("/mates", requireUserMw.ApplyFn(matesC.Index)).Methods("GET").Name(controllers.IndexMates)
+	r.HandleFunc("/mates/confirm", matesC.Confirm).Methods("GET")
+	r.HandleFunc("/mates/unsubscribe", matesC.Unsubscribe).Methods("GET")
+	r.HandleFunc("/mates/unsubscribe", matesC.DoUnsubscribe).Methods("POST")
+	r.HandleFunc("/mates", requireHouseholdMw.ApplyFn(matesC.Index)).Methods("GET").Name(controllers.IndexMates)
+	r.HandleFunc("/mates/{id:[0-9]+}", requireHouseholdMw.ApplyFn(matesC.Show)).Methods("GET").Name(controllers.ShowMate)
+	r.HandleFunc("/mates/{id:[0-9]+}/edit", requireHouseholdMw.ApplyFn(matesC.Edit)).Methods("GET").Name(controllers.EditMate)
+	r.HandleFunc("/mates/{id:[0-9]+}/update", requireHouseholdMw.ApplyFn(matesC.Update)).Methods("POST")
+	r.HandleFunc("/mates/{id:[0-9]+}/delete", requireHouseholdMw.ApplyFn(matesC.Delete)).Methods("POST")
+
+	// Calendar routes, which calendar apps fetch with the token
+	// instead of being logged in.
+	r.HandleFunc("/calendar/{token}.ics", calendarsC.Show).Methods("GET")
+	r.HandleFunc("/calendar/{token}/households/{id:[0-9]+}.ics", calendarsC.Household).Methods("GET")
+
+	// API routes
+	api := r.PathPrefix(controllers.APIPrefix).Subrouter()
+	api.HandleFunc("/user", requireAPIUserMw.ApplyFn(apiUsersC.Show)).Methods("GET")
+	api.HandleFunc("/user", requireAPIUserMw.ApplyFn(apiUsersC.Update)).Methods("PATCH")
+	api.HandleFunc("/households/{household_id:[0-9]+}/jobs", apiHouseholdMw.ApplyFn(apiJobsC.Index)).Methods("GET")
+	api.HandleFunc("/households/{household_id:[0-9]+}/jobs", apiHouseholdMw.ApplyFn(apiJobsC.Create)).Methods("POST")
+	api.HandleFunc("/households/{household_id:[0-9]+}/jobs/{id:[0-9]+}", apiHouseholdMw.ApplyFn(apiJobsC.Show)).Methods("GET")
+	api.HandleFunc("/households/{household_id:[0-9]+}/jobs/{id:[0-9]+}", apiHouseholdMw.ApplyFn(apiJobsC.Update)).Methods("PATCH")
+	api.HandleFunc("/households/{household_id:[0-9]+}/jobs/{id:[0-9]+}", apiHouseholdMw.ApplyFn(apiJobsC.Delete)).Methods("DELETE")
+	api.HandleFunc("/households/{household_id:[0-9]+}/assignments", apiHouseholdMw.ApplyFn(apiAssignmentsC.Index)).Methods("GET")
+	api.HandleFunc("/households/{household_id:[0-9]+}/assignments", apiHouseholdMw.ApplyFn(apiAssignmentsC.Create)).Methods("POST")
+	api.HandleFunc("/households/{household_id:[0-9]+}/assignments/{id:[0-9]+}", apiHouseholdMw.ApplyFn(apiAssignmentsC.Show)).Methods("GET")
+	api.HandleFunc("/households/{household_id:[0-9]+}/assignments/{id:[0-9]+}", apiHouseholdMw.ApplyFn(apiAssignmentsC.Update)).Methods("PATCH")
+	api.HandleFunc("/households/{household_id:[0-9]+}/assignments/{id:[0-9]+}", apiHouseholdMw.ApplyFn(apiAssignmentsC.Delete)).Methods("DELETE")
+	api.HandleFunc("/households/{household_id:[0-9]+}/mates", apiHouseholdMw.ApplyFn(apiMatesC.Index)).Methods("GET")
+	api.HandleFunc("/households/{household_id:[0-9]+}/mates", apiHouseholdMw.ApplyFn(apiMatesC.Create)).Methods("POST")
+	api.HandleFunc("/households/{household_id:[0-9]+}/mates/{id:[0-9]+}", apiHouseholdMw.ApplyFn(apiMatesC.Show)).Methods("GET")
+	api.HandleFunc("/households/{household_id:[0-9]+}/mates/{id:[0-9]+}", apiHouseholdMw.ApplyFn(apiMatesC.Update)).Methods("PATCH")
+	api.HandleFunc("/households/{household_id:[0-9]+}/mates/{id:[0-9]+}", apiHouseholdMw.ApplyFn(apiMatesC.Delete)).Methods("DELETE")
+
+	// Dev routes
+	if !cfg.IsProd() {
+		devC := controllers.NewDev(emailer)
+		r.HandleFunc("/dev/emails", devC.Emails).Methods("GET")
+		r.HandleFunc("/dev/emails/{name}", devC.Email).Methods("GET")
+	}
+
+	// Admin routes
+	r.HandleFunc("/admin/outbox", requireAdminMw.ApplyFn(outboxC.Index)).Methods("GET").Name(controllers.IndexOutbox)
+	r.HandleFunc("/admin/outbox/{id:[0-9]+}/resend", requireAdminMw.ApplyFn(outboxC.Resend)).Methods("POST")
 
 	// Assets
 	assetHandler := http.FileServer(http.Dir("./assets/"))
@@ -115,8 +405,82 @@ func main() {
 	}
 	// Use the config's IsProd method instead
 	csrfMw := csrf.Protect(b, csrf.Secure(cfg.IsProd()))
+	// One-click unsubscribes are posted by mail clients, which
+	// can't know the CSRF token. The signed token in the link
+	// stands in for it.
+	csrfExemptMw := middleware.CSRFExempt{
+		Paths: []string{"/mates/unsubscribe"},
+	}
+	// API clients log in with a token instead of a cookie, so
+	// they don't need CSRF tokens either.
+	bearerMw := middleware.Bearer{
+		APITokenService: services.APIToken,
+		UserService:     services.User,
+		Prefix:          controllers.APIPrefix,
+	}
+
+	var handler http.Handler = bearerMw.Apply(csrfExemptMw.Apply(csrfMw(userMw.Apply(r))))
+	if cfg.TrustProxy {
+		realIPMw := middleware.RealIP{}
+		handler = realIPMw.Apply(handler)
+	}
 
 	// Serve
 	fmt.Printf("Starting the server on :%d...\n", cfg.Port)
-	http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), csrfMw(userMw.Apply(r)))
+	http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), handler)
+}
+
+// emailTransport picks how emails are delivered from the
+// config.
+func emailTransport(cfg Config) email.Transport {
+	mgCfg := cfg.Mailgun
+	transport := cfg.Email.Transport
+	if transport == "" {
+		transport = "maildir"
+		if mgCfg.APIKey != "" {
+			transport = "mailgun"
+		}
+	}
+	switch transport {
+	case "mailgun":
+		return email.NewMailgunTransport(mgCfg.Domain, mgCfg.APIKey, mgCfg.PublicAPIKey)
+	case "smtp":
+		smtpCfg := cfg.Email.SMTP
+		return email.NewSMTPTransport(smtpCfg.Host, smtpCfg.Port, smtpCfg.Username, smtpCfg.Password)
+	case "maildir":
+		fmt.Printf("Writing emails to %s\n", cfg.Email.Maildir)
+		return email.NewMaildirTransport(cfg.Email.Maildir)
+	case "memory":
+		return &email.Recorder{}
+	default:
+		panic(fmt.Sprintf("unknown email transport %q", transport))
+	}
+}
+
+// chatService picks the chat service the bot is on, or none.
+func chatService(cfg Config) chat.Service {
+	chatCfg := cfg.Chat
+	switch chatCfg.Service {
+	case "":
+		return nil
+	case "telegram":
+		return chat.NewTelegram(chatCfg.Endpoint, chatCfg.Token)
+	case "matrix":
+		return chat.NewMatrix(chatCfg.Endpoint, chatCfg.Token, chatCfg.UserID)
+	default:
+		panic(fmt.Sprintf("unknown chat service %q", chatCfg.Service))
+	}
+}
+
+// rateLimitStore picks where rate limits are kept from the
+// config.
+func rateLimitStore(cfg Config, services *models.Services) ratelimit.Store {
+	switch cfg.RateLimit.Store {
+	case "", "memory":
+		return ratelimit.NewMemory()
+	case "database":
+		return services.RateLimit
+	default:
+		panic(fmt.Sprintf("unknown rate limit store %q", cfg.RateLimit.Store))
+	}
 }
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/csrf"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+// Bearer middleware logs API requests in with the personal
+// access token in their Authorization header. Such requests
+// carry no cookies for another site to ride on, so they are let
+// through gorilla/csrf without a token, which means Bearer has
+// to wrap the csrf middleware like CSRFExempt does. Tokens only
+// work on the paths under Prefix, requests anywhere else are
+// left alone, and read tokens can only make safe requests.
+type Bearer struct {
+	APITokenService models.APITokenService
+	UserService     models.UserService
+	Prefix          string
+}
+
+func (mw *Bearer) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *Bearer) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok || !strings.HasPrefix(r.URL.Path, mw.Prefix+"/") {
+			next(w, r)
+			return
+		}
+		apiToken, err := mw.APITokenService.Authenticate(token)
+		var user *models.User
+		if err == nil {
+			user, err = mw.UserService.ByID(apiToken.UserID)
+		}
+		if err == models.ErrTokenInvalid || err == models.ErrNotFound {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			views.RenderJSONMessage(w, http.StatusUnauthorized, "Token is not valid, it may have expired or been deleted")
+			return
+		}
+		if err != nil {
+			views.RenderJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !safeMethod(r.Method) && !apiToken.Allows(models.ScopeWrite) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="write"`)
+			views.RenderJSONMessage(w, http.StatusForbidden, "Token is read only")
+			return
+		}
+		ctx := context.WithUser(r.Context(), user)
+		ctx = context.WithAPIToken(ctx, apiToken)
+		r = csrf.UnsafeSkipCheck(r.WithContext(ctx))
+		next(w, r)
+	})
+}
+
+// bearerToken returns the token from the Authorization header
+// of the request, if it has one.
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	const scheme = "bearer "
+	if len(auth) <= len(scheme) || !strings.EqualFold(auth[:len(scheme)], scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(scheme):]), true
+}
+
+// safeMethod reports whether requests with the method only
+// look and never change anything.
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
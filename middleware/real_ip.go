@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP middleware sets the request's RemoteAddr to the address
+// of the client rather than the proxy in front of us, from the
+// X-Real-IP or else the X-Forwarded-For header the proxy added.
+// Anyone can send those headers, so it is only for servers that
+// can't be reached other than through the proxy.
+type RealIP struct{}
+
+func (mw *RealIP) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *RealIP) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := forwardedIP(r); ip != "" {
+			r.RemoteAddr = net.JoinHostPort(ip, "0")
+		}
+		next(w, r)
+	})
+}
+
+// forwardedIP returns the client address the proxy passed on,
+// or "" if there isn't a valid one. The last X-Forwarded-For
+// address is the one our proxy added, the others came from the
+// client.
+func forwardedIP(r *http.Request) string {
+	ip := strings.TrimSpace(r.Header.Get("X-Real-IP"))
+	if ip == "" {
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		ip = strings.TrimSpace(forwarded[len(forwarded)-1])
+	}
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	return ip
+}
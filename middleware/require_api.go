@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sirodoht/heartfort/context"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+// RequireAPIUser is RequireUser for the API, answering with a
+// JSON 401 instead of redirecting to the login page.
+type RequireAPIUser struct{}
+
+func (mw *RequireAPIUser) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *RequireAPIUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if context.User(r.Context()) == nil {
+			views.RenderJSONMessage(w, http.StatusUnauthorized, "You need to log in")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// APIHousehold makes the household named by the household_id
+// in the route the current household for the request, in place
+// of the one picked by the household cookie, so API clients
+// don't need to switch households first. Users who aren't
+// members get a 404 as if the household didn't exist, and
+// members who haven't turned on the two-factor authentication
+// the household requires get a 403. Requests made with a
+// personal access token only get the role the token's scope
+// allows. Like RequireUser it assumes that User middleware has
+// already been run.
+type APIHousehold struct {
+	HouseholdService models.HouseholdService
+	TwoFactorService models.TwoFactorService
+}
+
+func (mw *APIHousehold) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *APIHousehold) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if user == nil {
+			views.RenderJSONMessage(w, http.StatusUnauthorized, "You need to log in")
+			return
+		}
+		id, err := strconv.Atoi(mux.Vars(r)["household_id"])
+		if err != nil {
+			views.RenderJSONError(w, http.StatusNotFound, models.ErrNotFound)
+			return
+		}
+		membership, err := mw.HouseholdService.Membership(uint(id), user.ID)
+		if err == models.ErrNotFound {
+			views.RenderJSONError(w, http.StatusNotFound, models.ErrNotFound)
+			return
+		}
+		if err != nil {
+			views.RenderJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		household, err := mw.HouseholdService.ByID(membership.HouseholdID)
+		if err != nil {
+			views.RenderJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		allowed, err := mw.TwoFactorService.Allowed(household, user.ID)
+		if err != nil {
+			views.RenderJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !allowed {
+			views.RenderJSONMessage(w, http.StatusForbidden, household.Name+" requires two-factor authentication. Please turn it on to carry on.")
+			return
+		}
+		if apiToken := context.APIToken(r.Context()); apiToken != nil {
+			membership = apiToken.Limit(membership)
+		}
+		ctx := context.WithHousehold(r.Context(), household)
+		ctx = context.WithMembership(ctx, membership)
+		next(w, r.WithContext(ctx))
+	})
+}
@@ -1,19 +1,36 @@
 package middleware
 
 import (
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/sirodoht/heartfort/context"
 	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
 )
 
-// User middleware will lookup the current user via their
-// remember_token cookie using the UserService. If the user
-// is found, they will be set on the request context.
+// SessionCookie holds the token of the session the browser is
+// logged in with.
+const SessionCookie = "session"
+
+// HouseholdCookie holds the ID of the household the user has
+// switched to.
+const HouseholdCookie = "household_id"
+
+// User middleware will lookup the current user via the
+// session in their session cookie using the SessionService. If
+// the session is found, it and its user will be set on the
+// request context along with their active household, which is
+// the one named by the household_id cookie or else the first
+// one they belong to, and their membership of it. Requests
+// already logged in by Bearer middleware are left as they are.
 // Regardless, the next handler is always called.
 type User struct {
 	models.UserService
+	SessionService   models.SessionService
+	HouseholdService models.HouseholdService
 }
 
 func (mw *User) Apply(next http.Handler) http.HandlerFunc {
@@ -28,23 +45,67 @@ func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 			next(w, r)
 			return
 		}
-		cookie, err := r.Cookie("remember_token")
+		if context.User(r.Context()) != nil {
+			next(w, r)
+			return
+		}
+		cookie, err := r.Cookie(SessionCookie)
 		if err != nil {
 			next(w, r)
 			return
 		}
-		user, err := mw.UserService.ByRemember(cookie.Value)
+		session, err := mw.SessionService.Authenticate(cookie.Value)
 		if err != nil {
+			if err != models.ErrTokenInvalid {
+				log.Println(err)
+			}
+			next(w, r)
+			return
+		}
+		user, err := mw.UserService.ByID(session.UserID)
+		if err != nil {
+			if err != models.ErrNotFound {
+				log.Println(err)
+			}
 			next(w, r)
 			return
 		}
 		ctx := r.Context()
 		ctx = context.WithUser(ctx, user)
+		ctx = context.WithSession(ctx, session)
+		if household := mw.activeHousehold(r, user); household != nil {
+			membership, err := mw.HouseholdService.Membership(household.ID, user.ID)
+			if err == nil {
+				ctx = context.WithHousehold(ctx, household)
+				ctx = context.WithMembership(ctx, membership)
+			} else {
+				log.Println(err)
+			}
+		}
 		r = r.WithContext(ctx)
 		next(w, r)
 	})
 }
 
+func (mw *User) activeHousehold(r *http.Request, user *models.User) *models.Household {
+	households, err := mw.HouseholdService.ByUserID(user.ID)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	if len(households) == 0 {
+		return nil
+	}
+	if cookie, err := r.Cookie(HouseholdCookie); err == nil {
+		for i := range households {
+			if strconv.Itoa(int(households[i].ID)) == cookie.Value {
+				return &households[i]
+			}
+		}
+	}
+	return &households[0]
+}
+
 // RequireUser will redirect a user to the /login page
 // if they are not logged in. This middleware assumes
 // that User middleware has already been run, otherwise
@@ -65,3 +126,78 @@ func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 		next(w, r)
 	})
 }
+
+// RequireHousehold will redirect a user to the /login page if
+// they are not logged in, or to the /households page if they
+// don't belong to any household yet. If their household requires
+// two-factor authentication and they haven't turned it on, they
+// are sent to turn it on first. Like RequireUser it assumes that
+// User middleware has already been run.
+type RequireHousehold struct {
+	TwoFactorService models.TwoFactorService
+}
+
+func (mw *RequireHousehold) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *RequireHousehold) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if context.User(r.Context()) == nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		household := context.Household(r.Context())
+		if household == nil {
+			http.Redirect(w, r, "/households", http.StatusFound)
+			return
+		}
+		if mw.TwoFactorService != nil {
+			user := context.User(r.Context())
+			allowed, err := mw.TwoFactorService.Allowed(household, user.ID)
+			if err != nil {
+				log.Println(err)
+				http.Error(w, "Something went wrong.", http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				views.RedirectAlert(w, r, "/settings/2fa", http.StatusFound, views.Alert{
+					Level:   views.AlertLvlWarning,
+					Message: household.Name + " requires two-factor authentication. Please turn it on to carry on.",
+				})
+				return
+			}
+		}
+		next(w, r)
+	})
+}
+
+// RequireAdmin only lets the site admins, who are the users
+// with one of the given email addresses, through. Everyone else
+// gets a 404 so the admin pages don't give themselves away.
+// Like RequireUser it assumes that User middleware has already
+// been run.
+type RequireAdmin struct {
+	Emails []string
+}
+
+func (mw *RequireAdmin) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *RequireAdmin) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if user == nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		for _, email := range mw.Emails {
+			if strings.EqualFold(strings.TrimSpace(email), user.Email) {
+				next(w, r)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirodoht/heartfort/ratelimit"
+)
+
+// Throttle middleware limits how often a form can be posted
+// from the same IP address, and for the same email address if
+// the form has one, so passwords can't be guessed and emails
+// can't be sent without end. Requests over either limit get a
+// 429 with a Retry-After header instead. If the limiters can't
+// be checked the request is let through, rather than nobody
+// being able to log in.
+type Throttle struct {
+	ByIP    *ratelimit.Limiter
+	ByEmail *ratelimit.Limiter
+}
+
+func (mw *Throttle) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *Throttle) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := ClientIP(r)
+		retryAfter, ok := mw.allow(mw.ByIP, ip)
+		if ok && mw.ByEmail != nil {
+			if email := strings.ToLower(strings.TrimSpace(r.PostFormValue("email"))); email != "" {
+				retryAfter, ok = mw.allow(mw.ByEmail, email)
+			}
+		}
+		if !ok {
+			log.Printf("throttled %s %s from %s", r.Method, r.URL.Path, ip)
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			http.Error(w, "Too many attempts, please try again in a few minutes.", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (mw *Throttle) allow(limiter *ratelimit.Limiter, key string) (time.Duration, bool) {
+	if limiter == nil {
+		return 0, true
+	}
+	retryAfter, ok, err := limiter.Allow(key)
+	if err != nil {
+		log.Println(err)
+		return 0, true
+	}
+	return retryAfter, ok
+}
+
+// ClientIP returns the address the request came from, which is
+// the client's own when RealIP middleware is in use.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
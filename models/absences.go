@@ -0,0 +1,231 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	ErrStartDateRequired modelError = "models: start date is required"
+
+	ErrEndDateRequired modelError = "models: end date is required"
+
+	ErrAbsenceEndsEarly modelError = "models: absence can't end before it starts"
+)
+
+// Absence represents the absences table in our DB and is a user
+// being away from the household. Both dates are whole days and
+// the end date is the last day they are away, so a single day
+// starts and ends on the same date.
+type Absence struct {
+	gorm.Model
+	HouseholdID uint      `gorm:"index"`
+	UserID      uint      `gorm:"not null;index"`
+	User        User      `gorm:"save_associations:false"`
+	StartDate   time.Time `gorm:"not null"`
+	EndDate     time.Time `gorm:"not null"`
+	Reason      string
+}
+
+// Overlaps reports whether the user is away on any day from
+// from up to, but not including, to.
+func (a *Absence) Overlaps(from, to time.Time) bool {
+	return a.StartDate.Before(to) && !a.EndDate.Before(from)
+}
+
+func NewAbsenceService(db *gorm.DB) AbsenceService {
+	return &absenceService{
+		AbsenceDB: &absenceValidator{
+			AbsenceDB: &absenceGorm{
+				db: db,
+			},
+		},
+	}
+}
+
+type AbsenceService interface {
+	AbsenceDB
+}
+
+var _ AbsenceService = &absenceService{}
+
+type absenceService struct {
+	AbsenceDB
+}
+
+// AbsenceDB is used to interact with the absences database.
+type AbsenceDB interface {
+	ByID(id uint) (*Absence, error)
+	// ByHouseholdID returns the absences of a household with the
+	// latest first.
+	ByHouseholdID(householdID uint) ([]Absence, error)
+	// Overlapping returns the absences of a household that cover
+	// any day from from up to, but not including, to.
+	Overlapping(householdID uint, from, to time.Time) ([]Absence, error)
+	Create(absence *Absence) error
+	Update(absence *Absence) error
+	Delete(id uint) error
+}
+
+type absenceValidator struct {
+	AbsenceDB
+}
+
+func (av *absenceValidator) Create(absence *Absence) error {
+	err := runAbsenceValFns(absence,
+		av.householdIDRequired,
+		av.userIDRequired,
+		av.datesRequired,
+		av.truncateDates,
+		av.endNotBeforeStart,
+		av.normalizeReason,
+	)
+	if err != nil {
+		return err
+	}
+	return av.AbsenceDB.Create(absence)
+}
+
+func (av *absenceValidator) Update(absence *Absence) error {
+	err := runAbsenceValFns(absence,
+		av.householdIDRequired,
+		av.userIDRequired,
+		av.datesRequired,
+		av.truncateDates,
+		av.endNotBeforeStart,
+		av.normalizeReason,
+	)
+	if err != nil {
+		return err
+	}
+	return av.AbsenceDB.Update(absence)
+}
+
+func (av *absenceValidator) Delete(id uint) error {
+	var absence Absence
+	absence.ID = id
+	if err := runAbsenceValFns(&absence, av.nonZeroID); err != nil {
+		return err
+	}
+	return av.AbsenceDB.Delete(absence.ID)
+}
+
+func (av *absenceValidator) householdIDRequired(absence *Absence) error {
+	if absence.HouseholdID <= 0 {
+		return ErrHouseholdIDRequired
+	}
+	return nil
+}
+
+func (av *absenceValidator) userIDRequired(absence *Absence) error {
+	if absence.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (av *absenceValidator) datesRequired(absence *Absence) error {
+	if absence.StartDate.IsZero() {
+		return ErrStartDateRequired
+	}
+	if absence.EndDate.IsZero() {
+		return ErrEndDateRequired
+	}
+	return nil
+}
+
+// truncateDates drops any time of day so the dates line up
+// with the week starts of the rota.
+func (av *absenceValidator) truncateDates(absence *Absence) error {
+	absence.StartDate = day(absence.StartDate)
+	absence.EndDate = day(absence.EndDate)
+	return nil
+}
+
+func (av *absenceValidator) endNotBeforeStart(absence *Absence) error {
+	if absence.EndDate.Before(absence.StartDate) {
+		return ErrAbsenceEndsEarly
+	}
+	return nil
+}
+
+func (av *absenceValidator) normalizeReason(absence *Absence) error {
+	absence.Reason = strings.TrimSpace(absence.Reason)
+	return nil
+}
+
+func (av *absenceValidator) nonZeroID(absence *Absence) error {
+	if absence.ID <= 0 {
+		return ErrIDInvalid
+	}
+	return nil
+}
+
+// day returns midnight UTC of the day t falls on.
+func day(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+type absenceValFn func(*Absence) error
+
+func runAbsenceValFns(absence *Absence, fns ...absenceValFn) error {
+	for _, fn := range fns {
+		if err := fn(absence); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ AbsenceDB = &absenceGorm{}
+
+type absenceGorm struct {
+	db *gorm.DB
+}
+
+func (ag *absenceGorm) ByID(id uint) (*Absence, error) {
+	var absence Absence
+	err := first(ag.db.Preload("User").Where("id = ?", id), &absence)
+	if err != nil {
+		return nil, err
+	}
+	return &absence, nil
+}
+
+func (ag *absenceGorm) ByHouseholdID(householdID uint) ([]Absence, error) {
+	var absences []Absence
+	db := ag.db.Preload("User").
+		Where("household_id = ?", householdID).
+		Order("start_date DESC, id DESC")
+	if err := db.Find(&absences).Error; err != nil {
+		return nil, err
+	}
+	return absences, nil
+}
+
+func (ag *absenceGorm) Overlapping(householdID uint, from, to time.Time) ([]Absence, error) {
+	var absences []Absence
+	db := ag.db.Preload("User").
+		Where("household_id = ? AND start_date < ? AND end_date >= ?", householdID, to, from).
+		Order("start_date, id")
+	if err := db.Find(&absences).Error; err != nil {
+		return nil, err
+	}
+	return absences, nil
+}
+
+func (ag *absenceGorm) Create(absence *Absence) error {
+	return ag.db.Create(absence).Error
+}
+
+func (ag *absenceGorm) Update(absence *Absence) error {
+	return ag.db.Save(absence).Error
+}
+
+func (ag *absenceGorm) Delete(id uint) error {
+	absence := Absence{Model: gorm.Model{ID: id}}
+	return ag.db.Delete(&absence).Error
+}
@@ -0,0 +1,303 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/sirodoht/heartfort/hash"
+	"github.com/sirodoht/heartfort/rand"
+)
+
+const (
+	ErrScopeInvalid modelError = "models: scope must be read, write or admin"
+
+	ErrExpiresInPast modelError = "models: expiry date must be in the future"
+)
+
+// The scopes a token can have, from the least it can do to the
+// most. Read tokens can only look, write tokens can change what
+// a member can change and admin tokens can do everything the
+// user can.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// Scopes lists every scope from the least powerful to the most.
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeAdmin}
+
+var scopeRanks = map[string]int{
+	ScopeRead:  1,
+	ScopeWrite: 2,
+	ScopeAdmin: 3,
+}
+
+// APITokenPrefix starts every token so they are easy to spot,
+// say when one is pasted somewhere it shouldn't be.
+const APITokenPrefix = "hft_"
+
+// apiTokenTouchEvery is how stale LastUsedAt can get before
+// using the token updates it, so busy scripts don't write to
+// the database on every request.
+const apiTokenTouchEvery = time.Minute
+
+// APIToken represents the api_tokens table in our DB and is a
+// personal access token a user made for a script or app to use
+// the API as them. Only the hash of the token is kept, so it
+// can only be shown when it is made. Tokens without ExpiresAt
+// work until they are deleted.
+type APIToken struct {
+	ID         uint   `gorm:"primary_key"`
+	UserID     uint   `gorm:"not null;index"`
+	Name       string `gorm:"not null"`
+	Scope      string `gorm:"not null;default:'read'"`
+	Token      string `gorm:"-"`
+	TokenHash  string `gorm:"not null;unique_index"`
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+	CreatedAt  time.Time
+}
+
+// Allows reports whether the token's scope is the given scope
+// or a more powerful one.
+func (t *APIToken) Allows(scope string) bool {
+	return scopeRanks[t.Scope] >= scopeRanks[scope]
+}
+
+// Expired reports whether the token has stopped working.
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now())
+}
+
+// Limit returns the membership as far as the token is allowed
+// to use it. Read tokens act as guests and write tokens as
+// members at most, whatever the user's real role is.
+func (t *APIToken) Limit(m *Membership) *Membership {
+	limit := RoleOwner
+	switch {
+	case !t.Allows(ScopeWrite):
+		limit = RoleGuest
+	case !t.Allows(ScopeAdmin):
+		limit = RoleMember
+	}
+	if m == nil || !m.AtLeast(limit) {
+		return m
+	}
+	limited := *m
+	limited.Role = limit
+	return &limited
+}
+
+func NewAPITokenService(db *gorm.DB, hmacKey string) APITokenService {
+	return &apiTokenService{
+		APITokenDB: &apiTokenValidator{
+			APITokenDB: &apiTokenGorm{db},
+			hmac:       hash.NewHMAC(hmacKey),
+		},
+	}
+}
+
+type APITokenService interface {
+	// Authenticate looks up the token sent by an API client and
+	// records that it was used. ErrTokenInvalid is returned if
+	// there is no such token or it has expired.
+	Authenticate(token string) (*APIToken, error)
+	APITokenDB
+}
+
+var _ APITokenService = &apiTokenService{}
+
+type apiTokenService struct {
+	APITokenDB
+}
+
+func (ats *apiTokenService) Authenticate(token string) (*APIToken, error) {
+	apiToken, err := ats.ByToken(token)
+	if err == ErrNotFound {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if apiToken.Expired() {
+		return nil, ErrTokenInvalid
+	}
+	now := time.Now()
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) > apiTokenTouchEvery {
+		if err := ats.Touch(apiToken.ID, now); err != nil {
+			return nil, err
+		}
+		apiToken.LastUsedAt = &now
+	}
+	return apiToken, nil
+}
+
+// APITokenDB is used to interact with the api_tokens database.
+type APITokenDB interface {
+	ByID(id uint) (*APIToken, error)
+	ByToken(token string) (*APIToken, error)
+	// ByUserID returns the user's tokens with the newest first.
+	ByUserID(userID uint) ([]APIToken, error)
+	Create(apiToken *APIToken) error
+	// Touch sets when the token was last used.
+	Touch(id uint, at time.Time) error
+	Delete(id uint) error
+}
+
+type apiTokenValidator struct {
+	APITokenDB
+	hmac hash.HMAC
+}
+
+func (atv *apiTokenValidator) ByToken(token string) (*APIToken, error) {
+	apiToken := APIToken{Token: token}
+	if err := runAPITokenValFns(&apiToken, atv.hmacToken); err != nil {
+		return nil, err
+	}
+	if apiToken.TokenHash == "" {
+		return nil, ErrNotFound
+	}
+	return atv.APITokenDB.ByToken(apiToken.TokenHash)
+}
+
+func (atv *apiTokenValidator) Create(apiToken *APIToken) error {
+	err := runAPITokenValFns(apiToken,
+		atv.requireUserID,
+		atv.normalizeName,
+		atv.nameRequired,
+		atv.scopeValid,
+		atv.expiresInFuture,
+		atv.setTokenIfUnset,
+		atv.hmacToken,
+	)
+	if err != nil {
+		return err
+	}
+	return atv.APITokenDB.Create(apiToken)
+}
+
+func (atv *apiTokenValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return atv.APITokenDB.Delete(id)
+}
+
+func (atv *apiTokenValidator) requireUserID(apiToken *APIToken) error {
+	if apiToken.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (atv *apiTokenValidator) normalizeName(apiToken *APIToken) error {
+	apiToken.Name = strings.TrimSpace(apiToken.Name)
+	return nil
+}
+
+func (atv *apiTokenValidator) nameRequired(apiToken *APIToken) error {
+	if apiToken.Name == "" {
+		return ErrNameRequired
+	}
+	return nil
+}
+
+func (atv *apiTokenValidator) scopeValid(apiToken *APIToken) error {
+	if apiToken.Scope == "" {
+		apiToken.Scope = ScopeRead
+	}
+	if _, ok := scopeRanks[apiToken.Scope]; !ok {
+		return ErrScopeInvalid
+	}
+	return nil
+}
+
+func (atv *apiTokenValidator) expiresInFuture(apiToken *APIToken) error {
+	if apiToken.Expired() {
+		return ErrExpiresInPast
+	}
+	return nil
+}
+
+func (atv *apiTokenValidator) setTokenIfUnset(apiToken *APIToken) error {
+	if apiToken.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	apiToken.Token = APITokenPrefix + token
+	return nil
+}
+
+func (atv *apiTokenValidator) hmacToken(apiToken *APIToken) error {
+	if apiToken.Token == "" {
+		return nil
+	}
+	apiToken.TokenHash = atv.hmac.Hash(apiToken.Token)
+	return nil
+}
+
+type apiTokenValFn func(*APIToken) error
+
+func runAPITokenValFns(apiToken *APIToken, fns ...apiTokenValFn) error {
+	for _, fn := range fns {
+		if err := fn(apiToken); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ APITokenDB = &apiTokenGorm{}
+
+type apiTokenGorm struct {
+	db *gorm.DB
+}
+
+func (atg *apiTokenGorm) ByID(id uint) (*APIToken, error) {
+	var apiToken APIToken
+	err := first(atg.db.Where("id = ?", id), &apiToken)
+	if err != nil {
+		return nil, err
+	}
+	return &apiToken, nil
+}
+
+func (atg *apiTokenGorm) ByToken(tokenHash string) (*APIToken, error) {
+	var apiToken APIToken
+	err := first(atg.db.Where("token_hash = ?", tokenHash), &apiToken)
+	if err != nil {
+		return nil, err
+	}
+	return &apiToken, nil
+}
+
+func (atg *apiTokenGorm) ByUserID(userID uint) ([]APIToken, error) {
+	var apiTokens []APIToken
+	err := atg.db.Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&apiTokens).Error
+	if err != nil {
+		return nil, err
+	}
+	return apiTokens, nil
+}
+
+func (atg *apiTokenGorm) Create(apiToken *APIToken) error {
+	return atg.db.Create(apiToken).Error
+}
+
+func (atg *apiTokenGorm) Touch(id uint, at time.Time) error {
+	return atg.db.Model(&APIToken{}).
+		Where("id = ?", id).
+		UpdateColumn("last_used_at", at).Error
+}
+
+func (atg *apiTokenGorm) Delete(id uint) error {
+	return atg.db.Delete(&APIToken{ID: id}).Error
+}
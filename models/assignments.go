@@ -8,15 +8,89 @@ import (
 
 const (
 	ErrJobIDRequired modelError = "models: job ID is required"
+
+	ErrJobInvalid modelError = "models: job is not one of this household's jobs"
+
+	ErrStatusInvalid modelError = "models: status is not valid"
+
+	ErrStatusChange modelError = "models: assignment can't be moved to that status from where it is"
+
+	ErrItemNotInJob modelError = "models: checklist item is not part of this job"
+)
+
+// The statuses an assignment goes through. Every assignment
+// starts out pending.
+const (
+	StatusPending    = "pending"
+	StatusInProgress = "in_progress"
+	StatusDone       = "done"
+	StatusSkipped    = "skipped"
+	StatusMissed     = "missed"
 )
 
+// statusChanges lists which statuses an assignment can move to
+// from each status. Finished assignments can only be reopened.
+var statusChanges = map[string][]string{
+	StatusPending:    {StatusInProgress, StatusDone, StatusSkipped, StatusMissed},
+	StatusInProgress: {StatusPending, StatusDone, StatusSkipped, StatusMissed},
+	StatusDone:       {StatusPending},
+	StatusSkipped:    {StatusPending},
+	StatusMissed:     {StatusPending},
+}
+
+var statusLabels = map[string]string{
+	StatusPending:    "Pending",
+	StatusInProgress: "In progress",
+	StatusDone:       "Done",
+	StatusSkipped:    "Skipped",
+	StatusMissed:     "Missed",
+}
+
 // Assignment represents the assignments table in our DB and is
-// when a user is assigned to a assignment.
+// when a user is assigned to a assignment. StartedAt is set when
+// work on it begins and FinishedAt when it is done, skipped or
+// missed, along with whoever finished it.
 type Assignment struct {
 	gorm.Model
-	UserID    uint `gorm:"not_null"`
-	Job       Job  `gorm:"not_null"`
-	WeekStart *time.Time
+	HouseholdID  uint `gorm:"index"`
+	UserID       uint `gorm:"not_null"`
+	User         User `gorm:"save_associations:false"`
+	JobID        uint `gorm:"not_null"`
+	Job          Job  `gorm:"save_associations:false"`
+	WeekStart    *time.Time
+	Status       string `gorm:"not null;default:'pending'"`
+	StartedAt    *time.Time
+	FinishedAt   *time.Time
+	FinishedByID *uint
+	FinishedBy   *User       `gorm:"save_associations:false"`
+	Checks       []ItemCheck `gorm:"save_associations:false"`
+}
+
+// StatusLabel is the status written out for people.
+func (a *Assignment) StatusLabel() string {
+	return statusLabels[a.Status]
+}
+
+// CanMoveTo reports whether the assignment is allowed to move
+// to status from the one it is in now.
+func (a *Assignment) CanMoveTo(status string) bool {
+	for _, next := range statusChanges[a.Status] {
+		if next == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Checked reports whether the checklist item with the given ID
+// has been ticked off for this assignment.
+func (a *Assignment) Checked(itemID uint) bool {
+	for _, check := range a.Checks {
+		if check.ChecklistItemID == itemID {
+			return true
+		}
+	}
+	return false
 }
 
 func NewAssignmentService(db *gorm.DB) AssignmentService {
@@ -26,22 +100,144 @@ func NewAssignmentService(db *gorm.DB) AssignmentService {
 				db: db,
 			},
 		},
+		itemCheckDB: &itemCheckGorm{db},
+		db:          db,
 	}
 }
 
 type AssignmentService interface {
+	// SetStatus moves the assignment to the given status on
+	// behalf of the user with userID and records when that
+	// happened. Finishing it credits the assignee with the
+	// effort of its job and reopening it takes that back.
+	// ErrStatusChange is returned if the assignment can't get
+	// there from its current status.
+	SetStatus(assignment *Assignment, status string, userID uint) error
+	// CheckItem ticks off, or unticks when checked is false, a
+	// checklist item of the assignment's job. Ticking an item
+	// off a pending assignment means work on it has started.
+	CheckItem(assignment *Assignment, itemID, userID uint, checked bool) error
 	AssignmentDB
 }
 
+var _ AssignmentService = &assignmentService{}
+
 type assignmentService struct {
 	AssignmentDB
+	itemCheckDB itemCheckDB
+	db          *gorm.DB
+}
+
+func (as *assignmentService) SetStatus(assignment *Assignment, status string, userID uint) error {
+	if _, ok := statusChanges[status]; !ok {
+		return ErrStatusInvalid
+	}
+	if !assignment.CanMoveTo(status) {
+		return ErrStatusChange
+	}
+	now := time.Now()
+	switch status {
+	case StatusPending:
+		assignment.StartedAt = nil
+		assignment.FinishedAt = nil
+		assignment.FinishedByID = nil
+	case StatusInProgress:
+		assignment.StartedAt = &now
+	default:
+		if assignment.StartedAt == nil && status == StatusDone {
+			assignment.StartedAt = &now
+		}
+		assignment.FinishedAt = &now
+		assignment.FinishedByID = &userID
+	}
+	wasDone := assignment.Status == StatusDone
+	assignment.Status = status
+	// The status and the ledger change together, or the ledger
+	// would stop adding up to the assignments that are done.
+	return as.db.Transaction(func(tx *gorm.DB) error {
+		adb := &assignmentValidator{AssignmentDB: &assignmentGorm{db: tx}}
+		if err := adb.Update(assignment); err != nil {
+			return err
+		}
+		ldb := &ledgerGorm{tx}
+		switch {
+		case status == StatusDone:
+			return ldb.Credit(ledgerEntry(assignment))
+		case wasDone:
+			return ldb.Debit(assignment.ID)
+		}
+		return nil
+	})
+}
+
+// Delete also takes any points for the assignment off the
+// ledger.
+func (as *assignmentService) Delete(id uint) error {
+	return as.db.Transaction(func(tx *gorm.DB) error {
+		adb := &assignmentValidator{AssignmentDB: &assignmentGorm{db: tx}}
+		if err := adb.Delete(id); err != nil {
+			return err
+		}
+		return (&ledgerGorm{tx}).Debit(id)
+	})
+}
+
+// ledgerEntry is what the assignee earns for a finished
+// assignment. Assignments without a week count for the week
+// they were finished in.
+func ledgerEntry(assignment *Assignment) *LedgerEntry {
+	weekStart := WeekOf(time.Now())
+	if assignment.WeekStart != nil {
+		weekStart = WeekOf(*assignment.WeekStart)
+	}
+	points := assignment.Job.Effort
+	if points < 1 {
+		points = 1
+	}
+	return &LedgerEntry{
+		HouseholdID:  assignment.HouseholdID,
+		UserID:       assignment.UserID,
+		AssignmentID: assignment.ID,
+		WeekStart:    weekStart,
+		Points:       points,
+	}
+}
+
+func (as *assignmentService) CheckItem(assignment *Assignment, itemID, userID uint, checked bool) error {
+	if !assignment.Job.hasItem(itemID) {
+		return ErrItemNotInJob
+	}
+	if !checked {
+		return as.itemCheckDB.Delete(assignment.ID, itemID)
+	}
+	if assignment.Checked(itemID) {
+		return nil
+	}
+	check := ItemCheck{
+		AssignmentID:    assignment.ID,
+		ChecklistItemID: itemID,
+		UserID:          userID,
+	}
+	if err := as.itemCheckDB.Create(&check); err != nil {
+		return err
+	}
+	assignment.Checks = append(assignment.Checks, check)
+	if assignment.Status == StatusPending {
+		return as.SetStatus(assignment, StatusInProgress, userID)
+	}
+	return nil
 }
 
 // AssignmentDB is used to interact with the assignments database.
 type AssignmentDB interface {
 	ByID(id uint) (*Assignment, error)
 	ByUserID(userID uint) ([]Assignment, error)
-	List() ([]Assignment, error)
+	ByHouseholdID(householdID uint) ([]Assignment, error)
+	ByWeek(householdID uint, weekStart time.Time) ([]Assignment, error)
+	// Unfinished returns the pending and in progress
+	// assignments of every household whose week starts after
+	// after and no later than until.
+	Unfinished(after, until time.Time) ([]Assignment, error)
 	Create(assignment *Assignment) error
 	Update(assignment *Assignment) error
 	Delete(id uint) error
@@ -53,7 +249,13 @@ type assignmentValidator struct {
 }
 
 func (av *assignmentValidator) Create(assignment *Assignment) error {
-	err := runAssignmentValFns(assignment, av.userIDRequired, av.jobRequired)
+	err := runAssignmentValFns(assignment,
+		av.householdIDRequired,
+		av.userIDRequired,
+		av.jobRequired,
+		av.setStatusIfUnset,
+		av.statusValid,
+	)
 	if err != nil {
 		return err
 	}
@@ -61,7 +263,13 @@ func (av *assignmentValidator) Create(assignment *Assignment) error {
 }
 
 func (av *assignmentValidator) Update(assignment *Assignment) error {
-	err := runAssignmentValFns(assignment, av.userIDRequired, av.jobRequired)
+	err := runAssignmentValFns(assignment,
+		av.householdIDRequired,
+		av.userIDRequired,
+		av.jobRequired,
+		av.setStatusIfUnset,
+		av.statusValid,
+	)
 	if err != nil {
 		return err
 	}
@@ -85,7 +293,7 @@ type assignmentGorm struct {
 
 func (ag *assignmentGorm) ByID(id uint) (*Assignment, error) {
 	var assignment Assignment
-	db := ag.db.Where("id = ?", id)
+	db := ag.preload().Where("id = ?", id)
 	err := first(db, &assignment)
 	if err != nil {
 		return nil, err
@@ -95,16 +303,43 @@ func (ag *assignmentGorm) ByID(id uint) (*Assignment, error) {
 
 func (ag *assignmentGorm) ByUserID(userID uint) ([]Assignment, error) {
 	var assignments []Assignment
-	db := ag.db.Where("user_id = ?", userID)
+	db := ag.preload().Where("user_id = ?", userID)
 	if err := db.Find(&assignments).Error; err != nil {
 		return nil, err
 	}
 	return assignments, nil
 }
 
-func (ag *assignmentGorm) List() ([]Assignment, error) {
+func (ag *assignmentGorm) ByWeek(householdID uint, weekStart time.Time) ([]Assignment, error) {
 	var assignments []Assignment
-	if err := ag.db.Find(&assignments).Error; err != nil {
+	db := ag.preload().
+		Where("household_id = ? AND week_start = ?", householdID, weekStart).
+		Order("job_id")
+	if err := db.Find(&assignments).Error; err != nil {
+		return nil, err
+	}
+	return assignments, nil
+}
+
+func (ag *assignmentGorm) ByHouseholdID(householdID uint) ([]Assignment, error) {
+	var assignments []Assignment
+	db := ag.preload().
+		Where("household_id = ?", householdID).
+		Order("week_start DESC").
+		Order("job_id")
+	if err := db.Find(&assignments).Error; err != nil {
+		return nil, err
+	}
+	return assignments, nil
+}
+
+func (ag *assignmentGorm) Unfinished(after, until time.Time) ([]Assignment, error) {
+	var assignments []Assignment
+	db := ag.preload().
+		Where("status IN (?)", []string{StatusPending, StatusInProgress}).
+		Where("week_start > ? AND week_start <= ?", after, until).
+		Order("id")
+	if err := db.Find(&assignments).Error; err != nil {
 		return nil, err
 	}
 	return assignments, nil
@@ -123,12 +358,31 @@ func (ag *assignmentGorm) Delete(id uint) error {
 	return ag.db.Delete(&assignment).Error
 }
 
+// preload makes sure the job and the assignee come back
+// along with every assignment we query.
+func (ag *assignmentGorm) preload() *gorm.DB {
+	return ag.db.Preload("Job").
+		Preload("Job.Items", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position").Order("id")
+		}).
+		Preload("User").
+		Preload("FinishedBy").
+		Preload("Checks")
+}
+
 // func (ag *assignmentGorm) Job(assignment *Assignment) (*Job, error) {
 // 	var job Job
 // 	ag.db.Model(&assignment).Association("Jobs").Find(&job)
 // 	return &job, nil
 // }
 
+func (av *assignmentValidator) householdIDRequired(a *Assignment) error {
+	if a.HouseholdID <= 0 {
+		return ErrHouseholdIDRequired
+	}
+	return nil
+}
+
 func (av *assignmentValidator) userIDRequired(a *Assignment) error {
 	if a.UserID <= 0 {
 		return ErrUserIDRequired
@@ -137,12 +391,26 @@ func (av *assignmentValidator) userIDRequired(a *Assignment) error {
 }
 
 func (av *assignmentValidator) jobRequired(a *Assignment) error {
-	if a.Job == (Job{}) {
+	if a.JobID <= 0 {
 		return ErrJobIDRequired
 	}
 	return nil
 }
 
+func (av *assignmentValidator) setStatusIfUnset(a *Assignment) error {
+	if a.Status == "" {
+		a.Status = StatusPending
+	}
+	return nil
+}
+
+func (av *assignmentValidator) statusValid(a *Assignment) error {
+	if _, ok := statusChanges[a.Status]; !ok {
+		return ErrStatusInvalid
+	}
+	return nil
+}
+
 func (av *assignmentValidator) nonZeroID(assignment *Assignment) error {
 	if assignment.ID <= 0 {
 		return ErrIDInvalid
@@ -0,0 +1,168 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/sirodoht/heartfort/hash"
+	"github.com/sirodoht/heartfort/rand"
+)
+
+// CalendarFeed represents the calendar_feeds table in our DB
+// and is the secret link a user subscribes to in their
+// calendar app to see their assignments. Only the hash of the
+// token is kept, so the link can only be shown when it is made.
+type CalendarFeed struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"not null;unique_index"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not null;unique_index"`
+	CreatedAt time.Time
+}
+
+func NewCalendarService(db *gorm.DB, hmacKey string) CalendarService {
+	return &calendarService{
+		CalendarDB: &calendarValidator{
+			CalendarDB: &calendarGorm{db},
+			hmac:       hash.NewHMAC(hmacKey),
+		},
+		db:      db,
+		hmacKey: hmacKey,
+	}
+}
+
+type CalendarService interface {
+	// Regenerate gives the user a new feed token, which stops
+	// the old one from working. The returned feed is the only
+	// place the token can be read from.
+	Regenerate(userID uint) (*CalendarFeed, error)
+	CalendarDB
+}
+
+var _ CalendarService = &calendarService{}
+
+type calendarService struct {
+	CalendarDB
+	db      *gorm.DB
+	hmacKey string
+}
+
+func (cs *calendarService) Regenerate(userID uint) (*CalendarFeed, error) {
+	feed := CalendarFeed{UserID: userID}
+	err := cs.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ?", userID).Delete(&CalendarFeed{}).Error
+		if err != nil {
+			return err
+		}
+		return NewCalendarService(tx, cs.hmacKey).Create(&feed)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// CalendarDB is used to interact with the calendar_feeds
+// database.
+type CalendarDB interface {
+	// ByToken returns the feed matching the token, or
+	// ErrNotFound if it was regenerated since.
+	ByToken(token string) (*CalendarFeed, error)
+	ByUserID(userID uint) (*CalendarFeed, error)
+	Create(feed *CalendarFeed) error
+}
+
+type calendarValidator struct {
+	CalendarDB
+	hmac hash.HMAC
+}
+
+func (cv *calendarValidator) ByToken(token string) (*CalendarFeed, error) {
+	feed := CalendarFeed{Token: token}
+	if err := runCalendarValFns(&feed, cv.hmacToken); err != nil {
+		return nil, err
+	}
+	if feed.TokenHash == "" {
+		return nil, ErrNotFound
+	}
+	return cv.CalendarDB.ByToken(feed.TokenHash)
+}
+
+func (cv *calendarValidator) Create(feed *CalendarFeed) error {
+	err := runCalendarValFns(feed,
+		cv.requireUserID,
+		cv.setTokenIfUnset,
+		cv.hmacToken,
+	)
+	if err != nil {
+		return err
+	}
+	return cv.CalendarDB.Create(feed)
+}
+
+func (cv *calendarValidator) requireUserID(feed *CalendarFeed) error {
+	if feed.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (cv *calendarValidator) setTokenIfUnset(feed *CalendarFeed) error {
+	if feed.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	feed.Token = token
+	return nil
+}
+
+func (cv *calendarValidator) hmacToken(feed *CalendarFeed) error {
+	if feed.Token == "" {
+		return nil
+	}
+	feed.TokenHash = cv.hmac.Hash(feed.Token)
+	return nil
+}
+
+type calendarValFn func(*CalendarFeed) error
+
+func runCalendarValFns(feed *CalendarFeed, fns ...calendarValFn) error {
+	for _, fn := range fns {
+		if err := fn(feed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ CalendarDB = &calendarGorm{}
+
+type calendarGorm struct {
+	db *gorm.DB
+}
+
+func (cg *calendarGorm) ByToken(tokenHash string) (*CalendarFeed, error) {
+	var feed CalendarFeed
+	err := first(cg.db.Where("token_hash = ?", tokenHash), &feed)
+	if err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+func (cg *calendarGorm) ByUserID(userID uint) (*CalendarFeed, error) {
+	var feed CalendarFeed
+	err := first(cg.db.Where("user_id = ?", userID), &feed)
+	if err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+func (cg *calendarGorm) Create(feed *CalendarFeed) error {
+	return cg.db.Create(feed).Error
+}
@@ -0,0 +1,204 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/sirodoht/heartfort/hash"
+	"github.com/sirodoht/heartfort/rand"
+)
+
+const (
+	ErrChatIDRequired modelError = "models: chat is required"
+
+	ErrSenderIDRequired modelError = "models: chat sender is required"
+)
+
+// chatLinkCodeLifetime is how long a user has to send their
+// link code to the bot.
+const chatLinkCodeLifetime = 15 * time.Minute
+
+// ChatLink represents the chat_links table in our DB and ties a
+// user to the chat the bot talks to them in. A link starts out
+// with a code the user sends the bot from the chat. Once the bot
+// gets it the chat is linked and the code is gone. Only the hash
+// of the code is kept, so it can only be shown when it is made.
+// SenderID is who sent the code on the chat service, and the
+// only one the bot takes commands from for the user. Several
+// users can share a chat, like a group for the household.
+type ChatLink struct {
+	ID            uint    `gorm:"primary_key"`
+	UserID        uint    `gorm:"not null;unique_index"`
+	ChatID        *string `gorm:"index"`
+	SenderID      *string `gorm:"unique_index"`
+	Code          string  `gorm:"-"`
+	CodeHash      *string `gorm:"unique_index"`
+	CodeExpiresAt *time.Time
+	LinkedAt      *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Linked reports whether the bot knows which chat is the user's.
+func (cl *ChatLink) Linked() bool {
+	return cl.ChatID != nil
+}
+
+func NewChatLinkService(db *gorm.DB, hmacKey string) ChatLinkService {
+	return &chatLinkService{
+		ChatLinkDB: &chatLinkValidator{
+			ChatLinkDB: &chatLinkGorm{db},
+		},
+		db:   db,
+		hmac: hash.NewHMAC(hmacKey),
+	}
+}
+
+type ChatLinkService interface {
+	// NewCode gives the user a new link code, keeping any chat
+	// they already linked until the code is used. The returned
+	// link is the only place the code can be read from.
+	NewCode(userID uint) (*ChatLink, error)
+	// Link links the chat and its sender to whoever the code was
+	// made for, taking the sender away from anyone they were
+	// linked to before. If the code is not valid or has expired
+	// ErrTokenInvalid is returned.
+	Link(code, chatID, senderID string) (*ChatLink, error)
+	ChatLinkDB
+}
+
+var _ ChatLinkService = &chatLinkService{}
+
+type chatLinkService struct {
+	ChatLinkDB
+	db   *gorm.DB
+	hmac hash.HMAC
+}
+
+func (cls *chatLinkService) NewCode(userID uint) (*ChatLink, error) {
+	if userID <= 0 {
+		return nil, ErrUserIDRequired
+	}
+	code, err := rand.String(6)
+	if err != nil {
+		return nil, err
+	}
+	link, err := cls.ByUserID(userID)
+	switch err {
+	case nil:
+	case ErrNotFound:
+		link = &ChatLink{UserID: userID}
+	default:
+		return nil, err
+	}
+	codeHash := cls.hashCode(code)
+	expiresAt := time.Now().Add(chatLinkCodeLifetime)
+	link.Code = code
+	link.CodeHash = &codeHash
+	link.CodeExpiresAt = &expiresAt
+	if err := cls.db.Save(link).Error; err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func (cls *chatLinkService) Link(code, chatID, senderID string) (*ChatLink, error) {
+	if chatID == "" {
+		return nil, ErrChatIDRequired
+	}
+	if senderID == "" {
+		return nil, ErrSenderIDRequired
+	}
+	if code == "" {
+		return nil, ErrTokenInvalid
+	}
+	var link ChatLink
+	err := cls.db.Transaction(func(tx *gorm.DB) error {
+		db := tx.Set("gorm:query_option", "FOR UPDATE").
+			Where("code_hash = ? AND code_expires_at > ?", cls.hashCode(code), time.Now())
+		switch err := first(db, &link); err {
+		case nil:
+		case ErrNotFound:
+			return ErrTokenInvalid
+		default:
+			return err
+		}
+		err := tx.Where("sender_id = ? AND id <> ?", senderID, link.ID).
+			Delete(&ChatLink{}).Error
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		link.ChatID = &chatID
+		link.SenderID = &senderID
+		link.LinkedAt = &now
+		link.CodeHash = nil
+		link.CodeExpiresAt = nil
+		return tx.Save(&link).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (cls *chatLinkService) hashCode(code string) string {
+	return cls.hmac.Hash(code)
+}
+
+// ChatLinkDB is used to interact with the chat_links database.
+type ChatLinkDB interface {
+	ByUserID(userID uint) (*ChatLink, error)
+	// BySenderID returns the link the sender made, or
+	// ErrNotFound if they haven't linked themselves to anyone.
+	BySenderID(senderID string) (*ChatLink, error)
+	// Unlink forgets the user's chat and any code they have.
+	Unlink(userID uint) error
+}
+
+type chatLinkValidator struct {
+	ChatLinkDB
+}
+
+func (clv *chatLinkValidator) BySenderID(senderID string) (*ChatLink, error) {
+	if senderID == "" {
+		return nil, ErrNotFound
+	}
+	return clv.ChatLinkDB.BySenderID(senderID)
+}
+
+func (clv *chatLinkValidator) Unlink(userID uint) error {
+	if userID <= 0 {
+		return ErrUserIDRequired
+	}
+	return clv.ChatLinkDB.Unlink(userID)
+}
+
+var _ ChatLinkDB = &chatLinkGorm{}
+
+type chatLinkGorm struct {
+	db *gorm.DB
+}
+
+func (clg *chatLinkGorm) ByUserID(userID uint) (*ChatLink, error) {
+	var link ChatLink
+	err := first(clg.db.Where("user_id = ?", userID), &link)
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (clg *chatLinkGorm) BySenderID(senderID string) (*ChatLink, error) {
+	var link ChatLink
+	err := first(clg.db.Where("sender_id = ?", senderID), &link)
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (clg *chatLinkGorm) Unlink(userID uint) error {
+	return clg.db.Where("user_id = ?", userID).Delete(&ChatLink{}).Error
+}
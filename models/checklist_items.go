@@ -0,0 +1,187 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	ErrTextRequired modelError = "models: text is required"
+)
+
+// ChecklistItem represents the checklist_items table in our DB
+// and is a single step of a job, such as "Replace and wash the
+// towel". Items of a job are shown in order of Position. Note
+// holds anything extra about the item, like who is exempt.
+type ChecklistItem struct {
+	gorm.Model
+	JobID    uint   `gorm:"not null;index"`
+	Text     string `gorm:"not null"`
+	Note     string
+	Position int `gorm:"not null;default:0"`
+}
+
+func NewChecklistItemService(db *gorm.DB) ChecklistItemService {
+	return &checklistItemService{
+		ChecklistItemDB: &checklistItemValidator{
+			ChecklistItemDB: &checklistItemGorm{
+				db: db,
+			},
+		},
+	}
+}
+
+type ChecklistItemService interface {
+	ChecklistItemDB
+}
+
+type checklistItemService struct {
+	ChecklistItemDB
+}
+
+// ChecklistItemDB is used to interact with the checklist_items
+// database.
+type ChecklistItemDB interface {
+	ByID(id uint) (*ChecklistItem, error)
+	// ByJobID returns the items of a job in the order they
+	// should be carried out.
+	ByJobID(jobID uint) ([]ChecklistItem, error)
+	Create(item *ChecklistItem) error
+	Update(item *ChecklistItem) error
+	Delete(id uint) error
+}
+
+type checklistItemValidator struct {
+	ChecklistItemDB
+}
+
+func (cv *checklistItemValidator) Create(item *ChecklistItem) error {
+	err := runChecklistItemValFns(item,
+		cv.jobIDRequired,
+		cv.normalizeText,
+		cv.textRequired,
+		cv.setPositionIfUnset,
+	)
+	if err != nil {
+		return err
+	}
+	return cv.ChecklistItemDB.Create(item)
+}
+
+func (cv *checklistItemValidator) Update(item *ChecklistItem) error {
+	err := runChecklistItemValFns(item,
+		cv.jobIDRequired,
+		cv.normalizeText,
+		cv.textRequired,
+		cv.setPositionIfUnset,
+	)
+	if err != nil {
+		return err
+	}
+	return cv.ChecklistItemDB.Update(item)
+}
+
+func (cv *checklistItemValidator) Delete(id uint) error {
+	var item ChecklistItem
+	item.ID = id
+	if err := runChecklistItemValFns(&item, cv.nonZeroID); err != nil {
+		return err
+	}
+	return cv.ChecklistItemDB.Delete(item.ID)
+}
+
+var _ ChecklistItemDB = &checklistItemGorm{}
+
+type checklistItemGorm struct {
+	db *gorm.DB
+}
+
+func (cg *checklistItemGorm) ByID(id uint) (*ChecklistItem, error) {
+	var item ChecklistItem
+	db := cg.db.Where("id = ?", id)
+	err := first(db, &item)
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (cg *checklistItemGorm) ByJobID(jobID uint) ([]ChecklistItem, error) {
+	var items []ChecklistItem
+	db := cg.db.Where("job_id = ?", jobID).Order("position").Order("id")
+	if err := db.Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (cg *checklistItemGorm) Create(item *ChecklistItem) error {
+	return cg.db.Create(item).Error
+}
+
+func (cg *checklistItemGorm) Update(item *ChecklistItem) error {
+	return cg.db.Save(item).Error
+}
+
+func (cg *checklistItemGorm) Delete(id uint) error {
+	item := ChecklistItem{Model: gorm.Model{ID: id}}
+	return cg.db.Delete(&item).Error
+}
+
+func (cv *checklistItemValidator) jobIDRequired(item *ChecklistItem) error {
+	if item.JobID <= 0 {
+		return ErrJobIDRequired
+	}
+	return nil
+}
+
+func (cv *checklistItemValidator) normalizeText(item *ChecklistItem) error {
+	item.Text = strings.TrimSpace(item.Text)
+	item.Note = strings.TrimSpace(item.Note)
+	return nil
+}
+
+func (cv *checklistItemValidator) textRequired(item *ChecklistItem) error {
+	if item.Text == "" {
+		return ErrTextRequired
+	}
+	return nil
+}
+
+// setPositionIfUnset puts items without a position at the end
+// of their job's checklist.
+func (cv *checklistItemValidator) setPositionIfUnset(item *ChecklistItem) error {
+	if item.Position > 0 {
+		return nil
+	}
+	items, err := cv.ByJobID(item.JobID)
+	if err != nil {
+		return err
+	}
+	item.Position = 1
+	for _, other := range items {
+		if other.ID != item.ID && other.Position >= item.Position {
+			item.Position = other.Position + 1
+		}
+	}
+	return nil
+}
+
+func (cv *checklistItemValidator) nonZeroID(item *ChecklistItem) error {
+	if item.ID <= 0 {
+		return ErrIDInvalid
+	}
+	return nil
+}
+
+type checklistItemValFn func(*ChecklistItem) error
+
+func runChecklistItemValFns(item *ChecklistItem, fns ...checklistItemValFn) error {
+	for _, fn := range fns {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
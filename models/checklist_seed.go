@@ -0,0 +1,76 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// specChecklists are the checklists that used to be written out
+// on the specs page, by the name of the job they belong to.
+var specChecklists = []struct {
+	job   string
+	items []ChecklistItem
+}{
+	{"Kitchen", []ChecklistItem{
+		{Text: "Table cleaning"},
+		{Text: "Oven"},
+		{Text: "Microwave"},
+		{Text: "Stove"},
+		{Text: "Surfaces"},
+		{Text: "Underneath the dish plate rack"},
+		{Text: "Replace and wash the towel"},
+	}},
+	{"Living room", []ChecklistItem{
+		{Text: "Vacuum"},
+		{Text: "Mopping", Note: "Floor includes the whole kitchen floor too"},
+		{Text: "Vacuum the sofas"},
+		{Text: "Fluff the pillows"},
+		{Text: "Also clean the entrance corridor"},
+		{Text: "Special exemption", Note: "Zuzana does not clean the working table"},
+	}},
+	{"Stairs", []ChecklistItem{
+		{Text: "Vacuum"},
+	}},
+	{"Toilet", []ChecklistItem{
+		{Text: "Wash all toilet furniture"},
+		{Text: "Add extra rolls of toilet paper"},
+		{Text: "Replace and wash towel"},
+		{Text: "Soap"},
+		{Text: "Clean and mop floor"},
+	}},
+	{"Bins weekly", []ChecklistItem{
+		{Text: "Make sure bins are not"},
+		{Text: "Put bins out for collection at the appropriate day"},
+	}},
+	{"Bins monthly", []ChecklistItem{
+		{Text: "Wash bins"},
+	}},
+	{"Windows monthly", []ChecklistItem{
+		{Text: "Clean the living room two windows"},
+	}},
+}
+
+// seedChecklistItems gives the jobs that were on the specs page
+// their checklists, so the page shows the same thing it did
+// before checklists were kept in the database. Jobs are matched
+// by name, and ones that don't exist are left out.
+func seedChecklistItems(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, spec := range specChecklists {
+			var jobs []Job
+			err := tx.Where("LOWER(name) = LOWER(?)", spec.job).Find(&jobs).Error
+			if err != nil {
+				return err
+			}
+			for _, job := range jobs {
+				for i, item := range spec.items {
+					item.JobID = job.ID
+					item.Position = i + 1
+					if err := tx.Create(&item).Error; err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+}
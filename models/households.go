@@ -0,0 +1,419 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/sirodoht/heartfort/rand"
+)
+
+const (
+	ErrHouseholdIDRequired modelError = "models: household ID is required"
+
+	ErrAlreadyMember modelError = "models: user is already a member of this household"
+
+	ErrNotMember modelError = "models: user is not a member of this household"
+)
+
+// Household represents the households table in our DB and is a
+// single flat sharing jobs, assignments and mates. Users belong
+// to households through memberships. When RequireTwoFactor is
+// set, members have to turn on two-factor authentication before
+// they can use the household. SignupToken goes in the link that
+// mates sign up for the household's notifications with, so that
+// only people it was shared with can.
+type Household struct {
+	gorm.Model
+	Name             string `gorm:"not null"`
+	RequireTwoFactor bool   `gorm:"not null;default:false"`
+	SignupToken      string `gorm:"unique_index"`
+}
+
+// Membership represents the memberships table in our DB and is
+// a user belonging to a household with one of the roles in
+// policy.go. Leaving a household deletes the membership
+// outright so the user can be added again later.
+type Membership struct {
+	ID          uint      `gorm:"primary_key"`
+	HouseholdID uint      `gorm:"not null;unique_index:idx_memberships_household_user"`
+	Household   Household `gorm:"save_associations:false"`
+	UserID      uint      `gorm:"not null;unique_index:idx_memberships_household_user"`
+	User        User      `gorm:"save_associations:false"`
+	Role        string    `gorm:"not null;default:'member'"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func NewHouseholdService(db *gorm.DB) HouseholdService {
+	return &householdService{
+		HouseholdDB: &householdValidator{
+			HouseholdDB: &householdGorm{
+				db: db,
+			},
+		},
+		db: db,
+	}
+}
+
+type HouseholdService interface {
+	// CreateFor creates the household with the user as its
+	// first member and owner.
+	CreateFor(household *Household, userID uint) error
+	HouseholdDB
+}
+
+var _ HouseholdService = &householdService{}
+
+type householdService struct {
+	HouseholdDB
+	db *gorm.DB
+}
+
+func (hs *householdService) CreateFor(household *Household, userID uint) error {
+	return hs.db.Transaction(func(tx *gorm.DB) error {
+		hdb := NewHouseholdService(tx)
+		if err := hdb.Create(household); err != nil {
+			return err
+		}
+		return hdb.AddMember(&Membership{
+			HouseholdID: household.ID,
+			UserID:      userID,
+			Role:        RoleOwner,
+		})
+	})
+}
+
+// defaultHousehold is the name of the household that everything
+// from before there were households is moved into.
+const defaultHousehold = "Our household"
+
+// migrateToHouseholds moves a site from before there were
+// households into one, so its users keep seeing their jobs,
+// assignments and mates. Every user becomes an owner of it,
+// since they could all change everything before.
+func migrateToHouseholds(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var userIDs []uint
+		if err := tx.Model(&User{}).Order("id").Pluck("id", &userIDs).Error; err != nil {
+			return err
+		}
+		if len(userIDs) == 0 {
+			return nil
+		}
+		household := Household{Name: defaultHousehold}
+		if err := tx.Create(&household).Error; err != nil {
+			return err
+		}
+		for _, userID := range userIDs {
+			err := tx.Create(&Membership{
+				HouseholdID: household.ID,
+				UserID:      userID,
+				Role:        RoleOwner,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, model := range []interface{}{&Job{}, &Assignment{}, &Mate{}} {
+			err := tx.Model(model).Unscoped().
+				Where("household_id IS NULL OR household_id = 0").
+				UpdateColumn("household_id", household.ID).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// signupTokenBytes is how random a household's signup token is.
+const signupTokenBytes = 16
+
+func newSignupToken() (string, error) {
+	return rand.String(signupTokenBytes)
+}
+
+// fillSignupTokens gives the households from before they had
+// signup tokens one each, since the links used to have their
+// ID in instead.
+func fillSignupTokens(db *gorm.DB) error {
+	var ids []uint
+	err := db.Model(&Household{}).Unscoped().
+		Where("signup_token IS NULL OR signup_token = ''").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		token, err := newSignupToken()
+		if err != nil {
+			return err
+		}
+		err = db.Model(&Household{}).Unscoped().Where("id = ?", id).
+			UpdateColumn("signup_token", token).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HouseholdDB is used to interact with the households and
+// memberships database.
+type HouseholdDB interface {
+	ByID(id uint) (*Household, error)
+	// BySignupToken returns the household whose notifications
+	// sign up link has the token, or ErrNotFound.
+	BySignupToken(token string) (*Household, error)
+	// ByUserID returns the households the user is a member of.
+	ByUserID(userID uint) ([]Household, error)
+	// All returns every household ordered by ID.
+	All() ([]Household, error)
+	Create(household *Household) error
+	Update(household *Household) error
+	Delete(id uint) error
+
+	// Members returns the users of a household ordered by ID.
+	Members(householdID uint) ([]User, error)
+	// Workers returns the users of a household who can be given
+	// jobs, which is everyone but guests, ordered by ID.
+	Workers(householdID uint) ([]User, error)
+	// Memberships returns the memberships of a household along
+	// with their users.
+	Memberships(householdID uint) ([]Membership, error)
+	// Membership looks up the membership of a user in a
+	// household, returning ErrNotFound if they aren't a member.
+	Membership(householdID, userID uint) (*Membership, error)
+	AddMember(membership *Membership) error
+	UpdateMember(membership *Membership) error
+	RemoveMember(householdID, userID uint) error
+}
+
+type householdValidator struct {
+	HouseholdDB
+}
+
+func (hv *householdValidator) BySignupToken(token string) (*Household, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	return hv.HouseholdDB.BySignupToken(token)
+}
+
+func (hv *householdValidator) Create(household *Household) error {
+	err := runHouseholdValFns(household, hv.normalizeName, hv.nameRequired, hv.setSignupToken)
+	if err != nil {
+		return err
+	}
+	return hv.HouseholdDB.Create(household)
+}
+
+func (hv *householdValidator) Update(household *Household) error {
+	err := runHouseholdValFns(household, hv.normalizeName, hv.nameRequired)
+	if err != nil {
+		return err
+	}
+	return hv.HouseholdDB.Update(household)
+}
+
+func (hv *householdValidator) Delete(id uint) error {
+	var household Household
+	household.ID = id
+	if err := runHouseholdValFns(&household, hv.nonZeroID); err != nil {
+		return err
+	}
+	return hv.HouseholdDB.Delete(household.ID)
+}
+
+func (hv *householdValidator) AddMember(membership *Membership) error {
+	if membership.HouseholdID <= 0 {
+		return ErrHouseholdIDRequired
+	}
+	if membership.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	if membership.Role == "" {
+		membership.Role = RoleMember
+	}
+	if _, ok := roleRanks[membership.Role]; !ok {
+		return ErrRoleInvalid
+	}
+	_, err := hv.Membership(membership.HouseholdID, membership.UserID)
+	switch err {
+	case nil:
+		return ErrAlreadyMember
+	case ErrNotFound:
+	default:
+		return err
+	}
+	return hv.HouseholdDB.AddMember(membership)
+}
+
+func (hv *householdValidator) UpdateMember(membership *Membership) error {
+	if _, ok := roleRanks[membership.Role]; !ok {
+		return ErrRoleInvalid
+	}
+	return hv.HouseholdDB.UpdateMember(membership)
+}
+
+var _ HouseholdDB = &householdGorm{}
+
+type householdGorm struct {
+	db *gorm.DB
+}
+
+func (hg *householdGorm) ByID(id uint) (*Household, error) {
+	var household Household
+	db := hg.db.Where("id = ?", id)
+	err := first(db, &household)
+	if err != nil {
+		return nil, err
+	}
+	return &household, nil
+}
+
+func (hg *householdGorm) BySignupToken(token string) (*Household, error) {
+	var household Household
+	db := hg.db.Where("signup_token = ?", token)
+	err := first(db, &household)
+	if err != nil {
+		return nil, err
+	}
+	return &household, nil
+}
+
+func (hg *householdGorm) ByUserID(userID uint) ([]Household, error) {
+	var households []Household
+	db := hg.db.
+		Joins("JOIN memberships ON memberships.household_id = households.id").
+		Where("memberships.user_id = ?", userID).
+		Order("households.id")
+	if err := db.Find(&households).Error; err != nil {
+		return nil, err
+	}
+	return households, nil
+}
+
+func (hg *householdGorm) All() ([]Household, error) {
+	var households []Household
+	if err := hg.db.Order("id").Find(&households).Error; err != nil {
+		return nil, err
+	}
+	return households, nil
+}
+
+func (hg *householdGorm) Create(household *Household) error {
+	return hg.db.Create(household).Error
+}
+
+func (hg *householdGorm) Update(household *Household) error {
+	return hg.db.Save(household).Error
+}
+
+func (hg *householdGorm) Delete(id uint) error {
+	household := Household{Model: gorm.Model{ID: id}}
+	return hg.db.Delete(&household).Error
+}
+
+func (hg *householdGorm) Members(householdID uint) ([]User, error) {
+	var users []User
+	db := hg.db.
+		Joins("JOIN memberships ON memberships.user_id = users.id").
+		Where("memberships.household_id = ?", householdID).
+		Order("users.id")
+	if err := db.Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (hg *householdGorm) Workers(householdID uint) ([]User, error) {
+	var users []User
+	db := hg.db.
+		Joins("JOIN memberships ON memberships.user_id = users.id").
+		Where("memberships.household_id = ? AND memberships.role IN (?)", householdID, rolesAtLeast(RoleMember)).
+		Order("users.id")
+	if err := db.Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (hg *householdGorm) Memberships(householdID uint) ([]Membership, error) {
+	var memberships []Membership
+	db := hg.db.Preload("User").
+		Where("household_id = ?", householdID).
+		Order("user_id")
+	if err := db.Find(&memberships).Error; err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+func (hg *householdGorm) Membership(householdID, userID uint) (*Membership, error) {
+	var membership Membership
+	db := hg.db.Where("household_id = ? AND user_id = ?", householdID, userID)
+	err := first(db, &membership)
+	if err != nil {
+		return nil, err
+	}
+	return &membership, nil
+}
+
+func (hg *householdGorm) AddMember(membership *Membership) error {
+	return hg.db.Create(membership).Error
+}
+
+func (hg *householdGorm) UpdateMember(membership *Membership) error {
+	return hg.db.Save(membership).Error
+}
+
+func (hg *householdGorm) RemoveMember(householdID, userID uint) error {
+	return hg.db.
+		Where("household_id = ? AND user_id = ?", householdID, userID).
+		Delete(&Membership{}).Error
+}
+
+func (hv *householdValidator) normalizeName(household *Household) error {
+	household.Name = strings.TrimSpace(household.Name)
+	return nil
+}
+
+func (hv *householdValidator) nameRequired(household *Household) error {
+	if household.Name == "" {
+		return ErrNameRequired
+	}
+	return nil
+}
+
+func (hv *householdValidator) setSignupToken(household *Household) error {
+	if household.SignupToken != "" {
+		return nil
+	}
+	token, err := newSignupToken()
+	if err != nil {
+		return err
+	}
+	household.SignupToken = token
+	return nil
+}
+
+func (hv *householdValidator) nonZeroID(household *Household) error {
+	if household.ID <= 0 {
+		return ErrIDInvalid
+	}
+	return nil
+}
+
+type householdValFn func(*Household) error
+
+func runHouseholdValFns(household *Household, fns ...householdValFn) error {
+	for _, fn := range fns {
+		if err := fn(household); err != nil {
+			return err
+		}
+	}
+	return nil
+}
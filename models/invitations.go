@@ -0,0 +1,287 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirodoht/heartfort/hash"
+	"github.com/sirodoht/heartfort/rand"
+)
+
+// InvitationLifetime is how long an invitation can be accepted
+// for after it was sent.
+const InvitationLifetime = 7 * 24 * time.Hour
+
+// Invitation represents the invitations table in our DB and is
+// an invite for someone to join a household. Like password
+// resets only the HMAC of the token is stored.
+type Invitation struct {
+	gorm.Model
+	HouseholdID  uint      `gorm:"not null;index"`
+	Household    Household `gorm:"save_associations:false"`
+	Email        string    `gorm:"not null"`
+	InvitedByID  uint      `gorm:"not null"`
+	InvitedBy    User      `gorm:"save_associations:false"`
+	Token        string    `gorm:"-"`
+	TokenHash    string    `gorm:"not null;unique_index"`
+	ExpiresAt    time.Time `gorm:"not null"`
+	AcceptedAt   *time.Time
+	AcceptedByID *uint
+	RevokedAt    *time.Time
+}
+
+// Status describes where the invitation is at: "Accepted",
+// "Revoked", "Expired" or "Pending".
+func (inv *Invitation) Status() string {
+	switch {
+	case inv.AcceptedAt != nil:
+		return "Accepted"
+	case inv.RevokedAt != nil:
+		return "Revoked"
+	case time.Now().After(inv.ExpiresAt):
+		return "Expired"
+	}
+	return "Pending"
+}
+
+// Pending reports whether the invitation can still be accepted.
+func (inv *Invitation) Pending() bool {
+	return inv.Status() == "Pending"
+}
+
+func NewInvitationService(db *gorm.DB, hmacKey string) InvitationService {
+	return &invitationService{
+		InvitationDB: newInvitationValidator(&invitationGorm{db}, hash.NewHMAC(hmacKey)),
+		db:           db,
+		hmacKey:      hmacKey,
+	}
+}
+
+type InvitationService interface {
+	// Accept adds the user to the household of the invitation
+	// matching the token and marks the invitation accepted. If
+	// the invitation has expired, was revoked or was already
+	// used ErrTokenInvalid is returned.
+	Accept(token string, userID uint) (*Invitation, error)
+	// Revoke stops a pending invitation from being accepted.
+	Revoke(invitation *Invitation) error
+	InvitationDB
+}
+
+var _ InvitationService = &invitationService{}
+
+type invitationService struct {
+	InvitationDB
+	db      *gorm.DB
+	hmacKey string
+}
+
+func (is *invitationService) Accept(token string, userID uint) (*Invitation, error) {
+	var invitation *Invitation
+	err := is.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		invitation, err = NewInvitationService(tx, is.hmacKey).ByToken(token)
+		if err == ErrNotFound || (err == nil && !invitation.Pending()) {
+			return ErrTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+		err = NewHouseholdService(tx).AddMember(&Membership{
+			HouseholdID: invitation.HouseholdID,
+			UserID:      userID,
+		})
+		if err != nil && err != ErrAlreadyMember {
+			return err
+		}
+		now := time.Now()
+		invitation.AcceptedAt = &now
+		invitation.AcceptedByID = &userID
+		return tx.Save(invitation).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+func (is *invitationService) Revoke(invitation *Invitation) error {
+	if !invitation.Pending() {
+		return ErrTokenInvalid
+	}
+	now := time.Now()
+	invitation.RevokedAt = &now
+	return is.Update(invitation)
+}
+
+// InvitationDB is used to interact with the invitations
+// database.
+type InvitationDB interface {
+	ByID(id uint) (*Invitation, error)
+	// ByToken looks up an invitation by the token that was
+	// emailed out, not its hash.
+	ByToken(token string) (*Invitation, error)
+	// ByHouseholdID returns the invitations of a household with
+	// the most recent first.
+	ByHouseholdID(householdID uint) ([]Invitation, error)
+	Create(invitation *Invitation) error
+	Update(invitation *Invitation) error
+}
+
+func newInvitationValidator(db InvitationDB, hmac hash.HMAC) *invitationValidator {
+	return &invitationValidator{
+		InvitationDB: db,
+		hmac:         hmac,
+		emailRegex:   regexp.MustCompile(emailPattern),
+	}
+}
+
+type invitationValidator struct {
+	InvitationDB
+	hmac       hash.HMAC
+	emailRegex *regexp.Regexp
+}
+
+func (iv *invitationValidator) ByToken(token string) (*Invitation, error) {
+	invitation := Invitation{Token: token}
+	if err := runInvitationValFns(&invitation, iv.hmacToken); err != nil {
+		return nil, err
+	}
+	return iv.InvitationDB.ByToken(invitation.TokenHash)
+}
+
+func (iv *invitationValidator) Create(invitation *Invitation) error {
+	err := runInvitationValFns(invitation,
+		iv.householdIDRequired,
+		iv.invitedByRequired,
+		iv.normalizeEmail,
+		iv.requireEmail,
+		iv.emailFormat,
+		iv.setTokenIfUnset,
+		iv.hmacToken,
+		iv.setExpiresAtIfUnset,
+	)
+	if err != nil {
+		return err
+	}
+	return iv.InvitationDB.Create(invitation)
+}
+
+func (iv *invitationValidator) householdIDRequired(invitation *Invitation) error {
+	if invitation.HouseholdID <= 0 {
+		return ErrHouseholdIDRequired
+	}
+	return nil
+}
+
+func (iv *invitationValidator) invitedByRequired(invitation *Invitation) error {
+	if invitation.InvitedByID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (iv *invitationValidator) normalizeEmail(invitation *Invitation) error {
+	invitation.Email = strings.TrimSpace(strings.ToLower(invitation.Email))
+	return nil
+}
+
+func (iv *invitationValidator) requireEmail(invitation *Invitation) error {
+	if invitation.Email == "" {
+		return ErrEmailRequired
+	}
+	return nil
+}
+
+func (iv *invitationValidator) emailFormat(invitation *Invitation) error {
+	if !iv.emailRegex.MatchString(invitation.Email) {
+		return ErrEmailInvalid
+	}
+	return nil
+}
+
+func (iv *invitationValidator) setTokenIfUnset(invitation *Invitation) error {
+	if invitation.Token != "" {
+		return nil
+	}
+	token, err := rand.String(rand.RememberTokenBytes)
+	if err != nil {
+		return err
+	}
+	invitation.Token = token
+	return nil
+}
+
+func (iv *invitationValidator) hmacToken(invitation *Invitation) error {
+	if invitation.Token == "" {
+		return nil
+	}
+	invitation.TokenHash = iv.hmac.Hash(invitation.Token)
+	return nil
+}
+
+func (iv *invitationValidator) setExpiresAtIfUnset(invitation *Invitation) error {
+	if !invitation.ExpiresAt.IsZero() {
+		return nil
+	}
+	invitation.ExpiresAt = time.Now().Add(InvitationLifetime)
+	return nil
+}
+
+type invitationValFn func(*Invitation) error
+
+func runInvitationValFns(invitation *Invitation, fns ...invitationValFn) error {
+	for _, fn := range fns {
+		if err := fn(invitation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ InvitationDB = &invitationGorm{}
+
+type invitationGorm struct {
+	db *gorm.DB
+}
+
+func (ig *invitationGorm) ByID(id uint) (*Invitation, error) {
+	var invitation Invitation
+	err := first(ig.preload().Where("id = ?", id), &invitation)
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func (ig *invitationGorm) ByToken(tokenHash string) (*Invitation, error) {
+	var invitation Invitation
+	err := first(ig.preload().Where("token_hash = ?", tokenHash), &invitation)
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func (ig *invitationGorm) ByHouseholdID(householdID uint) ([]Invitation, error) {
+	var invitations []Invitation
+	db := ig.preload().Where("household_id = ?", householdID).Order("id DESC")
+	if err := db.Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func (ig *invitationGorm) Create(invitation *Invitation) error {
+	return ig.db.Create(invitation).Error
+}
+
+func (ig *invitationGorm) Update(invitation *Invitation) error {
+	return ig.db.Save(invitation).Error
+}
+
+func (ig *invitationGorm) preload() *gorm.DB {
+	return ig.db.Preload("Household").Preload("InvitedBy")
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ItemCheck represents the item_checks table in our DB and is a
+// checklist item that has been ticked off for an assignment.
+// Unticking an item deletes its check outright.
+type ItemCheck struct {
+	ID              uint `gorm:"primary_key"`
+	AssignmentID    uint `gorm:"not null;unique_index:idx_item_checks_assignment_item"`
+	ChecklistItemID uint `gorm:"not null;unique_index:idx_item_checks_assignment_item"`
+	UserID          uint `gorm:"not null"`
+	CreatedAt       time.Time
+}
+
+type itemCheckDB interface {
+	Create(check *ItemCheck) error
+	Delete(assignmentID, itemID uint) error
+}
+
+type itemCheckGorm struct {
+	db *gorm.DB
+}
+
+func (icg *itemCheckGorm) Create(check *ItemCheck) error {
+	return icg.db.Create(check).Error
+}
+
+func (icg *itemCheckGorm) Delete(assignmentID, itemID uint) error {
+	return icg.db.
+		Where("assignment_id = ? AND checklist_item_id = ?", assignmentID, itemID).
+		Delete(&ItemCheck{}).Error
+}
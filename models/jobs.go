@@ -1,16 +1,50 @@
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
 
 const (
 	ErrNameRequired modelError = "models: name is required"
+
+	ErrEffortInvalid modelError = "models: effort must be between 1 and 10"
 )
 
+// MaxEffort is the most effort a job can take. Every job takes
+// at least 1.
+const MaxEffort = 10
+
 // Job represents the jobs table in our DB and is a single job
-// such as "Kitchen".
+// such as "Kitchen". It repeats every IntervalCount weeks or
+// months counting from AnchorDate, unless RRule is set, in
+// which case that takes precedence. Effort is how many points
+// finishing it earns, so heavier jobs count for more. Items is
+// its checklist and is only changed through the
+// ChecklistItemService.
 type Job struct {
 	gorm.Model
-	Name string `gorm:"not_null"`
+	HouseholdID   uint   `gorm:"index"`
+	Name          string `gorm:"not_null"`
+	IntervalUnit  string `gorm:"not null;default:'week'"`
+	IntervalCount int    `gorm:"not null;default:1"`
+	AnchorDate    *time.Time
+	RRule         string
+	Effort        int             `gorm:"not null;default:1"`
+	Items         []ChecklistItem `gorm:"save_associations:false"`
+}
+
+// hasItem reports whether the checklist item with the given ID
+// is part of the job.
+func (j *Job) hasItem(itemID uint) bool {
+	for _, item := range j.Items {
+		if item.ID == itemID {
+			return true
+		}
+	}
+	return false
 }
 
 func NewJobService(db *gorm.DB) JobService {
@@ -34,7 +68,7 @@ type jobService struct {
 // JobDB is used to interact with the jobs database.
 type JobDB interface {
 	ByID(id uint) (*Job, error)
-	List() ([]Job, error)
+	ByHouseholdID(householdID uint) ([]Job, error)
 	Create(job *Job) error
 	Update(job *Job) error
 	Delete(id uint) error
@@ -45,7 +79,14 @@ type jobValidator struct {
 }
 
 func (jv *jobValidator) Create(job *Job) error {
-	err := runJobValFns(job, jv.nameRequired)
+	err := runJobValFns(job,
+		jv.householdIDRequired,
+		jv.nameRequired,
+		jv.normalizeRecurrence,
+		jv.recurrenceValid,
+		jv.setEffortIfUnset,
+		jv.effortValid,
+	)
 	if err != nil {
 		return err
 	}
@@ -53,7 +94,14 @@ func (jv *jobValidator) Create(job *Job) error {
 }
 
 func (jv *jobValidator) Update(job *Job) error {
-	err := runJobValFns(job, jv.nameRequired)
+	err := runJobValFns(job,
+		jv.householdIDRequired,
+		jv.nameRequired,
+		jv.normalizeRecurrence,
+		jv.recurrenceValid,
+		jv.setEffortIfUnset,
+		jv.effortValid,
+	)
 	if err != nil {
 		return err
 	}
@@ -77,7 +125,7 @@ type jobGorm struct {
 
 func (jg *jobGorm) ByID(id uint) (*Job, error) {
 	var job Job
-	db := jg.db.Where("id = ?", id)
+	db := jg.preload().Where("id = ?", id)
 	err := first(db, &job)
 	if err != nil {
 		return nil, err
@@ -85,14 +133,23 @@ func (jg *jobGorm) ByID(id uint) (*Job, error) {
 	return &job, nil
 }
 
-func (jg *jobGorm) List() ([]Job, error) {
+func (jg *jobGorm) ByHouseholdID(householdID uint) ([]Job, error) {
 	var jobs []Job
-	if err := jg.db.Find(&jobs).Error; err != nil {
+	db := jg.preload().Where("household_id = ?", householdID).Order("id")
+	if err := db.Find(&jobs).Error; err != nil {
 		return nil, err
 	}
 	return jobs, nil
 }
 
+// preload makes sure every job comes back with its checklist
+// in order.
+func (jg *jobGorm) preload() *gorm.DB {
+	return jg.db.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position").Order("id")
+	})
+}
+
 func (jg *jobGorm) Create(job *Job) error {
 	return jg.db.Create(job).Error
 }
@@ -106,6 +163,13 @@ func (jg *jobGorm) Delete(id uint) error {
 	return jg.db.Delete(&job).Error
 }
 
+func (jv *jobValidator) householdIDRequired(job *Job) error {
+	if job.HouseholdID <= 0 {
+		return ErrHouseholdIDRequired
+	}
+	return nil
+}
+
 func (jv *jobValidator) nameRequired(g *Job) error {
 	if g.Name == "" {
 		return ErrNameRequired
@@ -113,6 +177,49 @@ func (jv *jobValidator) nameRequired(g *Job) error {
 	return nil
 }
 
+func (jv *jobValidator) normalizeRecurrence(job *Job) error {
+	job.IntervalUnit = strings.ToLower(strings.TrimSpace(job.IntervalUnit))
+	if job.IntervalUnit == "" {
+		job.IntervalUnit = IntervalWeek
+	}
+	if job.IntervalCount == 0 {
+		job.IntervalCount = 1
+	}
+	job.RRule = strings.TrimSpace(strings.ToUpper(job.RRule))
+	job.RRule = strings.TrimPrefix(job.RRule, "RRULE:")
+	return nil
+}
+
+func (jv *jobValidator) recurrenceValid(job *Job) error {
+	switch job.IntervalUnit {
+	case IntervalWeek, IntervalMonth:
+	default:
+		return ErrIntervalUnitInvalid
+	}
+	if job.IntervalCount < 1 {
+		return ErrIntervalCountInvalid
+	}
+	if job.RRule == "" {
+		return nil
+	}
+	_, err := parseRRule(job.RRule)
+	return err
+}
+
+func (jv *jobValidator) setEffortIfUnset(job *Job) error {
+	if job.Effort == 0 {
+		job.Effort = 1
+	}
+	return nil
+}
+
+func (jv *jobValidator) effortValid(job *Job) error {
+	if job.Effort < 1 || job.Effort > MaxEffort {
+		return ErrEffortInvalid
+	}
+	return nil
+}
+
 func (jv *jobValidator) nonZeroID(job *Job) error {
 	if job.ID <= 0 {
 		return ErrIDInvalid
@@ -0,0 +1,211 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// FairnessWeeks is how many weeks back the rota looks when
+// working out who is behind on points, and how many weeks the
+// fairness page shows unless asked for more.
+const FairnessWeeks = 8
+
+// LedgerEntry represents the ledger_entries table in our DB and
+// is the points a user earned for finishing an assignment,
+// which is the effort of its job. Reopening the assignment
+// takes the entry off the ledger again.
+type LedgerEntry struct {
+	ID           uint      `gorm:"primary_key"`
+	HouseholdID  uint      `gorm:"not null;index"`
+	UserID       uint      `gorm:"not null;index"`
+	AssignmentID uint      `gorm:"not null;unique_index"`
+	WeekStart    time.Time `gorm:"not null"`
+	Points       int       `gorm:"not null"`
+	CreatedAt    time.Time
+}
+
+// UserPoints is how many points a user has, in total or in a
+// given week.
+type UserPoints struct {
+	UserID    uint
+	WeekStart time.Time
+	Points    int
+}
+
+// Fairness is how the points of a household are spread out
+// among its members. Weeks are the week starts of the history,
+// oldest first.
+type Fairness struct {
+	Weeks []time.Time
+	Rows  []FairnessRow
+	// Spread is the difference between whoever has the most
+	// points over the weeks shown and whoever has the fewest.
+	Spread int
+}
+
+// FairnessRow is one member's points. History lines up with
+// the weeks of the Fairness it belongs to, Recent is the sum of
+// it and Balance is how far Recent is above, or below when
+// negative, the household's average.
+type FairnessRow struct {
+	User    User
+	Total   int
+	Recent  int
+	History []int
+	Balance float64
+}
+
+func NewLedgerService(db *gorm.DB) LedgerService {
+	return &ledgerService{
+		LedgerDB: &ledgerGorm{db},
+		db:       db,
+	}
+}
+
+type LedgerService interface {
+	// Fairness works out the points of every member of the
+	// household but its guests, who get no jobs, over the given number of weeks up to and
+	// including the current one.
+	Fairness(householdID uint, weeks int) (*Fairness, error)
+	LedgerDB
+}
+
+var _ LedgerService = &ledgerService{}
+
+type ledgerService struct {
+	LedgerDB
+	db *gorm.DB
+}
+
+func (ls *ledgerService) Fairness(householdID uint, weeks int) (*Fairness, error) {
+	if weeks < 1 {
+		weeks = FairnessWeeks
+	}
+	members, err := NewHouseholdService(ls.db).Workers(householdID)
+	if err != nil {
+		return nil, err
+	}
+	totals, err := ls.Totals(householdID, nil)
+	if err != nil {
+		return nil, err
+	}
+	since := WeekOf(time.Now()).AddDate(0, 0, -7*(weeks-1))
+	weekly, err := ls.Weekly(householdID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	var fairness Fairness
+	for i := 0; i < weeks; i++ {
+		fairness.Weeks = append(fairness.Weeks, since.AddDate(0, 0, 7*i))
+	}
+	history := make(map[uint][]int, len(members))
+	for _, m := range members {
+		history[m.ID] = make([]int, weeks)
+	}
+	for _, p := range weekly {
+		i := int(p.WeekStart.Sub(since) / (7 * 24 * time.Hour))
+		if h, ok := history[p.UserID]; ok && i >= 0 && i < weeks {
+			h[i] += p.Points
+		}
+	}
+
+	sum := 0
+	for _, m := range members {
+		row := FairnessRow{
+			User:    m,
+			Total:   totals[m.ID],
+			History: history[m.ID],
+		}
+		for _, points := range row.History {
+			row.Recent += points
+		}
+		sum += row.Recent
+		fairness.Rows = append(fairness.Rows, row)
+	}
+	if len(fairness.Rows) == 0 {
+		return &fairness, nil
+	}
+	average := float64(sum) / float64(len(fairness.Rows))
+	most, fewest := fairness.Rows[0].Recent, fairness.Rows[0].Recent
+	for i := range fairness.Rows {
+		row := &fairness.Rows[i]
+		row.Balance = float64(row.Recent) - average
+		if row.Recent > most {
+			most = row.Recent
+		}
+		if row.Recent < fewest {
+			fewest = row.Recent
+		}
+	}
+	fairness.Spread = most - fewest
+	return &fairness, nil
+}
+
+// LedgerDB is used to interact with the ledger_entries
+// database.
+type LedgerDB interface {
+	// Totals returns the points of each user in the household,
+	// keyed by user ID. With since set only the weeks from then
+	// on are counted.
+	Totals(householdID uint, since *time.Time) (map[uint]int, error)
+	// Weekly returns the points of each user in the household
+	// for every week since the given week start.
+	Weekly(householdID uint, since time.Time) ([]UserPoints, error)
+	// Credit puts the entry on the ledger, replacing any entry
+	// for the same assignment.
+	Credit(entry *LedgerEntry) error
+	// Debit takes the entry for the assignment off the ledger.
+	Debit(assignmentID uint) error
+}
+
+var _ LedgerDB = &ledgerGorm{}
+
+type ledgerGorm struct {
+	db *gorm.DB
+}
+
+func (lg *ledgerGorm) Totals(householdID uint, since *time.Time) (map[uint]int, error) {
+	var rows []UserPoints
+	db := lg.db.Table("ledger_entries").
+		Select("user_id, SUM(points) AS points").
+		Where("household_id = ?", householdID)
+	if since != nil {
+		db = db.Where("week_start >= ?", *since)
+	}
+	if err := db.Group("user_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	totals := make(map[uint]int, len(rows))
+	for _, row := range rows {
+		totals[row.UserID] = row.Points
+	}
+	return totals, nil
+}
+
+func (lg *ledgerGorm) Weekly(householdID uint, since time.Time) ([]UserPoints, error) {
+	var rows []UserPoints
+	err := lg.db.Table("ledger_entries").
+		Select("user_id, week_start, SUM(points) AS points").
+		Where("household_id = ? AND week_start >= ?", householdID, since).
+		Group("user_id, week_start").
+		Order("week_start, user_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (lg *ledgerGorm) Credit(entry *LedgerEntry) error {
+	if err := lg.Debit(entry.AssignmentID); err != nil {
+		return err
+	}
+	return lg.db.Create(entry).Error
+}
+
+func (lg *ledgerGorm) Debit(assignmentID uint) error {
+	return lg.db.Where("assignment_id = ?", assignmentID).
+		Delete(&LedgerEntry{}).Error
+}
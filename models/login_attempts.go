@@ -0,0 +1,197 @@
+package models
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	ErrReasonInvalid modelError = "models: reason is not a known reason for a login to fail"
+)
+
+// Reasons a login can fail for. Only wrong passwords count
+// towards locking an account, the rest are kept for the audit
+// trail.
+const (
+	LoginNoUser   = "no_user"
+	LoginPassword = "password"
+	LoginLocked   = "locked"
+	LoginLinkOnly = "link_only"
+)
+
+// maxAttemptEmail is as much of the email address of a failed
+// login as is kept, since it is whatever was typed in.
+const maxAttemptEmail = 255
+
+// LoginAttempt represents the login_attempts table in our DB
+// and is a login that failed. UserID is nil when nobody has the
+// email address that was tried.
+type LoginAttempt struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    *uint  `gorm:"index"`
+	Email     string `gorm:"not null;index"`
+	IP        string
+	Reason    string `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+func NewLoginAttemptService(db *gorm.DB, lockAfter int, lockFor time.Duration) LoginAttemptService {
+	return &loginAttemptService{
+		LoginAttemptDB: &loginAttemptValidator{
+			LoginAttemptDB: &loginAttemptGorm{db},
+		},
+		lockAfter: lockAfter,
+		lockFor:   lockFor,
+	}
+}
+
+type LoginAttemptService interface {
+	// Failed records a failed login for the audit trail. user is
+	// who it was for, or nil if nobody has the email address.
+	// Once lockAfter wrong passwords have been tried for the
+	// user within lockFor, their account is locked for lockFor
+	// and locked is true, for the attempt that locked it only.
+	// The user's LockedUntil is set to match.
+	Failed(attempt *LoginAttempt, user *User) (locked bool, err error)
+	LoginAttemptDB
+}
+
+type LoginAttemptDB interface {
+	Create(attempt *LoginAttempt) error
+	// CountFailed is how many wrong passwords have been tried
+	// for the user since.
+	CountFailed(userID uint, since time.Time) (int, error)
+	// Lock locks the user's account until, unless it is locked
+	// already. locked is false if it was.
+	Lock(userID uint, until, now time.Time) (locked bool, err error)
+}
+
+var _ LoginAttemptService = &loginAttemptService{}
+
+type loginAttemptService struct {
+	LoginAttemptDB
+	lockAfter int
+	lockFor   time.Duration
+}
+
+func (las *loginAttemptService) Failed(attempt *LoginAttempt, user *User) (bool, error) {
+	if user != nil {
+		attempt.UserID = &user.ID
+	}
+	if err := las.Create(attempt); err != nil {
+		return false, err
+	}
+	if user == nil || attempt.Reason != LoginPassword || las.lockAfter <= 0 {
+		return false, nil
+	}
+	// Wrong passwords from before the account was last unlocked
+	// have been paid for already.
+	now := time.Now()
+	since := now.Add(-las.lockFor)
+	if user.LockedUntil != nil && user.LockedUntil.After(since) {
+		since = *user.LockedUntil
+	}
+	n, err := las.CountFailed(user.ID, since)
+	if err != nil {
+		return false, err
+	}
+	if n < las.lockAfter {
+		return false, nil
+	}
+	until := now.Add(las.lockFor)
+	locked, err := las.Lock(user.ID, until, now)
+	if err != nil {
+		return false, err
+	}
+	if locked {
+		user.LockedUntil = &until
+	}
+	return locked, nil
+}
+
+type loginAttemptValidator struct {
+	LoginAttemptDB
+}
+
+func (lav *loginAttemptValidator) Create(attempt *LoginAttempt) error {
+	err := runLoginAttemptValFns(attempt,
+		lav.normalizeEmail,
+		lav.truncateEmail,
+		lav.reasonIsValid,
+	)
+	if err != nil {
+		return err
+	}
+	return lav.LoginAttemptDB.Create(attempt)
+}
+
+func (lav *loginAttemptValidator) normalizeEmail(attempt *LoginAttempt) error {
+	attempt.Email = strings.ToLower(strings.TrimSpace(attempt.Email))
+	return nil
+}
+
+func (lav *loginAttemptValidator) truncateEmail(attempt *LoginAttempt) error {
+	if len(attempt.Email) <= maxAttemptEmail {
+		return nil
+	}
+	email := attempt.Email[:maxAttemptEmail]
+	for !utf8.ValidString(email) {
+		email = email[:len(email)-1]
+	}
+	attempt.Email = email
+	return nil
+}
+
+func (lav *loginAttemptValidator) reasonIsValid(attempt *LoginAttempt) error {
+	switch attempt.Reason {
+	case LoginNoUser, LoginPassword, LoginLocked, LoginLinkOnly:
+		return nil
+	default:
+		return ErrReasonInvalid
+	}
+}
+
+type loginAttemptValFn func(*LoginAttempt) error
+
+func runLoginAttemptValFns(attempt *LoginAttempt, fns ...loginAttemptValFn) error {
+	for _, fn := range fns {
+		if err := fn(attempt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ LoginAttemptDB = &loginAttemptGorm{}
+
+type loginAttemptGorm struct {
+	db *gorm.DB
+}
+
+func (lag *loginAttemptGorm) Create(attempt *LoginAttempt) error {
+	return lag.db.Create(attempt).Error
+}
+
+func (lag *loginAttemptGorm) CountFailed(userID uint, since time.Time) (int, error) {
+	var n int
+	err := lag.db.Model(&LoginAttempt{}).
+		Where("user_id = ? AND reason = ? AND created_at > ?", userID, LoginPassword, since).
+		Count(&n).Error
+	return n, err
+}
+
+func (lag *loginAttemptGorm) Lock(userID uint, until, now time.Time) (bool, error) {
+	// Only locking an account that isn't locked means that of
+	// the wrong passwords tried at the same time, just one locks
+	// it and sends the email to unlock it.
+	db := lag.db.Model(&User{}).
+		Where("id = ? AND (locked_until IS NULL OR locked_until <= ?)", userID, now).
+		UpdateColumn("locked_until", until)
+	if db.Error != nil {
+		return false, db.Error
+	}
+	return db.RowsAffected == 1, nil
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/sirodoht/heartfort/hash"
+	"github.com/sirodoht/heartfort/rand"
+)
+
+// loginChallengeLifetime is how long a user has to enter their
+// code once their password has been accepted.
+const loginChallengeLifetime = 10 * time.Minute
+
+// loginChallengeAttempts is how many wrong codes can be entered
+// before the user has to start over with their password.
+const loginChallengeAttempts = 5
+
+// loginChallenge is a login whose password was right and is
+// waiting for the second factor. The token goes in the
+// browser's cookie until then, and only its hash is kept.
+type loginChallenge struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"not null;index"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not null;unique_index"`
+	Attempts  int    `gorm:"not null;default:0"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type loginChallengeDB interface {
+	ByToken(token string) (*loginChallenge, error)
+	Create(lc *loginChallenge) error
+	// Attempt uses up one of the attempts at entering a code.
+	// ErrTokenInvalid is returned if there are none left.
+	Attempt(id uint) error
+	Delete(id uint) error
+}
+
+func newLoginChallengeValidator(db loginChallengeDB, hmac hash.HMAC) *loginChallengeValidator {
+	return &loginChallengeValidator{
+		loginChallengeDB: db,
+		hmac:             hmac,
+	}
+}
+
+type loginChallengeValidator struct {
+	loginChallengeDB
+	hmac hash.HMAC
+}
+
+func (lcv *loginChallengeValidator) ByToken(token string) (*loginChallenge, error) {
+	lc := loginChallenge{Token: token}
+	if err := runLoginChallengeValFns(&lc, lcv.hmacToken); err != nil {
+		return nil, err
+	}
+	if lc.TokenHash == "" {
+		return nil, ErrNotFound
+	}
+	return lcv.loginChallengeDB.ByToken(lc.TokenHash)
+}
+
+func (lcv *loginChallengeValidator) Create(lc *loginChallenge) error {
+	err := runLoginChallengeValFns(lc,
+		lcv.requireUserID,
+		lcv.setTokenIfUnset,
+		lcv.hmacToken,
+		lcv.setExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+	return lcv.loginChallengeDB.Create(lc)
+}
+
+func (lcv *loginChallengeValidator) requireUserID(lc *loginChallenge) error {
+	if lc.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (lcv *loginChallengeValidator) setTokenIfUnset(lc *loginChallenge) error {
+	if lc.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	lc.Token = token
+	return nil
+}
+
+func (lcv *loginChallengeValidator) hmacToken(lc *loginChallenge) error {
+	if lc.Token == "" {
+		return nil
+	}
+	lc.TokenHash = lcv.hmac.Hash(lc.Token)
+	return nil
+}
+
+func (lcv *loginChallengeValidator) setExpiresAt(lc *loginChallenge) error {
+	lc.ExpiresAt = time.Now().Add(loginChallengeLifetime)
+	return nil
+}
+
+type loginChallengeValFn func(*loginChallenge) error
+
+func runLoginChallengeValFns(lc *loginChallenge, fns ...loginChallengeValFn) error {
+	for _, fn := range fns {
+		if err := fn(lc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ loginChallengeDB = &loginChallengeGorm{}
+
+type loginChallengeGorm struct {
+	db *gorm.DB
+}
+
+func (lcg *loginChallengeGorm) ByToken(tokenHash string) (*loginChallenge, error) {
+	var lc loginChallenge
+	err := first(lcg.db.Where("token_hash = ?", tokenHash), &lc)
+	if err != nil {
+		return nil, err
+	}
+	return &lc, nil
+}
+
+func (lcg *loginChallengeGorm) Create(lc *loginChallenge) error {
+	return lcg.db.Create(lc).Error
+}
+
+func (lcg *loginChallengeGorm) Attempt(id uint) error {
+	// Counting in the update means codes entered at the same
+	// time can't get past the limit.
+	db := lcg.db.Model(&loginChallenge{}).
+		Where("id = ? AND attempts < ?", id, loginChallengeAttempts).
+		UpdateColumn("attempts", gorm.Expr("attempts + 1"))
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+func (lcg *loginChallengeGorm) Delete(id uint) error {
+	return lcg.db.Where("id = ?", id).Delete(&loginChallenge{}).Error
+}
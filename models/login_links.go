@@ -0,0 +1,174 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/sirodoht/heartfort/hash"
+	"github.com/sirodoht/heartfort/rand"
+)
+
+const (
+	ErrTooManyLoginLinks modelError = "models: too many login links have been sent, please try again later"
+)
+
+// loginLinkLifetime is how long a login link works for.
+const loginLinkLifetime = 15 * time.Minute
+
+// loginLinkMax is how many login links can be sent to the same
+// address in loginLinkWindow, so nobody can flood someone's
+// inbox with them.
+const (
+	loginLinkMax    = 3
+	loginLinkWindow = time.Hour
+)
+
+// loginLink is a link emailed to a user that logs them in once
+// without their password. Like password resets, only the hash of
+// the token is kept. A used link is deleted, so it can't be used
+// again.
+type loginLink struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"not null;index"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not null;unique_index"`
+	CreatedAt time.Time
+}
+
+type loginLinkDB interface {
+	ByToken(token string) (*loginLink, error)
+	// CountSince is how many links the user has been sent since.
+	CountSince(userID uint, since time.Time) (int, error)
+	Create(ll *loginLink) error
+	// Use deletes the link. ErrTokenInvalid is returned if it
+	// has been used already.
+	Use(id uint) error
+}
+
+func newLoginLinkValidator(db loginLinkDB, hmac hash.HMAC) *loginLinkValidator {
+	return &loginLinkValidator{
+		loginLinkDB: db,
+		hmac:        hmac,
+	}
+}
+
+type loginLinkValidator struct {
+	loginLinkDB
+	hmac hash.HMAC
+}
+
+func (llv *loginLinkValidator) ByToken(token string) (*loginLink, error) {
+	ll := loginLink{Token: token}
+	if err := runLoginLinkValFns(&ll, llv.hmacToken); err != nil {
+		return nil, err
+	}
+	if ll.TokenHash == "" {
+		return nil, ErrNotFound
+	}
+	return llv.loginLinkDB.ByToken(ll.TokenHash)
+}
+
+func (llv *loginLinkValidator) Create(ll *loginLink) error {
+	err := runLoginLinkValFns(ll,
+		llv.requireUserID,
+		llv.setTokenIfUnset,
+		llv.hmacToken,
+	)
+	if err != nil {
+		return err
+	}
+	return llv.loginLinkDB.Create(ll)
+}
+
+func (llv *loginLinkValidator) Use(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return llv.loginLinkDB.Use(id)
+}
+
+func (llv *loginLinkValidator) requireUserID(ll *loginLink) error {
+	if ll.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (llv *loginLinkValidator) setTokenIfUnset(ll *loginLink) error {
+	if ll.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	ll.Token = token
+	return nil
+}
+
+func (llv *loginLinkValidator) hmacToken(ll *loginLink) error {
+	if ll.Token == "" {
+		return nil
+	}
+	ll.TokenHash = llv.hmac.Hash(ll.Token)
+	return nil
+}
+
+type loginLinkValFn func(*loginLink) error
+
+func runLoginLinkValFns(ll *loginLink, fns ...loginLinkValFn) error {
+	for _, fn := range fns {
+		if err := fn(ll); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ loginLinkDB = &loginLinkGorm{}
+
+type loginLinkGorm struct {
+	db *gorm.DB
+}
+
+func (llg *loginLinkGorm) ByToken(tokenHash string) (*loginLink, error) {
+	var ll loginLink
+	err := first(llg.db.Where("token_hash = ?", tokenHash), &ll)
+	if err != nil {
+		return nil, err
+	}
+	return &ll, nil
+}
+
+func (llg *loginLinkGorm) CountSince(userID uint, since time.Time) (int, error) {
+	var n int
+	err := llg.db.Model(&loginLink{}).
+		Where("user_id = ? AND created_at > ?", userID, since).
+		Count(&n).Error
+	return n, err
+}
+
+func (llg *loginLinkGorm) Create(ll *loginLink) error {
+	// Links that have expired are no use to anyone, nor do they
+	// count towards the limit anymore.
+	err := llg.db.Where("user_id = ? AND created_at < ?", ll.UserID, time.Now().Add(-loginLinkWindow)).
+		Delete(&loginLink{}).Error
+	if err != nil {
+		return err
+	}
+	return llg.db.Create(ll).Error
+}
+
+func (llg *loginLinkGorm) Use(id uint) error {
+	// Deleting is what uses the link up, so if it is clicked
+	// twice at once only one of them gets to log in.
+	db := llg.db.Where("id = ?", id).Delete(&loginLink{})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrTokenInvalid
+	}
+	return nil
+}
@@ -1,34 +1,186 @@
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"crypto/subtle"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 
+	"github.com/jinzhu/gorm"
+
+	"github.com/sirodoht/heartfort/hash"
+)
+
+// A mate is pending from signing up until they follow the link
+// in the confirmation email, and only confirmed mates are sent
+// notifications. Unsubscribing keeps the mate around so that
+// signing up again picks the same row back up.
+const (
+	MatePending      = "pending"
+	MateConfirmed    = "confirmed"
+	MateUnsubscribed = "unsubscribed"
+)
+
+const (
+	ErrMateStatusInvalid modelError = "models: mate status is not valid"
+)
+
+const (
+	mateConfirmPurpose     = "confirm"
+	mateUnsubscribePurpose = "unsubscribe"
+)
+
+// Mate represents the mates table in our DB and is an email
+// address signed up for a household's notifications. Mates
+// from before sign ups had to be confirmed default to being
+// confirmed so they keep getting emails.
 type Mate struct {
 	gorm.Model
-	Email string `gorm:"not_null"`
+	HouseholdID uint   `gorm:"index"`
+	Email       string `gorm:"not_null"`
+	Status      string `gorm:"not null;default:'confirmed'"`
 }
 
-func NewMateService(db *gorm.DB) MateService {
+func NewMateService(db *gorm.DB, hmacKey string) MateService {
 	return &mateService{
 		MateDB: &mateValidator{
 			MateDB: &mateGorm{
 				db: db,
 			},
+			emailRegex: regexp.MustCompile(emailPattern),
 		},
+		hmac: hash.NewHMAC(hmacKey),
 	}
 }
 
 type MateService interface {
+	// Subscribe signs the email address up for the household's
+	// notifications. Signing up an address that is already
+	// there returns the existing mate instead of a duplicate,
+	// and one that had unsubscribed goes back to pending.
+	Subscribe(householdID uint, email string) (*Mate, error)
+	// ConfirmToken and UnsubscribeToken sign the links emailed
+	// to a mate. Both stop working if the mate's email changes.
+	ConfirmToken(mate *Mate) string
+	UnsubscribeToken(mate *Mate) string
+	// Confirm and Unsubscribe look up the mate a token was made
+	// for and set their status, reporting whether it changed
+	// rather than being the status already. If the token is not
+	// valid ErrTokenInvalid is returned.
+	Confirm(token string) (*Mate, bool, error)
+	Unsubscribe(token string) (*Mate, bool, error)
 	MateDB
 }
 
+var _ MateService = &mateService{}
+
 type mateService struct {
 	MateDB
+	hmac hash.HMAC
+}
+
+func (ms *mateService) Subscribe(householdID uint, email string) (*Mate, error) {
+	mate, err := ms.ByEmail(householdID, normalizeMateEmail(email))
+	switch err {
+	case nil:
+		if mate.Status == MateUnsubscribed {
+			mate.Status = MatePending
+			if err := ms.Update(mate); err != nil {
+				return nil, err
+			}
+		}
+		return mate, nil
+	case ErrNotFound:
+		mate = &Mate{
+			HouseholdID: householdID,
+			Email:       email,
+			Status:      MatePending,
+		}
+		if err := ms.Create(mate); err != nil {
+			return nil, err
+		}
+		return mate, nil
+	default:
+		return nil, err
+	}
+}
+
+func (ms *mateService) ConfirmToken(mate *Mate) string {
+	return ms.token(mateConfirmPurpose, mate)
+}
+
+func (ms *mateService) UnsubscribeToken(mate *Mate) string {
+	return ms.token(mateUnsubscribePurpose, mate)
+}
+
+func (ms *mateService) Confirm(token string) (*Mate, bool, error) {
+	return ms.setStatus(mateConfirmPurpose, token, MateConfirmed)
+}
+
+func (ms *mateService) Unsubscribe(token string) (*Mate, bool, error) {
+	return ms.setStatus(mateUnsubscribePurpose, token, MateUnsubscribed)
+}
+
+func (ms *mateService) setStatus(purpose, token, status string) (*Mate, bool, error) {
+	mate, err := ms.byToken(purpose, token)
+	if err != nil {
+		return nil, false, err
+	}
+	if mate.Status == status {
+		return mate, false, nil
+	}
+	mate.Status = status
+	if err := ms.Update(mate); err != nil {
+		return nil, false, err
+	}
+	return mate, true, nil
+}
+
+// token is the mate's ID followed by an HMAC of what the token
+// is for, the ID and the email address, so nothing needs to be
+// stored to check it later.
+func (ms *mateService) token(purpose string, mate *Mate) string {
+	return fmt.Sprintf("%d.%s", mate.ID, ms.sign(purpose, mate))
+}
+
+func (ms *mateService) byToken(purpose, token string) (*Mate, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrTokenInvalid
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	mate, err := ms.ByID(uint(id))
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	expected := ms.sign(purpose, mate)
+	if subtle.ConstantTimeCompare([]byte(parts[1]), []byte(expected)) != 1 {
+		return nil, ErrTokenInvalid
+	}
+	return mate, nil
+}
+
+func (ms *mateService) sign(purpose string, mate *Mate) string {
+	return ms.hmac.Hash(fmt.Sprintf("%s:%d:%s", purpose, mate.ID, mate.Email))
 }
 
 // MateDB is used to interact with the mates database.
 type MateDB interface {
 	ByID(id uint) (*Mate, error)
-	List() ([]Mate, error)
+	// ByEmail looks up the mate of a household with the given,
+	// already normalized, email address.
+	ByEmail(householdID uint, email string) (*Mate, error)
+	ByHouseholdID(householdID uint) ([]Mate, error)
+	// Confirmed returns the mates of a household that emails
+	// like the weekly digest can be sent to.
+	Confirmed(householdID uint) ([]Mate, error)
 	Create(mate *Mate) error
 	Update(mate *Mate) error
 	Delete(id uint) error
@@ -36,10 +188,18 @@ type MateDB interface {
 
 type mateValidator struct {
 	MateDB
+	emailRegex *regexp.Regexp
 }
 
 func (mv *mateValidator) Create(mate *Mate) error {
-	err := runMateValFns(mate, mv.emailRequired)
+	err := runMateValFns(mate,
+		mv.householdIDRequired,
+		mv.normalizeEmail,
+		mv.emailRequired,
+		mv.emailFormat,
+		mv.emailIsAvail,
+		mv.statusValid,
+	)
 	if err != nil {
 		return err
 	}
@@ -47,7 +207,14 @@ func (mv *mateValidator) Create(mate *Mate) error {
 }
 
 func (mv *mateValidator) Update(mate *Mate) error {
-	err := runMateValFns(mate, mv.emailRequired)
+	err := runMateValFns(mate,
+		mv.householdIDRequired,
+		mv.normalizeEmail,
+		mv.emailRequired,
+		mv.emailFormat,
+		mv.emailIsAvail,
+		mv.statusValid,
+	)
 	if err != nil {
 		return err
 	}
@@ -79,9 +246,30 @@ func (jg *mateGorm) ByID(id uint) (*Mate, error) {
 	return &mate, nil
 }
 
-func (jg *mateGorm) List() ([]Mate, error) {
+func (jg *mateGorm) ByEmail(householdID uint, email string) (*Mate, error) {
+	var mate Mate
+	db := jg.db.Where("household_id = ? AND email = ?", householdID, email)
+	err := first(db, &mate)
+	if err != nil {
+		return nil, err
+	}
+	return &mate, nil
+}
+
+func (jg *mateGorm) ByHouseholdID(householdID uint) ([]Mate, error) {
+	var mates []Mate
+	db := jg.db.Where("household_id = ?", householdID).Order("id")
+	if err := db.Find(&mates).Error; err != nil {
+		return nil, err
+	}
+	return mates, nil
+}
+
+func (jg *mateGorm) Confirmed(householdID uint) ([]Mate, error) {
 	var mates []Mate
-	if err := jg.db.Find(&mates).Error; err != nil {
+	db := jg.db.Where("household_id = ? AND status = ?", householdID, MateConfirmed).
+		Order("id")
+	if err := db.Find(&mates).Error; err != nil {
 		return nil, err
 	}
 	return mates, nil
@@ -100,6 +288,18 @@ func (jg *mateGorm) Delete(id uint) error {
 	return jg.db.Delete(&mate).Error
 }
 
+func (mv *mateValidator) householdIDRequired(mate *Mate) error {
+	if mate.HouseholdID <= 0 {
+		return ErrHouseholdIDRequired
+	}
+	return nil
+}
+
+func (mv *mateValidator) normalizeEmail(mate *Mate) error {
+	mate.Email = normalizeMateEmail(mate.Email)
+	return nil
+}
+
 func (mv *mateValidator) emailRequired(mate *Mate) error {
 	if mate.Email == "" {
 		return ErrEmailRequired
@@ -107,6 +307,40 @@ func (mv *mateValidator) emailRequired(mate *Mate) error {
 	return nil
 }
 
+func (mv *mateValidator) emailFormat(mate *Mate) error {
+	if !mv.emailRegex.MatchString(mate.Email) {
+		return ErrEmailInvalid
+	}
+	return nil
+}
+
+// emailIsAvail makes sure an address is only signed up to a
+// household once.
+func (mv *mateValidator) emailIsAvail(mate *Mate) error {
+	existing, err := mv.ByEmail(mate.HouseholdID, mate.Email)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if mate.ID != existing.ID {
+		return ErrEmailTaken
+	}
+	return nil
+}
+
+func (mv *mateValidator) statusValid(mate *Mate) error {
+	switch mate.Status {
+	case "":
+		mate.Status = MatePending
+	case MatePending, MateConfirmed, MateUnsubscribed:
+	default:
+		return ErrMateStatusInvalid
+	}
+	return nil
+}
+
 func (mv *mateValidator) nonZeroID(mate *Mate) error {
 	if mate.ID <= 0 {
 		return ErrIDInvalid
@@ -114,6 +348,10 @@ func (mv *mateValidator) nonZeroID(mate *Mate) error {
 	return nil
 }
 
+func normalizeMateEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 type mateValFn func(*Mate) error
 
 func runMateValFns(mate *Mate, fns ...mateValFn) error {
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	ErrAlreadySent modelError = "models: notification has already been sent"
+
+	ErrKindRequired modelError = "models: notification kind is required"
+
+	ErrKeyRequired modelError = "models: notification key is required"
+)
+
+// Notification represents the notifications table in our DB and
+// is a record of an email the scheduler sent. Kind says what
+// sort of email it was and Key what it was about, eg the
+// assignment it reminded someone of, so that the same email is
+// never sent twice, even after a restart. The same goes for
+// messages sent to chats, whose Email is the chat they went to.
+type Notification struct {
+	ID        uint   `gorm:"primary_key"`
+	Kind      string `gorm:"not null;unique_index:idx_notifications_kind_key"`
+	Key       string `gorm:"not null;unique_index:idx_notifications_kind_key"`
+	Email     string `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+func NewNotificationService(db *gorm.DB) NotificationService {
+	return &notificationService{
+		NotificationDB: &notificationValidator{
+			NotificationDB: &notificationGorm{db},
+		},
+	}
+}
+
+type NotificationService interface {
+	NotificationDB
+}
+
+var _ NotificationService = &notificationService{}
+
+type notificationService struct {
+	NotificationDB
+}
+
+// NotificationDB is used to interact with the notifications
+// database.
+type NotificationDB interface {
+	// Claim records the notification before it is sent. If it
+	// has been claimed already ErrAlreadySent is returned and it
+	// must not be sent again.
+	Claim(notification *Notification) error
+	// Release forgets a claimed notification whose email
+	// couldn't be sent so it is tried again later.
+	Release(notification *Notification) error
+}
+
+type notificationValidator struct {
+	NotificationDB
+}
+
+func (nv *notificationValidator) Claim(notification *Notification) error {
+	notification.Kind = strings.TrimSpace(notification.Kind)
+	if notification.Kind == "" {
+		return ErrKindRequired
+	}
+	notification.Key = strings.TrimSpace(notification.Key)
+	if notification.Key == "" {
+		return ErrKeyRequired
+	}
+	if notification.Email == "" {
+		return ErrEmailRequired
+	}
+	return nv.NotificationDB.Claim(notification)
+}
+
+var _ NotificationDB = &notificationGorm{}
+
+type notificationGorm struct {
+	db *gorm.DB
+}
+
+func (ng *notificationGorm) Claim(notification *Notification) error {
+	// Another server may be claiming the same notification at
+	// the same time, so let the unique index decide who wins.
+	// gorm reads the new ID back with RETURNING, which has no
+	// row to give when nothing was inserted.
+	err := ng.db.Set("gorm:insert_option", "ON CONFLICT DO NOTHING").
+		Create(notification).Error
+	if err == sql.ErrNoRows {
+		return ErrAlreadySent
+	}
+	return err
+}
+
+func (ng *notificationGorm) Release(notification *Notification) error {
+	return ng.db.Where("id = ?", notification.ID).
+		Delete(&Notification{}).Error
+}
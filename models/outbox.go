@@ -0,0 +1,243 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/sirodoht/heartfort/email"
+)
+
+// An outbox message is pending until it is delivered, and dead
+// once every attempt to deliver it has failed. Dead messages
+// stay until someone resends them or they are purged.
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxDead    = "dead"
+)
+
+// OutboxMessage represents the outbox_messages table in our DB
+// and is an email waiting to be delivered, or that was. Headers
+// are the extra headers of the email as JSON. Emails carry
+// tokens like password reset links, so once a message is sent
+// only who it went to and when is kept.
+type OutboxMessage struct {
+	ID            uint      `gorm:"primary_key"`
+	From          string    `gorm:"not null"`
+	To            string    `gorm:"not null"`
+	Subject       string    `gorm:"not null"`
+	Text          string    `gorm:"type:text"`
+	HTML          string    `gorm:"type:text"`
+	Headers       string    `gorm:"type:text"`
+	Status        string    `gorm:"not null;default:'pending';index:idx_outbox_messages_status_next"`
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"not null;index:idx_outbox_messages_status_next"`
+	LastError     string    `gorm:"type:text"`
+	SentAt        *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func NewOutboxService(db *gorm.DB) OutboxService {
+	return &outboxService{
+		OutboxDB: &outboxGorm{db},
+	}
+}
+
+// OutboxService is the store behind the email outbox.
+type OutboxService interface {
+	email.OutboxStore
+	OutboxDB
+}
+
+var _ OutboxService = &outboxService{}
+
+type outboxService struct {
+	OutboxDB
+}
+
+func (obs *outboxService) Enqueue(message *email.Message) error {
+	var headers []byte
+	if len(message.Headers) > 0 {
+		var err error
+		headers, err = json.Marshal(message.Headers)
+		if err != nil {
+			return err
+		}
+	}
+	return obs.Create(&OutboxMessage{
+		From:          message.From,
+		To:            message.To,
+		Subject:       message.Subject,
+		Text:          message.Text,
+		HTML:          message.HTML,
+		Headers:       string(headers),
+		Status:        OutboxPending,
+		NextAttemptAt: time.Now(),
+	})
+}
+
+func (obs *outboxService) Claim(now time.Time, lease time.Duration, limit int) ([]email.Queued, error) {
+	claimed, err := obs.ClaimDue(now, lease, limit)
+	if err != nil {
+		return nil, err
+	}
+	queued := make([]email.Queued, 0, len(claimed))
+	for _, m := range claimed {
+		message := email.Message{
+			From:    m.From,
+			To:      m.To,
+			Subject: m.Subject,
+			Text:    m.Text,
+			HTML:    m.HTML,
+		}
+		if m.Headers != "" {
+			if err := json.Unmarshal([]byte(m.Headers), &message.Headers); err != nil {
+				return nil, err
+			}
+		}
+		queued = append(queued, email.Queued{
+			ID:       m.ID,
+			Attempts: m.Attempts,
+			Message:  message,
+		})
+	}
+	return queued, nil
+}
+
+// OutboxDB is used to interact with the outbox_messages
+// database.
+type OutboxDB interface {
+	ByID(id uint) (*OutboxMessage, error)
+	// Dead returns the messages that couldn't be delivered, with
+	// the latest first.
+	Dead() ([]OutboxMessage, error)
+	Create(message *OutboxMessage) error
+	// ClaimDue counts an attempt against up to limit pending
+	// messages that are due by now and pushes their next attempt
+	// back by lease so no other worker claims them meanwhile.
+	ClaimDue(now time.Time, lease time.Duration, limit int) ([]OutboxMessage, error)
+	Delivered(id uint) error
+	Retry(id uint, reason string, at time.Time) error
+	DeadLetter(id uint, reason string) error
+	// Resend puts a dead message back in the queue with a fresh
+	// set of attempts.
+	Resend(id uint) error
+	// Purge deletes the messages that were sent or died before,
+	// and blanks any sent message that still has its body.
+	Purge(before time.Time) error
+}
+
+var _ OutboxDB = &outboxGorm{}
+
+type outboxGorm struct {
+	db *gorm.DB
+}
+
+func (og *outboxGorm) ByID(id uint) (*OutboxMessage, error) {
+	var message OutboxMessage
+	err := first(og.db.Where("id = ?", id), &message)
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+func (og *outboxGorm) Dead() ([]OutboxMessage, error) {
+	var messages []OutboxMessage
+	db := og.db.Where("status = ?", OutboxDead).Order("updated_at DESC, id DESC")
+	if err := db.Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (og *outboxGorm) Create(message *OutboxMessage) error {
+	return og.db.Create(message).Error
+}
+
+func (og *outboxGorm) ClaimDue(now time.Time, lease time.Duration, limit int) ([]OutboxMessage, error) {
+	var messages []OutboxMessage
+	// SKIP LOCKED lets workers, in this process or another, claim
+	// different messages at the same time without waiting.
+	err := og.db.Raw(`UPDATE outbox_messages
+		SET attempts = attempts + 1, next_attempt_at = ?, updated_at = ?
+		WHERE id IN (
+			SELECT id FROM outbox_messages
+			WHERE status = ? AND next_attempt_at <= ?
+			ORDER BY next_attempt_at, id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, now.Add(lease), now, OutboxPending, now, limit).
+		Scan(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (og *outboxGorm) Delivered(id uint) error {
+	return og.update(id, map[string]interface{}{
+		"status":     OutboxSent,
+		"sent_at":    time.Now(),
+		"last_error": "",
+		"text":       "",
+		"html":       "",
+		"headers":    "",
+	})
+}
+
+func (og *outboxGorm) Retry(id uint, reason string, at time.Time) error {
+	return og.update(id, map[string]interface{}{
+		"next_attempt_at": at,
+		"last_error":      reason,
+	})
+}
+
+func (og *outboxGorm) DeadLetter(id uint, reason string) error {
+	return og.update(id, map[string]interface{}{
+		"status":     OutboxDead,
+		"last_error": reason,
+	})
+}
+
+func (og *outboxGorm) Resend(id uint) error {
+	db := og.db.Model(&OutboxMessage{}).
+		Where("id = ? AND status = ?", id, OutboxDead).
+		Updates(map[string]interface{}{
+			"status":          OutboxPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (og *outboxGorm) Purge(before time.Time) error {
+	err := og.db.Where("(status = ? AND sent_at < ?) OR (status = ? AND updated_at < ?)",
+		OutboxSent, before, OutboxDead, before).
+		Delete(&OutboxMessage{}).Error
+	if err != nil {
+		return err
+	}
+	// Messages sent before bodies were blanked on delivery.
+	return og.db.Model(&OutboxMessage{}).
+		Where("status = ? AND (text <> '' OR html <> '' OR headers <> '')", OutboxSent).
+		UpdateColumns(map[string]interface{}{
+			"text":    "",
+			"html":    "",
+			"headers": "",
+		}).Error
+}
+
+func (og *outboxGorm) update(id uint, fields map[string]interface{}) error {
+	return og.db.Model(&OutboxMessage{ID: id}).Updates(fields).Error
+}
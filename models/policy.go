@@ -0,0 +1,132 @@
+package models
+
+const (
+	ErrRoleInvalid modelError = "models: role must be owner, admin, member or guest"
+)
+
+// The roles a user can have in a household, from the most
+// powerful to the least. Owners can do everything, including
+// changing roles. Admins manage jobs, the rota, mates and
+// invitations. Members work on their own assignments. Guests
+// can only look.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleGuest  = "guest"
+)
+
+// Roles lists every role from the most powerful to the least.
+var Roles = []string{RoleOwner, RoleAdmin, RoleMember, RoleGuest}
+
+var roleRanks = map[string]int{
+	RoleGuest:  1,
+	RoleMember: 2,
+	RoleAdmin:  3,
+	RoleOwner:  4,
+}
+
+// AtLeast reports whether the membership's role is the given
+// role or a more powerful one.
+func (m *Membership) AtLeast(role string) bool {
+	if m == nil {
+		return false
+	}
+	return roleRanks[m.Role] >= roleRanks[role]
+}
+
+// rolesAtLeast returns the given role and every more powerful
+// one.
+func rolesAtLeast(role string) []string {
+	var roles []string
+	for _, r := range Roles {
+		if roleRanks[r] >= roleRanks[role] {
+			roles = append(roles, r)
+		}
+	}
+	return roles
+}
+
+// CanManageJobs reports whether jobs and their checklists can
+// be created, changed and deleted.
+func (m *Membership) CanManageJobs() bool {
+	return m.AtLeast(RoleAdmin)
+}
+
+// CanManageRota reports whether assignments can be generated,
+// created, changed and deleted.
+func (m *Membership) CanManageRota() bool {
+	return m.AtLeast(RoleAdmin)
+}
+
+// CanManageMates reports whether mates can be changed and
+// deleted.
+func (m *Membership) CanManageMates() bool {
+	return m.AtLeast(RoleAdmin)
+}
+
+// CanManageMembers reports whether people can be invited to the
+// household and invitations revoked.
+func (m *Membership) CanManageMembers() bool {
+	return m.AtLeast(RoleAdmin)
+}
+
+// CanManageWebhooks reports whether the household's webhooks
+// can be seen, changed and deleted. Their secrets are on show,
+// so members can't even see them.
+func (m *Membership) CanManageWebhooks() bool {
+	return m.AtLeast(RoleAdmin)
+}
+
+// CanRequireTwoFactor reports whether members can be made to
+// turn on two-factor authentication.
+func (m *Membership) CanRequireTwoFactor() bool {
+	return m.AtLeast(RoleAdmin)
+}
+
+// CanChangeRole reports whether the other membership can be
+// given a new role. Only owners can change roles, and never
+// their own so a household always keeps an owner.
+func (m *Membership) CanChangeRole(other *Membership) bool {
+	return m.AtLeast(RoleOwner) && m.UserID != other.UserID
+}
+
+// CanWorkOn reports whether the checklist of the assignment can
+// be ticked off. Members can only work on their own assignments.
+func (m *Membership) CanWorkOn(a *Assignment) bool {
+	if m.CanManageRota() {
+		return true
+	}
+	return m.AtLeast(RoleMember) && a.UserID == m.UserID
+}
+
+// CanSetStatus reports whether the assignment can be moved to
+// status. Members can start, finish or skip their own
+// assignments, but only admins can say one was missed.
+func (m *Membership) CanSetStatus(a *Assignment, status string) bool {
+	if m.CanManageRota() {
+		return true
+	}
+	return m.CanWorkOn(a) && status != StatusMissed
+}
+
+// CanSwap reports whether the assignment can be offered up for
+// a swap. Only members can swap and only their own assignments.
+func (m *Membership) CanSwap(a *Assignment) bool {
+	return m.AtLeast(RoleMember) && a.UserID == m.UserID
+}
+
+// CanAnswerSwaps reports whether swaps can be accepted or
+// declined. Guests can't take on assignments.
+func (m *Membership) CanAnswerSwaps() bool {
+	return m.AtLeast(RoleMember)
+}
+
+// CanManageAbsence reports whether the absence can be created,
+// changed or deleted. Members can only manage their own.
+func (m *Membership) CanManageAbsence(a *Absence) bool {
+	if m.CanManageRota() {
+		return true
+	}
+	return m.AtLeast(RoleMember) && a.UserID == m.UserID
+}
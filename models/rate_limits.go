@@ -0,0 +1,77 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/sirodoht/heartfort/ratelimit"
+)
+
+// RateLimit represents the rate_limits table in our DB and is
+// one token bucket, for servers that share their limits through
+// the database instead of keeping them in memory. TakenAt is
+// when a token was last taken, or tried to be.
+type RateLimit struct {
+	Key     string    `gorm:"primary_key"`
+	Tokens  float64   `gorm:"not null"`
+	TakenAt time.Time `gorm:"not null;index"`
+}
+
+func NewRateLimitService(db *gorm.DB) RateLimitService {
+	return &rateLimitGorm{db}
+}
+
+// RateLimitService is the store behind rate limiters when they
+// are shared by several servers.
+type RateLimitService interface {
+	ratelimit.Store
+	// DeleteStale deletes the buckets nothing has been taken
+	// from since before, which have long filled up again.
+	DeleteStale(before time.Time) error
+}
+
+var _ RateLimitService = &rateLimitGorm{}
+
+type rateLimitGorm struct {
+	db *gorm.DB
+}
+
+func (rlg *rateLimitGorm) Take(key string, limit ratelimit.Limit, now time.Time) (time.Duration, bool, error) {
+	var retryAfter time.Duration
+	var ok bool
+	err := rlg.db.Transaction(func(tx *gorm.DB) error {
+		// A new bucket starts out full. If another server makes
+		// it first, or it is there already, we use that one. This
+		// is an Exec rather than a Create, as gorm would want a
+		// row back from the insert that didn't happen.
+		err := tx.Exec("INSERT INTO rate_limits (key, tokens, taken_at) VALUES (?, ?, ?) ON CONFLICT (key) DO NOTHING",
+			key, float64(limit.Burst), now).Error
+		if err != nil {
+			return err
+		}
+		// Locking the bucket means servers taking from it at the
+		// same time wait for each other, so no token is taken
+		// twice.
+		var rl RateLimit
+		db := tx.Set("gorm:query_option", "FOR UPDATE").Where("key = ?", key)
+		if err := first(db, &rl); err != nil {
+			return err
+		}
+		var left float64
+		left, retryAfter, ok = ratelimit.Take(rl.Tokens, rl.TakenAt, now, limit)
+		return tx.Model(&RateLimit{}).Where("key = ?", key).
+			UpdateColumns(map[string]interface{}{
+				"tokens":   left,
+				"taken_at": now,
+			}).Error
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return retryAfter, ok, nil
+}
+
+func (rlg *rateLimitGorm) DeleteStale(before time.Time) error {
+	return rlg.db.Where("taken_at < ?", before).Delete(&RateLimit{}).Error
+}
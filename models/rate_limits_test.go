@@ -0,0 +1,60 @@
+package models
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/sirodoht/heartfort/ratelimit"
+)
+
+// testDB connects to the Postgres database named by
+// HEARTFORT_TEST_DB, eg "host=localhost user=postgres
+// dbname=heartfort_test sslmode=disable", and skips the test if
+// there isn't one.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("HEARTFORT_TEST_DB")
+	if dsn == "" {
+		t.Skip("HEARTFORT_TEST_DB is not set")
+	}
+	db, err := gorm.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestRateLimitTakeTwice(t *testing.T) {
+	db := testDB(t)
+	if err := db.AutoMigrate(&RateLimit{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	key := "test:" + t.Name()
+	db.Where("key = ?", key).Delete(&RateLimit{})
+	defer db.Where("key = ?", key).Delete(&RateLimit{})
+
+	rls := NewRateLimitService(db)
+	limit := ratelimit.Limit{Burst: 1, Every: time.Hour}
+	now := time.Now()
+	_, ok, err := rls.Take(key, limit, now)
+	if err != nil {
+		t.Fatalf("first take: %v", err)
+	}
+	if !ok {
+		t.Fatal("first take: want a token from a new bucket")
+	}
+	retryAfter, ok, err := rls.Take(key, limit, now.Add(time.Second))
+	if err != nil {
+		t.Fatalf("second take: %v", err)
+	}
+	if ok {
+		t.Fatal("second take: want the bucket to be empty")
+	}
+	if retryAfter <= 0 {
+		t.Fatalf("second take: retryAfter = %v, want more than 0", retryAfter)
+	}
+}
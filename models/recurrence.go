@@ -0,0 +1,253 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+
+	ErrIntervalUnitInvalid modelError = "models: repeat unit must be week or month"
+
+	ErrIntervalCountInvalid modelError = "models: repeat count must be at least 1"
+
+	ErrRRuleInvalid modelError = "models: RRULE is not valid"
+)
+
+// maxOccurrences bounds how far we walk a recurrence looking
+// for a week, so a rule whose occurrences hardly ever exist,
+// like the 31st of every other month, can't keep us busy
+// forever.
+const maxOccurrences = 10000
+
+// recurrence is the subset of an RFC 5545 RRULE that jobs
+// understand: FREQ, INTERVAL, COUNT and UNTIL.
+type recurrence struct {
+	freq     string
+	interval int
+	count    int
+	until    *time.Time
+	// clamp moves occurrences that don't exist, like the 31st
+	// of a short month, to the last day of the month instead
+	// of skipping them as RFC 5545 would.
+	clamp bool
+}
+
+// parseRRule parses a recurrence rule such as
+// "FREQ=MONTHLY;INTERVAL=2". A leading "RRULE:" is allowed.
+func parseRRule(rule string) (*recurrence, error) {
+	rule = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(rule)), "RRULE:")
+	rc := recurrence{interval: 1}
+	for _, part := range strings.Split(rule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, ErrRRuleInvalid
+		}
+		switch kv[0] {
+		case "FREQ":
+			switch kv[1] {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				rc.freq = kv[1]
+			default:
+				return nil, ErrRRuleInvalid
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(kv[1])
+			if err != nil || n < 1 {
+				return nil, ErrRRuleInvalid
+			}
+			rc.interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(kv[1])
+			if err != nil || n < 1 {
+				return nil, ErrRRuleInvalid
+			}
+			rc.count = n
+		case "UNTIL":
+			until, err := parseRRuleTime(kv[1])
+			if err != nil {
+				return nil, ErrRRuleInvalid
+			}
+			rc.until = &until
+		case "WKST":
+			// Only matters for BYxxx rules, which we don't support.
+		default:
+			return nil, ErrRRuleInvalid
+		}
+	}
+	if rc.freq == "" || (rc.count > 0 && rc.until != nil) {
+		return nil, ErrRRuleInvalid
+	}
+	return &rc, nil
+}
+
+func parseRRuleTime(s string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("models: invalid RRULE time %q", s)
+}
+
+// nth returns the nth occurrence counting from dtstart, or false
+// if that occurrence does not exist, such as the 31st of a
+// month that is shorter and the recurrence does not clamp.
+func (rc *recurrence) nth(dtstart time.Time, n int) (time.Time, bool) {
+	step := n * rc.interval
+	y, m, d := dtstart.Date()
+	switch rc.freq {
+	case "DAILY":
+		return dtstart.AddDate(0, 0, step), true
+	case "WEEKLY":
+		return dtstart.AddDate(0, 0, 7*step), true
+	case "MONTHLY":
+		m += time.Month(step)
+	case "YEARLY":
+		y += step
+	}
+	t := time.Date(y, m, d, dtstart.Hour(), dtstart.Minute(), dtstart.Second(), 0, dtstart.Location())
+	if t.Day() == d {
+		return t, true
+	}
+	// time.Date normalised the 31st of a short month into the
+	// next one, so step back to the end of the month we wanted.
+	return t.AddDate(0, 0, -t.Day()), rc.clamp
+}
+
+// skip returns how many occurrences starting at dtstart are
+// sure to be before from, worked out without walking them. It
+// stops one interval short, so that the next occurrence is
+// never missed.
+func (rc *recurrence) skip(dtstart, from time.Time) int {
+	if !from.After(dtstart) {
+		return 0
+	}
+	y, m, _ := dtstart.Date()
+	fy, fm, _ := from.Date()
+	days := int(from.Sub(dtstart) / (24 * time.Hour))
+	var n int
+	switch rc.freq {
+	case "DAILY":
+		n = days / rc.interval
+	case "WEEKLY":
+		n = days / (7 * rc.interval)
+	case "MONTHLY":
+		n = ((fy-y)*12 + int(fm-m)) / rc.interval
+	case "YEARLY":
+		n = (fy - y) / rc.interval
+	}
+	if n < 1 {
+		return 0
+	}
+	return n - 1
+}
+
+// alwaysOccurs reports whether every occurrence exists, so the
+// ones skipped can be counted without walking them.
+func (rc *recurrence) alwaysOccurs(dtstart time.Time) bool {
+	switch rc.freq {
+	case "DAILY", "WEEKLY":
+		return true
+	}
+	return rc.clamp || dtstart.Day() <= 28
+}
+
+// occursBetween reports whether the recurrence starting at
+// dtstart has an occurrence in the range [from, to).
+func (rc *recurrence) occursBetween(dtstart, from, to time.Time) bool {
+	// Jump to just before from rather than walking there, unless
+	// there is a COUNT and some occurrences don't exist. Then
+	// only walking tells which ones count, but COUNT bounds the
+	// walk anyway.
+	start := 0
+	if rc.count == 0 || rc.alwaysOccurs(dtstart) {
+		start = rc.skip(dtstart, from)
+	}
+	seen := start
+	for n := start; n < start+maxOccurrences; n++ {
+		t, ok := rc.nth(dtstart, n)
+		if !ok {
+			continue
+		}
+		seen++
+		if rc.count > 0 && seen > rc.count {
+			return false
+		}
+		if rc.until != nil && t.After(*rc.until) {
+			return false
+		}
+		if !t.Before(to) {
+			return false
+		}
+		if !t.Before(from) {
+			return true
+		}
+	}
+	return false
+}
+
+// recurrence turns the job's repeat settings into a
+// recurrence, preferring the RRULE when there is one.
+func (j *Job) recurrence() (*recurrence, error) {
+	if j.RRule != "" {
+		return parseRRule(j.RRule)
+	}
+	count := j.IntervalCount
+	if count < 1 {
+		count = 1
+	}
+	switch j.IntervalUnit {
+	case IntervalWeek, "":
+		return &recurrence{freq: "WEEKLY", interval: count}, nil
+	case IntervalMonth:
+		return &recurrence{freq: "MONTHLY", interval: count, clamp: true}, nil
+	}
+	return nil, ErrIntervalUnitInvalid
+}
+
+// anchor is the first day the job is due on. Jobs created
+// before recurrence existed fall back to their creation date.
+func (j *Job) anchor() time.Time {
+	if j.AnchorDate != nil {
+		return j.AnchorDate.UTC()
+	}
+	return j.CreatedAt.UTC()
+}
+
+// DueIn reports whether the job needs doing in the week that
+// starts at weekStart.
+func (j *Job) DueIn(weekStart time.Time) bool {
+	rc, err := j.recurrence()
+	if err != nil {
+		return false
+	}
+	weekStart = WeekOf(weekStart)
+	dtstart := j.anchor()
+	if rc.freq == "WEEKLY" {
+		// A weekly job is due for the whole week its anchor
+		// falls in, whichever day of the week that is.
+		dtstart = WeekOf(dtstart)
+	}
+	return rc.occursBetween(dtstart, weekStart, weekStart.AddDate(0, 0, 7))
+}
+
+// Repeats describes how often the job is due, eg "Every 2
+// months".
+func (j *Job) Repeats() string {
+	if j.RRule != "" {
+		return j.RRule
+	}
+	unit := j.IntervalUnit
+	if unit == "" {
+		unit = IntervalWeek
+	}
+	if j.IntervalCount <= 1 {
+		return "Every " + unit
+	}
+	return fmt.Sprintf("Every %d %ss", j.IntervalCount, unit)
+}
@@ -0,0 +1,192 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	ErrRotaEmpty modelError = "models: there are no jobs due that week or nobody to do them"
+)
+
+// WeekOf returns the start of the week that t falls in, which
+// is always Monday at midnight UTC. Every assignment created by
+// the rota uses this as its WeekStart.
+func WeekOf(t time.Time) time.Time {
+	t = t.UTC()
+	offset := (int(t.Weekday()) + 6) % 7
+	y, m, d := t.AddDate(0, 0, -offset).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// RotaService is used to hand out a week of jobs to users.
+type RotaService interface {
+	// Generate returns the household's assignments for the week
+	// containing weekStart. If that week has not been generated
+	// yet, every job due is assigned to whichever member is
+	// furthest behind on points, skipping guests and members who
+	// are away that week, and the assignments are created first.
+	// Calling it again for the same week returns the existing
+	// assignments unchanged, so created is true only for the
+	// call that created the week.
+	Generate(householdID uint, weekStart time.Time) (assignments []Assignment, created bool, err error)
+}
+
+func NewRotaService(db *gorm.DB) RotaService {
+	return &rotaService{
+		db: db,
+	}
+}
+
+var _ RotaService = &rotaService{}
+
+type rotaService struct {
+	db *gorm.DB
+}
+
+func (rs *rotaService) Generate(householdID uint, weekStart time.Time) ([]Assignment, bool, error) {
+	weekStart = WeekOf(weekStart)
+	var assignments []Assignment
+	var created bool
+	err := rs.db.Transaction(func(tx *gorm.DB) error {
+		// Two people pressing generate at the same time must not
+		// both create a week, so we serialise on the week itself.
+		err := tx.Exec("SELECT pg_advisory_xact_lock(?, ?)",
+			householdID, weekNumber(weekStart)).Error
+		if err != nil {
+			return err
+		}
+		as := NewAssignmentService(tx)
+		existing, err := as.ByWeek(householdID, weekStart)
+		if err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			assignments = existing
+			return nil
+		}
+		jobs, err := NewJobService(tx).ByHouseholdID(householdID)
+		if err != nil {
+			return err
+		}
+		users, err := NewHouseholdService(tx).Workers(householdID)
+		if err != nil {
+			return err
+		}
+		previous, err := as.ByWeek(householdID, weekStart.AddDate(0, 0, -7))
+		if err != nil {
+			return err
+		}
+		absences, err := NewAbsenceService(tx).Overlapping(householdID, weekStart, weekStart.AddDate(0, 0, 7))
+		if err != nil {
+			return err
+		}
+		absent := make(map[uint]bool, len(absences))
+		for _, absence := range absences {
+			absent[absence.UserID] = true
+		}
+		since := weekStart.AddDate(0, 0, -7*FairnessWeeks)
+		points, err := NewLedgerService(tx).Totals(householdID, &since)
+		if err != nil {
+			return err
+		}
+		planned := planRota(weekStart, jobs, users, previous, absent, points)
+		if len(planned) == 0 {
+			return ErrRotaEmpty
+		}
+		for i := range planned {
+			if err := as.Create(&planned[i]); err != nil {
+				return err
+			}
+		}
+		created = true
+		assignments, err = as.ByWeek(householdID, weekStart)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return assignments, created, nil
+}
+
+// planRota deals the jobs that are due this week out to the
+// users who aren't absent. Jobs go out heaviest first, each to
+// whoever has the fewest points, counting the points they
+// earned recently and the effort of the jobs already dealt to
+// them this week, so whoever is furthest behind catches up.
+// Ties go to whoever didn't have the job last week and then
+// round-robin, with the first user in the rotation moving
+// along by one every week so each job changes hands.
+func planRota(weekStart time.Time, jobs []Job, users []User, previous []Assignment, absent map[uint]bool, points map[uint]int) []Assignment {
+	due := jobs[:0:0]
+	for _, job := range jobs {
+		if job.DueIn(weekStart) {
+			due = append(due, job)
+		}
+	}
+	jobs = due
+	if len(jobs) == 0 || len(users) == 0 {
+		return nil
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Effort != jobs[j].Effort {
+			return jobs[i].Effort > jobs[j].Effort
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	lastAssignee := make(map[uint]uint, len(previous))
+	for _, a := range previous {
+		lastAssignee[a.JobID] = a.UserID
+	}
+
+	offset := weekNumber(weekStart) % len(users)
+	rotation := make([]User, 0, len(users))
+	load := make(map[uint]int, len(users))
+	for i := range users {
+		user := users[(i+offset)%len(users)]
+		if absent[user.ID] {
+			continue
+		}
+		rotation = append(rotation, user)
+		load[user.ID] = points[user.ID]
+	}
+	if len(rotation) == 0 {
+		return nil
+	}
+
+	assignments := make([]Assignment, 0, len(jobs))
+	for _, job := range jobs {
+		last := lastAssignee[job.ID]
+		best := rotation[0].ID
+		for _, user := range rotation[1:] {
+			switch {
+			case load[user.ID] < load[best]:
+				best = user.ID
+			case load[user.ID] == load[best] && best == last:
+				best = user.ID
+			}
+		}
+		effort := job.Effort
+		if effort < 1 {
+			effort = 1
+		}
+		load[best] += effort
+		ws := weekStart
+		assignments = append(assignments, Assignment{
+			HouseholdID: job.HouseholdID,
+			UserID:      best,
+			JobID:       job.ID,
+			WeekStart:   &ws,
+		})
+	}
+	return assignments
+}
+
+// weekNumber counts the weeks from the Unix epoch to weekStart.
+func weekNumber(weekStart time.Time) int {
+	return int(weekStart.Unix() / int64(7*24*time.Hour/time.Second))
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 )
@@ -34,11 +36,31 @@ func WithLogMode(mode bool) ServicesConfig {
 }
 
 // WithUser will use the existing GORM DB connection of the
-// Services object along with the provided pepper and hmacKey
-// to build and set a UserService.
-func WithUser(pepper, hmacKey string) ServicesConfig {
+// Services object along with the provided pepper, hmacKey and
+// the locale of users who haven't picked one to build and set a
+// UserService.
+func WithUser(pepper, hmacKey, defaultLocale string) ServicesConfig {
 	return func(s *Services) error {
-		s.User = NewUserService(s.DB, pepper, hmacKey)
+		s.User = NewUserService(s.DB, pepper, hmacKey, defaultLocale)
+		return nil
+	}
+}
+
+// WithHousehold will use the existing GORM DB connection of
+// the Services object to build and set a HouseholdService.
+func WithHousehold() ServicesConfig {
+	return func(s *Services) error {
+		s.Household = NewHouseholdService(s.DB)
+		return nil
+	}
+}
+
+// WithInvitation will use the existing GORM DB connection of
+// the Services object along with the provided hmacKey to build
+// and set an InvitationService.
+func WithInvitation(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.Invitation = NewInvitationService(s.DB, hmacKey)
 		return nil
 	}
 }
@@ -52,6 +74,16 @@ func WithJob() ServicesConfig {
 	}
 }
 
+// WithChecklistItem will use the existing GORM DB connection
+// of the Services object to build and set a
+// ChecklistItemService.
+func WithChecklistItem() ServicesConfig {
+	return func(s *Services) error {
+		s.ChecklistItem = NewChecklistItemService(s.DB)
+		return nil
+	}
+}
+
 // WithAssignment will use the existing GORM DB connection of
 // the Services object to build and set a AssignmentService.
 func WithAssignment() ServicesConfig {
@@ -61,9 +93,145 @@ func WithAssignment() ServicesConfig {
 	}
 }
 
-func WithMate() ServicesConfig {
+// WithRota will use the existing GORM DB connection of
+// the Services object to build and set a RotaService.
+func WithRota() ServicesConfig {
+	return func(s *Services) error {
+		s.Rota = NewRotaService(s.DB)
+		return nil
+	}
+}
+
+// WithSwap will use the existing GORM DB connection of
+// the Services object to build and set a SwapService.
+func WithSwap() ServicesConfig {
+	return func(s *Services) error {
+		s.Swap = NewSwapService(s.DB)
+		return nil
+	}
+}
+
+// WithAbsence will use the existing GORM DB connection of
+// the Services object to build and set an AbsenceService.
+func WithAbsence() ServicesConfig {
+	return func(s *Services) error {
+		s.Absence = NewAbsenceService(s.DB)
+		return nil
+	}
+}
+
+// WithLedger will use the existing GORM DB connection of
+// the Services object to build and set a LedgerService.
+func WithLedger() ServicesConfig {
+	return func(s *Services) error {
+		s.Ledger = NewLedgerService(s.DB)
+		return nil
+	}
+}
+
+// WithNotification will use the existing GORM DB connection of
+// the Services object to build and set a NotificationService.
+func WithNotification() ServicesConfig {
+	return func(s *Services) error {
+		s.Notification = NewNotificationService(s.DB)
+		return nil
+	}
+}
+
+// WithOutbox will use the existing GORM DB connection of the
+// Services object to build and set an OutboxService.
+func WithOutbox() ServicesConfig {
+	return func(s *Services) error {
+		s.Outbox = NewOutboxService(s.DB)
+		return nil
+	}
+}
+
+// WithMate will use the existing GORM DB connection of the
+// Services object along with the provided hmacKey to build and
+// set a MateService.
+func WithMate(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.Mate = NewMateService(s.DB, hmacKey)
+		return nil
+	}
+}
+
+// WithCalendar will use the existing GORM DB connection of the
+// Services object along with the provided hmacKey to build and
+// set a CalendarService.
+func WithCalendar(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.Calendar = NewCalendarService(s.DB, hmacKey)
+		return nil
+	}
+}
+
+// WithAPIToken will use the existing GORM DB connection of the
+// Services object along with the provided hmacKey to build and
+// set an APITokenService.
+func WithAPIToken(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.APIToken = NewAPITokenService(s.DB, hmacKey)
+		return nil
+	}
+}
+
+// WithWebhook will use the existing GORM DB connection of the
+// Services object to build and set a WebhookService.
+func WithWebhook() ServicesConfig {
+	return func(s *Services) error {
+		s.Webhook = NewWebhookService(s.DB)
+		return nil
+	}
+}
+
+// WithChatLink will use the existing GORM DB connection of the
+// Services object along with the provided hmacKey to build and
+// set a ChatLinkService.
+func WithChatLink(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.ChatLink = NewChatLinkService(s.DB, hmacKey)
+		return nil
+	}
+}
+
+// WithSession will use the existing GORM DB connection of the
+// Services object along with the provided hmacKey to build and
+// set a SessionService.
+func WithSession(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.Session = NewSessionService(s.DB, hmacKey)
+		return nil
+	}
+}
+
+// WithTwoFactor will use the existing GORM DB connection of the
+// Services object along with the provided hmacKey to build and
+// set a TwoFactorService.
+func WithTwoFactor(hmacKey string) ServicesConfig {
 	return func(s *Services) error {
-		s.Mate = NewMateService(s.DB)
+		s.TwoFactor = NewTwoFactorService(s.DB, hmacKey)
+		return nil
+	}
+}
+
+// WithRateLimit will use the existing GORM DB connection of
+// the Services object to build and set a RateLimitService.
+func WithRateLimit() ServicesConfig {
+	return func(s *Services) error {
+		s.RateLimit = NewRateLimitService(s.DB)
+		return nil
+	}
+}
+
+// WithLoginAttempt will use the existing GORM DB connection of
+// the Services object along with how many wrong passwords lock
+// an account and for how long to build and set a
+// LoginAttemptService.
+func WithLoginAttempt(lockAfter int, lockFor time.Duration) ServicesConfig {
+	return func(s *Services) error {
+		s.LoginAttempt = NewLoginAttemptService(s.DB, lockAfter, lockFor)
 		return nil
 	}
 }
@@ -84,11 +252,28 @@ func NewServices(cfgs ...ServicesConfig) (*Services, error) {
 }
 
 type Services struct {
-	Mate       MateService
-	Assignment AssignmentService
-	Job        JobService
-	User       UserService
-	DB         *gorm.DB
+	Mate          MateService
+	Calendar      CalendarService
+	APIToken      APITokenService
+	Webhook       WebhookService
+	ChatLink      ChatLinkService
+	Session       SessionService
+	TwoFactor     TwoFactorService
+	RateLimit     RateLimitService
+	LoginAttempt  LoginAttemptService
+	Assignment    AssignmentService
+	Rota          RotaService
+	Swap          SwapService
+	Absence       AbsenceService
+	Ledger        LedgerService
+	Notification  NotificationService
+	Outbox        OutboxService
+	Job           JobService
+	ChecklistItem ChecklistItemService
+	User          UserService
+	Household     HouseholdService
+	Invitation    InvitationService
+	DB            *gorm.DB
 }
 
 // Closes the database connection
@@ -98,12 +283,47 @@ func (s *Services) Close() error {
 
 // AutoMigrate will attempt to automatically migrate all tables
 func (s *Services) AutoMigrate() error {
-	return s.DB.AutoMigrate(&User{}, &Job{}, &Assignment{}, &pwReset{}, &Mate{}).Error
+	// Users used to have a single remember token, which sessions
+	// have replaced. Its column is not null, so it has to go
+	// before any more users sign up.
+	if s.DB.Dialect().HasColumn("users", "remember_hash") {
+		if err := s.DB.Model(&User{}).DropColumn("remember_hash").Error; err != nil {
+			return err
+		}
+	}
+	// Chats used to be linked to one user each, and a group
+	// linked by a second user was taken from the first. Now
+	// users share chats, so the chat no longer has to be unique.
+	if err := s.DB.Exec("DROP INDEX IF EXISTS uix_chat_links_chat_id").Error; err != nil {
+		return err
+	}
+	// Households came after users, so a site that had users
+	// before then gets a household for all of them.
+	migrateHouseholds := s.DB.HasTable(&User{}) && !s.DB.HasTable(&Household{})
+	// Checklists used to be written out on the specs page, so
+	// they are filled in from it when their table is first made.
+	seedChecklists := !s.DB.HasTable(&ChecklistItem{})
+	err := s.DB.AutoMigrate(&User{}, &Household{}, &Membership{}, &Invitation{}, &Job{}, &ChecklistItem{}, &Assignment{}, &ItemCheck{}, &LedgerEntry{}, &Swap{}, &Absence{}, &Notification{}, &OutboxMessage{}, &pwReset{}, &Mate{}, &CalendarFeed{}, &APIToken{}, &Webhook{}, &WebhookDelivery{}, &ChatLink{}, &Session{}, &TwoFactor{}, &RecoveryCode{}, &loginChallenge{}, &loginLink{}, &RateLimit{}, &LoginAttempt{}).Error
+	if err != nil {
+		return err
+	}
+	if migrateHouseholds {
+		if err := migrateToHouseholds(s.DB); err != nil {
+			return err
+		}
+	}
+	if err := fillSignupTokens(s.DB); err != nil {
+		return err
+	}
+	if seedChecklists {
+		return seedChecklistItems(s.DB)
+	}
+	return nil
 }
 
 // DestructiveReset drops all tables and rebuilds them
 func (s *Services) DestructiveReset() error {
-	err := s.DB.DropTableIfExists(&User{}, &Job{}, &Assignment{}, &pwReset{}).Error
+	err := s.DB.DropTableIfExists(&User{}, &Household{}, &Membership{}, &Invitation{}, &Job{}, &ChecklistItem{}, &Assignment{}, &ItemCheck{}, &LedgerEntry{}, &Swap{}, &Absence{}, &Notification{}, &OutboxMessage{}, &pwReset{}, &CalendarFeed{}, &APIToken{}, &Webhook{}, &WebhookDelivery{}, &ChatLink{}, &Session{}, &TwoFactor{}, &RecoveryCode{}, &loginChallenge{}, &loginLink{}, &RateLimit{}, &LoginAttempt{}).Error
 	if err != nil {
 		return err
 	}
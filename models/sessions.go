@@ -0,0 +1,259 @@
+package models
+
+import (
+	"time"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/sirodoht/heartfort/hash"
+	"github.com/sirodoht/heartfort/rand"
+)
+
+// SessionLifetime is how long a device stays logged in.
+const SessionLifetime = 30 * 24 * time.Hour
+
+// sessionTouchEvery is how stale LastSeenAt can get before a
+// request updates it, so browsing doesn't write to the database
+// on every page.
+const sessionTouchEvery = time.Minute
+
+// maxUserAgent is as much of a user agent as is kept, which is
+// plenty to tell devices apart.
+const maxUserAgent = 255
+
+// Session represents the sessions table in our DB and is one
+// device a user is logged in on. Only the hash of the token is
+// kept, and the token itself only ever goes in the device's
+// cookie.
+type Session struct {
+	ID         uint   `gorm:"primary_key"`
+	UserID     uint   `gorm:"not null;index"`
+	Token      string `gorm:"-"`
+	TokenHash  string `gorm:"not null;unique_index"`
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time `gorm:"not null;index"`
+}
+
+// Expired reports whether the device has to log in again.
+func (s *Session) Expired() bool {
+	return !s.ExpiresAt.After(time.Now())
+}
+
+func NewSessionService(db *gorm.DB, hmacKey string) SessionService {
+	return &sessionService{
+		SessionDB: &sessionValidator{
+			SessionDB: &sessionGorm{db},
+			hmac:      hash.NewHMAC(hmacKey),
+		},
+	}
+}
+
+type SessionService interface {
+	// Authenticate looks up the session in a device's cookie and
+	// records that it was seen. ErrTokenInvalid is returned if
+	// there is no such session or it has expired.
+	Authenticate(token string) (*Session, error)
+	SessionDB
+}
+
+var _ SessionService = &sessionService{}
+
+type sessionService struct {
+	SessionDB
+}
+
+func (ss *sessionService) Authenticate(token string) (*Session, error) {
+	session, err := ss.ByToken(token)
+	if err == ErrNotFound {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.Expired() {
+		return nil, ErrTokenInvalid
+	}
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) > sessionTouchEvery {
+		if err := ss.Touch(session.ID, now); err != nil {
+			return nil, err
+		}
+		session.LastSeenAt = now
+	}
+	return session, nil
+}
+
+// SessionDB is used to interact with the sessions database.
+type SessionDB interface {
+	ByID(id uint) (*Session, error)
+	ByToken(token string) (*Session, error)
+	// ByUserID returns the sessions of the user that haven't
+	// expired, the most recently seen first.
+	ByUserID(userID uint) ([]Session, error)
+	// Create starts a session that lasts SessionLifetime. The
+	// returned session is the only place the token can be read
+	// from.
+	Create(session *Session) error
+	Touch(id uint, at time.Time) error
+	Delete(id uint) error
+	// DeleteByUserID logs the user out on every device.
+	DeleteByUserID(userID uint) error
+	// DeleteExpired forgets the sessions that expired before.
+	DeleteExpired(before time.Time) error
+}
+
+type sessionValidator struct {
+	SessionDB
+	hmac hash.HMAC
+}
+
+func (sv *sessionValidator) ByToken(token string) (*Session, error) {
+	session := Session{Token: token}
+	if err := runSessionValFns(&session, sv.hmacToken); err != nil {
+		return nil, err
+	}
+	if session.TokenHash == "" {
+		return nil, ErrNotFound
+	}
+	return sv.SessionDB.ByToken(session.TokenHash)
+}
+
+func (sv *sessionValidator) Create(session *Session) error {
+	err := runSessionValFns(session,
+		sv.requireUserID,
+		sv.setTokenIfUnset,
+		sv.hmacToken,
+		sv.setTimes,
+		sv.truncateUserAgent,
+	)
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.Create(session)
+}
+
+func (sv *sessionValidator) DeleteByUserID(userID uint) error {
+	if userID <= 0 {
+		return ErrUserIDRequired
+	}
+	return sv.SessionDB.DeleteByUserID(userID)
+}
+
+func (sv *sessionValidator) requireUserID(session *Session) error {
+	if session.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (sv *sessionValidator) setTokenIfUnset(session *Session) error {
+	if session.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	session.Token = token
+	return nil
+}
+
+func (sv *sessionValidator) hmacToken(session *Session) error {
+	if session.Token == "" {
+		return nil
+	}
+	session.TokenHash = sv.hmac.Hash(session.Token)
+	return nil
+}
+
+func (sv *sessionValidator) setTimes(session *Session) error {
+	now := time.Now()
+	session.LastSeenAt = now
+	session.ExpiresAt = now.Add(SessionLifetime)
+	return nil
+}
+
+func (sv *sessionValidator) truncateUserAgent(session *Session) error {
+	ua := session.UserAgent
+	if len(ua) <= maxUserAgent {
+		return nil
+	}
+	ua = ua[:maxUserAgent]
+	for !utf8.ValidString(ua) {
+		ua = ua[:len(ua)-1]
+	}
+	session.UserAgent = ua
+	return nil
+}
+
+type sessionValFn func(*Session) error
+
+func runSessionValFns(session *Session, fns ...sessionValFn) error {
+	for _, fn := range fns {
+		if err := fn(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ SessionDB = &sessionGorm{}
+
+type sessionGorm struct {
+	db *gorm.DB
+}
+
+func (sg *sessionGorm) ByID(id uint) (*Session, error) {
+	var session Session
+	err := first(sg.db.Where("id = ?", id), &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (sg *sessionGorm) ByToken(tokenHash string) (*Session, error) {
+	var session Session
+	err := first(sg.db.Where("token_hash = ?", tokenHash), &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (sg *sessionGorm) ByUserID(userID uint) ([]Session, error) {
+	var sessions []Session
+	err := sg.db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (sg *sessionGorm) Create(session *Session) error {
+	return sg.db.Create(session).Error
+}
+
+func (sg *sessionGorm) Touch(id uint, at time.Time) error {
+	return sg.db.Model(&Session{}).
+		Where("id = ?", id).
+		UpdateColumn("last_seen_at", at).Error
+}
+
+func (sg *sessionGorm) Delete(id uint) error {
+	return sg.db.Where("id = ?", id).Delete(&Session{}).Error
+}
+
+func (sg *sessionGorm) DeleteByUserID(userID uint) error {
+	return sg.db.Where("user_id = ?", userID).Delete(&Session{}).Error
+}
+
+func (sg *sessionGorm) DeleteExpired(before time.Time) error {
+	return sg.db.Where("expires_at < ?", before).Delete(&Session{}).Error
+}
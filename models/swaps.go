@@ -0,0 +1,295 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	ErrSwapNotOwner modelError = "models: you can only swap your own assignments"
+
+	ErrSwapWithSelf modelError = "models: you can't swap with yourself"
+
+	ErrSwapFinished modelError = "models: finished assignments can't be swapped"
+
+	ErrSwapPending modelError = "models: there is already a pending swap for this assignment"
+
+	ErrSwapAnswered modelError = "models: swap has already been answered"
+
+	ErrSwapNotYours modelError = "models: swap isn't yours to answer"
+
+	ErrSwapStale modelError = "models: assignments have changed since the swap was asked for"
+)
+
+// The statuses a swap goes through. Every swap starts out
+// pending until it is accepted, declined or cancelled.
+const (
+	SwapPending   = "pending"
+	SwapAccepted  = "accepted"
+	SwapDeclined  = "declined"
+	SwapCancelled = "cancelled"
+)
+
+// Swap represents the swaps table in our DB and is someone
+// asking to hand their assignment over. With WithAssignmentID
+// set it is a trade for another user's assignment, who is then
+// the only one that can answer. Without it anyone in the
+// household can take the assignment on.
+type Swap struct {
+	gorm.Model
+	HouseholdID      uint        `gorm:"index"`
+	AssignmentID     uint        `gorm:"not null;index"`
+	Assignment       Assignment  `gorm:"save_associations:false"`
+	WithAssignmentID *uint       `gorm:"index"`
+	WithAssignment   *Assignment `gorm:"save_associations:false"`
+	RequestedByID    uint        `gorm:"not null"`
+	RequestedBy      User        `gorm:"save_associations:false"`
+	ToUserID         *uint
+	ToUser           *User  `gorm:"save_associations:false"`
+	Status           string `gorm:"not null;default:'pending'"`
+	AnsweredByID     *uint
+	AnsweredBy       *User `gorm:"save_associations:false"`
+	AnsweredAt       *time.Time
+}
+
+// Open reports whether anyone in the household can take the
+// assignment, rather than one person being asked to trade.
+func (s *Swap) Open() bool {
+	return s.ToUserID == nil
+}
+
+// CanAnswer reports whether the user with userID can accept
+// the swap.
+func (s *Swap) CanAnswer(userID uint) bool {
+	if s.Open() {
+		return userID != s.RequestedByID
+	}
+	return *s.ToUserID == userID
+}
+
+// Swappable reports whether the assignment hasn't been
+// finished yet and can still change hands.
+func (a *Assignment) Swappable() bool {
+	return a.Status == StatusPending || a.Status == StatusInProgress
+}
+
+func NewSwapService(db *gorm.DB) SwapService {
+	return &swapService{
+		SwapDB: &swapGorm{db},
+		db:     db,
+	}
+}
+
+type SwapService interface {
+	// Propose checks that the assignments of the swap can be
+	// swapped and creates it. The assignment must belong to
+	// whoever asks and, when it's a trade, WithAssignmentID must
+	// be someone else's assignment in the same household.
+	Propose(swap *Swap) error
+	// Accept hands the assignments over in one transaction. For
+	// a trade the two assignments switch users, otherwise the
+	// user with userID takes the assignment on.
+	Accept(swap *Swap, userID uint) error
+	// Decline turns down a trade. Only the person asked can
+	// decline it.
+	Decline(swap *Swap, userID uint) error
+	// Cancel withdraws the swap. Only whoever asked can cancel
+	// it.
+	Cancel(swap *Swap, userID uint) error
+	SwapDB
+}
+
+var _ SwapService = &swapService{}
+
+type swapService struct {
+	SwapDB
+	db *gorm.DB
+}
+
+func (ss *swapService) Propose(swap *Swap) error {
+	as := NewAssignmentService(ss.db)
+	assignment, err := as.ByID(swap.AssignmentID)
+	if err != nil {
+		return err
+	}
+	if assignment.UserID != swap.RequestedByID {
+		return ErrSwapNotOwner
+	}
+	if !assignment.Swappable() {
+		return ErrSwapFinished
+	}
+	swap.HouseholdID = assignment.HouseholdID
+	swap.ToUserID = nil
+	if swap.WithAssignmentID != nil {
+		with, err := as.ByID(*swap.WithAssignmentID)
+		if err == nil && with.HouseholdID != assignment.HouseholdID {
+			err = ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if with.UserID == swap.RequestedByID {
+			return ErrSwapWithSelf
+		}
+		if !with.Swappable() {
+			return ErrSwapFinished
+		}
+		swap.ToUserID = &with.UserID
+	}
+	var count int
+	err = ss.db.Model(&Swap{}).
+		Where("assignment_id = ? AND status = ?", swap.AssignmentID, SwapPending).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrSwapPending
+	}
+	swap.Status = SwapPending
+	return ss.Create(swap)
+}
+
+func (ss *swapService) Accept(swap *Swap, userID uint) error {
+	return ss.answer(swap, userID, SwapAccepted, func(tx *gorm.DB, current *Swap) error {
+		if !current.CanAnswer(userID) {
+			return ErrSwapNotYours
+		}
+		assignment, err := lockAssignment(tx, current.AssignmentID)
+		if err != nil {
+			return err
+		}
+		if assignment.UserID != current.RequestedByID || !assignment.Swappable() {
+			return ErrSwapStale
+		}
+		if current.WithAssignmentID != nil {
+			with, err := lockAssignment(tx, *current.WithAssignmentID)
+			if err != nil {
+				return err
+			}
+			if with.UserID != *current.ToUserID || !with.Swappable() {
+				return ErrSwapStale
+			}
+			err = tx.Model(with).Update("user_id", current.RequestedByID).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(assignment).Update("user_id", userID).Error
+	})
+}
+
+func (ss *swapService) Decline(swap *Swap, userID uint) error {
+	return ss.answer(swap, userID, SwapDeclined, func(tx *gorm.DB, current *Swap) error {
+		if current.Open() || !current.CanAnswer(userID) {
+			return ErrSwapNotYours
+		}
+		return nil
+	})
+}
+
+func (ss *swapService) Cancel(swap *Swap, userID uint) error {
+	return ss.answer(swap, userID, SwapCancelled, func(tx *gorm.DB, current *Swap) error {
+		if current.RequestedByID != userID {
+			return ErrSwapNotYours
+		}
+		return nil
+	})
+}
+
+// answer moves a pending swap to status in a transaction,
+// running fn first with the swap locked so two people can't
+// answer it at the same time.
+func (ss *swapService) answer(swap *Swap, userID uint, status string, fn func(tx *gorm.DB, current *Swap) error) error {
+	return ss.db.Transaction(func(tx *gorm.DB) error {
+		var current Swap
+		db := tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", swap.ID)
+		if err := first(db, &current); err != nil {
+			return err
+		}
+		if current.Status != SwapPending {
+			return ErrSwapAnswered
+		}
+		if err := fn(tx, &current); err != nil {
+			return err
+		}
+		now := time.Now()
+		swap.Status = status
+		swap.AnsweredByID = &userID
+		swap.AnsweredAt = &now
+		return tx.Model(&current).Updates(map[string]interface{}{
+			"status":         swap.Status,
+			"answered_by_id": swap.AnsweredByID,
+			"answered_at":    swap.AnsweredAt,
+		}).Error
+	})
+}
+
+func lockAssignment(tx *gorm.DB, id uint) (*Assignment, error) {
+	var assignment Assignment
+	db := tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", id)
+	if err := first(db, &assignment); err != nil {
+		return nil, err
+	}
+	return &assignment, nil
+}
+
+// SwapDB is used to interact with the swaps database.
+type SwapDB interface {
+	ByID(id uint) (*Swap, error)
+	// Pending returns the swaps of a household still waiting for
+	// an answer, oldest first.
+	Pending(householdID uint) ([]Swap, error)
+	Create(swap *Swap) error
+	Update(swap *Swap) error
+}
+
+var _ SwapDB = &swapGorm{}
+
+type swapGorm struct {
+	db *gorm.DB
+}
+
+func (sg *swapGorm) ByID(id uint) (*Swap, error) {
+	var swap Swap
+	err := first(sg.preload().Where("id = ?", id), &swap)
+	if err != nil {
+		return nil, err
+	}
+	return &swap, nil
+}
+
+func (sg *swapGorm) Pending(householdID uint) ([]Swap, error) {
+	var swaps []Swap
+	db := sg.preload().
+		Where("household_id = ? AND status = ?", householdID, SwapPending).
+		Order("id")
+	if err := db.Find(&swaps).Error; err != nil {
+		return nil, err
+	}
+	return swaps, nil
+}
+
+func (sg *swapGorm) Create(swap *Swap) error {
+	return sg.db.Create(swap).Error
+}
+
+func (sg *swapGorm) Update(swap *Swap) error {
+	return sg.db.Save(swap).Error
+}
+
+// preload makes sure both assignments come back with their
+// jobs, along with everyone involved.
+func (sg *swapGorm) preload() *gorm.DB {
+	return sg.db.
+		Preload("Assignment").
+		Preload("Assignment.Job").
+		Preload("Assignment.User").
+		Preload("WithAssignment").
+		Preload("WithAssignment.Job").
+		Preload("WithAssignment.User").
+		Preload("RequestedBy").
+		Preload("ToUser").
+		Preload("AnsweredBy")
+}
@@ -0,0 +1,412 @@
+package models
+
+import (
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/sirodoht/heartfort/hash"
+	"github.com/sirodoht/heartfort/rand"
+	"github.com/sirodoht/heartfort/totp"
+)
+
+const (
+	ErrCodeInvalid modelError = "models: code provided is not valid"
+
+	ErrTwoFactorEnabled modelError = "models: two-factor authentication is already on"
+
+	ErrTwoFactorDisabled modelError = "models: two-factor authentication is not on"
+)
+
+// TwoFactorIssuer is the name authenticator apps show the codes
+// under.
+const TwoFactorIssuer = "Heartfort"
+
+// recoveryCodeCount is how many recovery codes a user gets at a
+// time, and recoveryCodeBytes how random each of them is.
+const (
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 5
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactor represents the two_factors table in our DB and is a
+// user's authenticator app. It starts out with just a secret for
+// the app, and once the app has shown it got the secret right by
+// giving a code it is enabled, after which the user needs a code
+// to log in. The secret has to be kept as it is to check codes,
+// so unlike tokens it isn't hashed. LastStep is the step of the
+// last code used, so no code works twice.
+type TwoFactor struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"not null;unique_index"`
+	Secret    string `gorm:"not null"`
+	LastStep  int64  `gorm:"not null;default:0"`
+	EnabledAt *time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Enabled reports whether the user needs a code to log in.
+func (tf *TwoFactor) Enabled() bool {
+	return tf.EnabledAt != nil
+}
+
+// URI is the link to set an authenticator app up with, which is
+// what goes in the QR code.
+func (tf *TwoFactor) URI(account string) string {
+	return totp.URI(TwoFactorIssuer, account, tf.Secret)
+}
+
+// RecoveryCode represents the recovery_codes table in our DB
+// and is a code the user can log in with once instead of one
+// from their app, for when they lose it. Only its hash is kept.
+type RecoveryCode struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"not null;index"`
+	CodeHash  string `gorm:"not null;unique_index"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func NewTwoFactorService(db *gorm.DB, hmacKey string) TwoFactorService {
+	hmac := hash.NewHMAC(hmacKey)
+	return &twoFactorService{
+		TwoFactorDB: &twoFactorValidator{
+			TwoFactorDB: &twoFactorGorm{db},
+		},
+		db:               db,
+		hmac:             hmac,
+		loginChallengeDB: newLoginChallengeValidator(&loginChallengeGorm{db}, hmac),
+	}
+}
+
+type TwoFactorService interface {
+	// Enabled reports whether the user needs a code to log in.
+	Enabled(userID uint) (bool, error)
+	// Allowed reports whether the user may use the household,
+	// which they may not if it requires two-factor
+	// authentication and they haven't turned it on.
+	Allowed(household *Household, userID uint) (bool, error)
+	// Begin gives the user a new secret to set their app up
+	// with, replacing any they didn't finish setting up. If two
+	// factor authentication is already on ErrTwoFactorEnabled is
+	// returned.
+	Begin(userID uint) (*TwoFactor, error)
+	// Enable turns two factor authentication on once the code
+	// from the user's app is right, and returns their recovery
+	// codes, which can't be read again. ErrCodeInvalid is
+	// returned if the code is wrong.
+	Enable(userID uint, code string) ([]string, error)
+	// Verify checks a code from the user's app or one of their
+	// recovery codes, which is used up. ErrCodeInvalid is
+	// returned if it is wrong or has been used already.
+	Verify(userID uint, code string) error
+	// NewRecoveryCodes replaces the user's recovery codes and
+	// returns the new ones, which can't be read again.
+	NewRecoveryCodes(userID uint) ([]string, error)
+	// RecoveryCodesLeft is how many recovery codes the user
+	// hasn't used yet.
+	RecoveryCodesLeft(userID uint) (int, error)
+	// Disable turns two factor authentication off and forgets
+	// the user's secret and recovery codes.
+	Disable(userID uint) error
+	// StartLogin is called once the user's password has been
+	// accepted and returns the token the login goes on with.
+	StartLogin(userID uint) (string, error)
+	// FinishLogin checks the code for the login with the token
+	// and returns who is logging in. ErrCodeInvalid is returned
+	// if the code is wrong, and ErrTokenInvalid if the login has
+	// expired or had too many wrong codes and has to start over.
+	FinishLogin(token, code string) (uint, error)
+	TwoFactorDB
+}
+
+var _ TwoFactorService = &twoFactorService{}
+
+type twoFactorService struct {
+	TwoFactorDB
+	db               *gorm.DB
+	hmac             hash.HMAC
+	loginChallengeDB loginChallengeDB
+}
+
+func (tfs *twoFactorService) Enabled(userID uint) (bool, error) {
+	tf, err := tfs.ByUserID(userID)
+	switch err {
+	case nil:
+		return tf.Enabled(), nil
+	case ErrNotFound:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (tfs *twoFactorService) Allowed(household *Household, userID uint) (bool, error) {
+	if !household.RequireTwoFactor {
+		return true, nil
+	}
+	return tfs.Enabled(userID)
+}
+
+func (tfs *twoFactorService) Begin(userID uint) (*TwoFactor, error) {
+	if userID <= 0 {
+		return nil, ErrUserIDRequired
+	}
+	tf, err := tfs.ByUserID(userID)
+	switch err {
+	case nil:
+		if tf.Enabled() {
+			return nil, ErrTwoFactorEnabled
+		}
+	case ErrNotFound:
+		tf = &TwoFactor{UserID: userID}
+	default:
+		return nil, err
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	tf.Secret = secret
+	if err := tfs.db.Save(tf).Error; err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+func (tfs *twoFactorService) Enable(userID uint, code string) ([]string, error) {
+	tf, err := tfs.ByUserID(userID)
+	switch err {
+	case nil:
+		if tf.Enabled() {
+			return nil, ErrTwoFactorEnabled
+		}
+	case ErrNotFound:
+		return nil, ErrTwoFactorDisabled
+	default:
+		return nil, err
+	}
+	step, ok := totp.Validate(tf.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrCodeInvalid
+	}
+	codes, hashes, err := tfs.recoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = tfs.db.Transaction(func(tx *gorm.DB) error {
+		// Only the first of two tries at once gets to enable it.
+		db := tx.Model(&TwoFactor{}).
+			Where("id = ? AND enabled_at IS NULL", tf.ID).
+			Updates(map[string]interface{}{
+				"enabled_at": time.Now(),
+				"last_step":  step,
+			})
+		if db.Error != nil {
+			return db.Error
+		}
+		if db.RowsAffected == 0 {
+			return ErrTwoFactorEnabled
+		}
+		return replaceRecoveryCodes(tx, userID, hashes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (tfs *twoFactorService) Verify(userID uint, code string) error {
+	tf, err := tfs.ByUserID(userID)
+	switch err {
+	case nil:
+		if !tf.Enabled() {
+			return ErrTwoFactorDisabled
+		}
+	case ErrNotFound:
+		return ErrTwoFactorDisabled
+	default:
+		return err
+	}
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		step, ok := totp.Validate(tf.Secret, code, time.Now())
+		if !ok {
+			return ErrCodeInvalid
+		}
+		// Moving LastStep on in the update means a code that is
+		// seen twice, even at the same time, only works once.
+		db := tfs.db.Model(&TwoFactor{}).
+			Where("id = ? AND last_step < ?", tf.ID, step).
+			UpdateColumn("last_step", step)
+		if db.Error != nil {
+			return db.Error
+		}
+		if db.RowsAffected == 0 {
+			return ErrCodeInvalid
+		}
+		return nil
+	}
+	db := tfs.db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, tfs.hashRecoveryCode(code)).
+		UpdateColumn("used_at", time.Now())
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrCodeInvalid
+	}
+	return nil
+}
+
+func (tfs *twoFactorService) NewRecoveryCodes(userID uint) ([]string, error) {
+	enabled, err := tfs.Enabled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrTwoFactorDisabled
+	}
+	codes, hashes, err := tfs.recoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = tfs.db.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, hashes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (tfs *twoFactorService) RecoveryCodesLeft(userID uint) (int, error) {
+	var n int
+	err := tfs.db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&n).Error
+	return n, err
+}
+
+func (tfs *twoFactorService) Disable(userID uint) error {
+	if userID <= 0 {
+		return ErrUserIDRequired
+	}
+	return tfs.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&TwoFactor{}).Error
+	})
+}
+
+func (tfs *twoFactorService) StartLogin(userID uint) (string, error) {
+	lc := loginChallenge{UserID: userID}
+	if err := tfs.loginChallengeDB.Create(&lc); err != nil {
+		return "", err
+	}
+	return lc.Token, nil
+}
+
+func (tfs *twoFactorService) FinishLogin(token, code string) (uint, error) {
+	lc, err := tfs.loginChallengeDB.ByToken(token)
+	switch err {
+	case nil:
+	case ErrNotFound:
+		return 0, ErrTokenInvalid
+	default:
+		return 0, err
+	}
+	if !lc.ExpiresAt.After(time.Now()) {
+		tfs.loginChallengeDB.Delete(lc.ID)
+		return 0, ErrTokenInvalid
+	}
+	if err := tfs.loginChallengeDB.Attempt(lc.ID); err != nil {
+		if err == ErrTokenInvalid {
+			tfs.loginChallengeDB.Delete(lc.ID)
+		}
+		return 0, err
+	}
+	if err := tfs.Verify(lc.UserID, code); err != nil {
+		return 0, err
+	}
+	if err := tfs.loginChallengeDB.Delete(lc.ID); err != nil {
+		return 0, err
+	}
+	return lc.UserID, nil
+}
+
+// recoveryCodes makes a new set of recovery codes, like
+// "abcd-efgh", along with their hashes.
+func (tfs *twoFactorService) recoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b, err := rand.Bytes(recoveryCodeBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, tfs.hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes the code however it was typed in.
+func (tfs *twoFactorService) hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.Replace(code, "-", "", -1)
+	code = strings.Replace(code, " ", "", -1)
+	return tfs.hmac.Hash(code)
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint, hashes []string) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		rc := RecoveryCode{
+			UserID:   userID,
+			CodeHash: h,
+		}
+		if err := tx.Create(&rc).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TwoFactorDB is used to interact with the two_factors database.
+type TwoFactorDB interface {
+	ByUserID(userID uint) (*TwoFactor, error)
+}
+
+type twoFactorValidator struct {
+	TwoFactorDB
+}
+
+func (tfv *twoFactorValidator) ByUserID(userID uint) (*TwoFactor, error) {
+	if userID <= 0 {
+		return nil, ErrNotFound
+	}
+	return tfv.TwoFactorDB.ByUserID(userID)
+}
+
+var _ TwoFactorDB = &twoFactorGorm{}
+
+type twoFactorGorm struct {
+	db *gorm.DB
+}
+
+func (tfg *twoFactorGorm) ByUserID(userID uint) (*TwoFactor, error) {
+	var tf TwoFactor
+	err := first(tfg.db.Where("user_id = ?", userID), &tf)
+	if err != nil {
+		return nil, err
+	}
+	return &tf, nil
+}
@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/sirodoht/heartfort/hash"
-	"github.com/sirodoht/heartfort/rand"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
@@ -30,11 +29,13 @@ const (
 
 	ErrEmailTaken modelError = "models: email address is already taken"
 
-	ErrRememberRequired modelError = "models: remember token is required"
+	ErrTokenInvalid modelError = "models: token provided is not valid"
 
-	ErrRememberTooShort modelError = "models: remember token must be at least 32 bytes"
+	ErrLocaleInvalid modelError = "models: language is not supported"
 
-	ErrTokenInvalid modelError = "models: token provided is not valid"
+	ErrPasswordLoginOff modelError = "models: this account only logs in with emailed links"
+
+	ErrAccountLocked modelError = "models: this account is locked for a while after too many wrong passwords, check your email for a link to log in with"
 )
 
 // UserDB is used to interact with the users database.
@@ -42,7 +43,6 @@ type UserDB interface {
 	// Methods for querying for single users
 	ByID(id uint) (*User, error)
 	ByEmail(email string) (*User, error)
-	ByRemember(token string) (*User, error)
 
 	// Methods for altering users
 	Create(user *User) error
@@ -56,8 +56,20 @@ type User struct {
 	Email        string `gorm:"not null;unique_index"`
 	Password     string `gorm:"-"`
 	PasswordHash string `gorm:"not null"`
-	Remember     string `gorm:"-"`
-	RememberHash string `gorm:"not null;unique_index"`
+	// Locale is the language the user's emails are written in.
+	Locale string `gorm:"not null;default:'en'"`
+	// LinkLoginOnly turns password login off, so the user can
+	// only log in with links emailed to them.
+	LinkLoginOnly bool `gorm:"not null;default:false"`
+	// LockedUntil is when the user can log in with their
+	// password again, after too many wrong ones were tried.
+	LockedUntil *time.Time
+}
+
+// Locked reports whether the user's password can't be used to
+// log in right now.
+func (u *User) Locked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(time.Now())
 }
 
 // UserService is a set of methods used to manipulate and
@@ -75,17 +87,28 @@ type UserService interface {
 	// If the token has expired, or if it is invalid for any
 	// other reason the ErrTokenInvalid error will be returned.
 	CompleteReset(token, newPw string) (*User, error)
+	// InitiateLoginLink starts logging in the user with the
+	// provided email address without their password, and
+	// returns the token for the link to email them. If too many
+	// links have been asked for lately ErrTooManyLoginLinks is
+	// returned.
+	InitiateLoginLink(email string) (string, error)
+	// CompleteLoginLink uses up the token from a login link and
+	// returns the user it logs in. If the token has expired or
+	// been used already ErrTokenInvalid is returned.
+	CompleteLoginLink(token string) (*User, error)
 	UserDB
 }
 
-func NewUserService(db *gorm.DB, pepper, hmacKey string) UserService {
+func NewUserService(db *gorm.DB, pepper, hmacKey, defaultLocale string) UserService {
 	ug := &userGorm{db}
 	hmac := hash.NewHMAC(hmacKey)
-	uv := newUserValidator(ug, hmac, pepper)
+	uv := newUserValidator(ug, pepper, defaultLocale)
 	return &userService{
-		UserDB:    uv,
-		pepper:    pepper,
-		pwResetDB: newPwResetValidator(&pwResetGorm{db}, hmac),
+		UserDB:      uv,
+		pepper:      pepper,
+		pwResetDB:   newPwResetValidator(&pwResetGorm{db}, hmac),
+		loginLinkDB: newLoginLinkValidator(&loginLinkGorm{db}, hmac),
 	}
 }
 
@@ -93,14 +116,19 @@ var _ UserService = &userService{}
 
 type userService struct {
 	UserDB
-	pepper    string
-	pwResetDB pwResetDB
+	pepper      string
+	pwResetDB   pwResetDB
+	loginLinkDB loginLinkDB
 }
 
 // Authenticate can be used to authenticate a user with the
 // provided email address and password.
 // If the email address provided is invalid, this will return
 //   nil, ErrNotFound
+// If the user only logs in with emailed links, this will return
+//   nil, ErrPasswordLoginOff
+// If the user's account is locked, this will return
+//   nil, ErrAccountLocked
 // If the password provided is invalid, this will return
 //   nil, ErrPasswordIncorrect
 // If the email and password are both valid, this will return
@@ -112,6 +140,14 @@ func (us *userService) Authenticate(email, password string) (*User, error) {
 	if err != nil {
 		return nil, err
 	}
+	if foundUser.LinkLoginOnly {
+		return nil, ErrPasswordLoginOff
+	}
+	// A locked account doesn't even get its password checked,
+	// so guessing it is no use until the lock is over.
+	if foundUser.Locked() {
+		return nil, ErrAccountLocked
+	}
 	err = bcrypt.CompareHashAndPassword(
 		[]byte(foundUser.PasswordHash),
 		[]byte(password+us.pepper))
@@ -156,6 +192,7 @@ func (us *userService) CompleteReset(token,
 		return nil, err
 	}
 	user.Password = newPw
+	user.LockedUntil = nil
 	err = us.Update(user)
 	if err != nil {
 		return nil, err
@@ -164,6 +201,56 @@ func (us *userService) CompleteReset(token,
 	return user, nil
 }
 
+func (us *userService) InitiateLoginLink(email string) (string, error) {
+	user, err := us.ByEmail(email)
+	if err != nil {
+		return "", err
+	}
+	n, err := us.loginLinkDB.CountSince(user.ID, time.Now().Add(-loginLinkWindow))
+	if err != nil {
+		return "", err
+	}
+	if n >= loginLinkMax {
+		return "", ErrTooManyLoginLinks
+	}
+	ll := loginLink{
+		UserID: user.ID,
+	}
+	if err := us.loginLinkDB.Create(&ll); err != nil {
+		return "", err
+	}
+	return ll.Token, nil
+}
+
+func (us *userService) CompleteLoginLink(token string) (*User, error) {
+	ll, err := us.loginLinkDB.ByToken(token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if time.Now().Sub(ll.CreatedAt) > loginLinkLifetime {
+		return nil, ErrTokenInvalid
+	}
+	if err := us.loginLinkDB.Use(ll.ID); err != nil {
+		return nil, err
+	}
+	user, err := us.ByID(ll.UserID)
+	if err != nil {
+		return nil, err
+	}
+	// Getting into their email is as good as knowing their
+	// password, so the link unlocks the user's account too.
+	if user.LockedUntil != nil {
+		user.LockedUntil = nil
+		if err := us.Update(user); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
 var _ UserDB = &userGorm{}
 
 // userGorm represents our database interaction layer
@@ -192,18 +279,6 @@ func (ug *userGorm) ByEmail(email string) (*User, error) {
 	return &user, err
 }
 
-// ByRemember looks up a user with the given remember token
-// and returns that user. This method expects the remember
-// token to already be hashed.
-func (ug *userGorm) ByRemember(rememberHash string) (*User, error) {
-	var user User
-	err := first(ug.db.Where("remember_hash = ?", rememberHash), &user)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
-}
-
 // Create will create the provided user and backfill data
 // like the ID, CreatedAt, and UpdatedAt fields.
 func (ug *userGorm) Create(user *User) error {
@@ -233,13 +308,16 @@ func first(db *gorm.DB, dst interface{}) error {
 	return err
 }
 
-func newUserValidator(udb UserDB, hmac hash.HMAC, pepper string) *userValidator {
+// emailPattern is what every email address we store must look
+// like once it has been normalized.
+const emailPattern = `^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,16}$`
+
+func newUserValidator(udb UserDB, pepper, defaultLocale string) *userValidator {
 	return &userValidator{
-		UserDB: udb,
-		hmac:   hmac,
-		pepper: pepper,
-		emailRegex: regexp.MustCompile(
-			`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,16}$`),
+		UserDB:        udb,
+		pepper:        pepper,
+		defaultLocale: defaultLocale,
+		emailRegex:    regexp.MustCompile(emailPattern),
 	}
 }
 
@@ -248,9 +326,9 @@ func newUserValidator(udb UserDB, hmac hash.HMAC, pepper string) *userValidator
 // UserDB in our interface chain.
 type userValidator struct {
 	UserDB
-	hmac       hash.HMAC
-	emailRegex *regexp.Regexp
-	pepper     string
+	emailRegex    *regexp.Regexp
+	pepper        string
+	defaultLocale string
 }
 
 // ByEmail will normalize an email address before passing
@@ -266,16 +344,6 @@ func (uv *userValidator) ByEmail(email string) (*User, error) {
 	return uv.UserDB.ByEmail(user.Email)
 }
 
-func (uv *userValidator) ByRemember(token string) (*User, error) {
-	user := User{
-		Remember: token,
-	}
-	if err := runUserValFns(&user, uv.hmacRemember); err != nil {
-		return nil, err
-	}
-	return uv.UserDB.ByRemember(user.RememberHash)
-}
-
 // Create will create the provided user and backfill data
 // like the ID, CreatedAt, and UpdatedAt fields.
 func (uv *userValidator) Create(user *User) error {
@@ -284,14 +352,11 @@ func (uv *userValidator) Create(user *User) error {
 		uv.passwordMinLength,
 		uv.bcryptPassword,
 		uv.passwordHashRequired,
-		uv.setRememberIfUnset,
-		uv.rememberMinBytes,
-		uv.hmacRemember,
-		uv.rememberHashRequired,
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
 		uv.emailIsAvail,
+		uv.normalizeLocale,
 	)
 	if err != nil {
 		return err
@@ -299,19 +364,17 @@ func (uv *userValidator) Create(user *User) error {
 	return uv.UserDB.Create(user)
 }
 
-// Update will hash a remember token if it is provided.
+// Update will hash a new password if it is provided.
 func (uv *userValidator) Update(user *User) error {
 	err := runUserValFns(user,
 		uv.passwordMinLength,
 		uv.bcryptPassword,
 		uv.passwordHashRequired,
-		uv.rememberMinBytes,
-		uv.hmacRemember,
-		uv.rememberHashRequired,
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
 		uv.emailIsAvail,
+		uv.normalizeLocale,
 	)
 	if err != nil {
 		return err
@@ -361,26 +424,6 @@ func (uv *userValidator) bcryptPassword(user *User) error {
 	return nil
 }
 
-func (uv *userValidator) setRememberIfUnset(user *User) error {
-	if user.Remember != "" {
-		return nil
-	}
-	token, err := rand.RememberToken()
-	if err != nil {
-		return err
-	}
-	user.Remember = token
-	return nil
-}
-
-func (uv *userValidator) hmacRemember(user *User) error {
-	if user.Remember == "" {
-		return nil
-	}
-	user.RememberHash = uv.hmac.Hash(user.Remember)
-	return nil
-}
-
 func (uv *userValidator) idGreaterThan(n uint) userValFn {
 	return userValFn(func(user *User) error {
 		if user.ID <= n {
@@ -396,6 +439,16 @@ func (uv *userValidator) normalizeEmail(user *User) error {
 	return nil
 }
 
+// normalizeLocale falls back to the default locale for users
+// who haven't picked one.
+func (uv *userValidator) normalizeLocale(user *User) error {
+	user.Locale = strings.ToLower(strings.TrimSpace(user.Locale))
+	if user.Locale == "" {
+		user.Locale = uv.defaultLocale
+	}
+	return nil
+}
+
 func (uv *userValidator) requireEmail(user *User) error {
 	if user.Email == "" {
 		return ErrEmailRequired
@@ -460,27 +513,6 @@ func (uv *userValidator) passwordHashRequired(user *User) error {
 	return nil
 }
 
-func (uv *userValidator) rememberMinBytes(user *User) error {
-	if user.Remember == "" {
-		return nil
-	}
-	n, err := rand.NBytes(user.Remember)
-	if err != nil {
-		return err
-	}
-	if n < 32 {
-		return ErrRememberTooShort
-	}
-	return nil
-}
-
-func (uv *userValidator) rememberHashRequired(user *User) error {
-	if user.RememberHash == "" {
-		return ErrRememberRequired
-	}
-	return nil
-}
-
 type modelError string
 
 func (e modelError) Error() string {
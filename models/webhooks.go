@@ -0,0 +1,464 @@
+package models
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/sirodoht/heartfort/rand"
+	"github.com/sirodoht/heartfort/webhook"
+)
+
+const (
+	ErrURLRequired modelError = "models: URL is required"
+
+	ErrURLInvalid modelError = "models: URL must start with http:// or https://"
+
+	ErrURLPrivate modelError = "models: URL must be on the public internet, not this server or a private network"
+
+	ErrURLHostUnknown modelError = "models: URL's host could not be found"
+
+	ErrEventsRequired modelError = "models: pick at least one event"
+
+	ErrEventInvalid modelError = "models: event is not one that can be subscribed to"
+)
+
+// A delivery is pending until the webhook takes it, and failed
+// once every attempt to deliver it has. Failed deliveries stay
+// until someone redelivers them.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// webhookSecretBytes is how much randomness goes into a
+// webhook's secret.
+const webhookSecretBytes = 24
+
+// Webhook represents the webhooks table in our DB and is a URL
+// that the household's events are posted to. Events is the
+// comma separated list of events it is subscribed to and Secret
+// is the key the deliveries are signed with.
+type Webhook struct {
+	ID          uint   `gorm:"primary_key"`
+	HouseholdID uint   `gorm:"not null;index"`
+	URL         string `gorm:"not null"`
+	Secret      string `gorm:"not null"`
+	Events      string `gorm:"not null"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// EventList returns the events the webhook is subscribed to.
+func (wh *Webhook) EventList() []string {
+	if wh.Events == "" {
+		return nil
+	}
+	return strings.Split(wh.Events, ",")
+}
+
+// Wants reports whether the webhook is subscribed to event.
+func (wh *Webhook) Wants(event string) bool {
+	for _, e := range wh.EventList() {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery represents the webhook_deliveries table in
+// our DB and is one event posted, or waiting to be posted, to a
+// webhook. ResponseStatus is what the webhook last answered
+// with, or 0 if it couldn't be reached.
+type WebhookDelivery struct {
+	ID             uint      `gorm:"primary_key"`
+	WebhookID      uint      `gorm:"not null;index"`
+	Event          string    `gorm:"not null"`
+	Body           string    `gorm:"type:text;not null"`
+	Status         string    `gorm:"not null;default:'pending';index:idx_webhook_deliveries_status_next"`
+	Attempts       int       `gorm:"not null;default:0"`
+	NextAttemptAt  time.Time `gorm:"not null;index:idx_webhook_deliveries_status_next"`
+	ResponseStatus int       `gorm:"not null;default:0"`
+	LastError      string    `gorm:"type:text"`
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func NewWebhookService(db *gorm.DB) WebhookService {
+	return &webhookService{
+		WebhookDB: &webhookValidator{
+			WebhookDB: &webhookGorm{db},
+		},
+	}
+}
+
+// WebhookService manages webhooks and is the store behind the
+// webhook dispatcher.
+type WebhookService interface {
+	webhook.Store
+	WebhookDB
+}
+
+var _ WebhookService = &webhookService{}
+
+type webhookService struct {
+	WebhookDB
+}
+
+func (whs *webhookService) Enqueue(householdID uint, event string, body []byte) error {
+	webhooks, err := whs.ByHouseholdID(householdID)
+	if err != nil {
+		return err
+	}
+	for _, wh := range webhooks {
+		if !wh.Wants(event) {
+			continue
+		}
+		err := whs.CreateDelivery(&WebhookDelivery{
+			WebhookID:     wh.ID,
+			Event:         event,
+			Body:          string(body),
+			Status:        DeliveryPending,
+			NextAttemptAt: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (whs *webhookService) Claim(now time.Time, lease time.Duration, limit int) ([]webhook.Delivery, error) {
+	claimed, err := whs.ClaimDue(now, lease, limit)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]webhook.Delivery, 0, len(claimed))
+	for _, d := range claimed {
+		wh, err := whs.ByID(d.WebhookID)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, webhook.Delivery{
+			ID:       d.ID,
+			Attempts: d.Attempts,
+			URL:      wh.URL,
+			Secret:   wh.Secret,
+			Event:    d.Event,
+			Body:     []byte(d.Body),
+		})
+	}
+	return deliveries, nil
+}
+
+// WebhookDB is used to interact with the webhooks and
+// webhook_deliveries databases.
+type WebhookDB interface {
+	ByID(id uint) (*Webhook, error)
+	ByHouseholdID(householdID uint) ([]Webhook, error)
+	Create(wh *Webhook) error
+	Update(wh *Webhook) error
+	// Delete deletes the webhook along with its deliveries.
+	Delete(id uint) error
+
+	DeliveryByID(id uint) (*WebhookDelivery, error)
+	// Deliveries returns up to limit of the webhook's latest
+	// deliveries, with the latest first.
+	Deliveries(webhookID uint, limit int) ([]WebhookDelivery, error)
+	CreateDelivery(delivery *WebhookDelivery) error
+	// ClaimDue counts an attempt against up to limit pending
+	// deliveries that are due by now and pushes their next
+	// attempt back by lease so no other worker claims them
+	// meanwhile.
+	ClaimDue(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error)
+	Delivered(id uint, status int) error
+	Retry(id uint, status int, reason string, at time.Time) error
+	Fail(id uint, status int, reason string) error
+	// Redeliver queues a delivery to be posted again with a
+	// fresh set of attempts, whether it was delivered or not.
+	Redeliver(id uint) error
+}
+
+type webhookValidator struct {
+	WebhookDB
+}
+
+func (whv *webhookValidator) Create(wh *Webhook) error {
+	err := runWebhookValFns(wh,
+		whv.householdIDRequired,
+		whv.normalizeURL,
+		whv.urlValid,
+		whv.urlPublic,
+		whv.normalizeEvents,
+		whv.eventsValid,
+		whv.setSecretIfUnset,
+	)
+	if err != nil {
+		return err
+	}
+	return whv.WebhookDB.Create(wh)
+}
+
+func (whv *webhookValidator) Update(wh *Webhook) error {
+	err := runWebhookValFns(wh,
+		whv.householdIDRequired,
+		whv.normalizeURL,
+		whv.urlValid,
+		whv.urlPublic,
+		whv.normalizeEvents,
+		whv.eventsValid,
+		whv.setSecretIfUnset,
+	)
+	if err != nil {
+		return err
+	}
+	return whv.WebhookDB.Update(wh)
+}
+
+func (whv *webhookValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return whv.WebhookDB.Delete(id)
+}
+
+func (whv *webhookValidator) householdIDRequired(wh *Webhook) error {
+	if wh.HouseholdID <= 0 {
+		return ErrHouseholdIDRequired
+	}
+	return nil
+}
+
+func (whv *webhookValidator) normalizeURL(wh *Webhook) error {
+	wh.URL = strings.TrimSpace(wh.URL)
+	return nil
+}
+
+func (whv *webhookValidator) urlValid(wh *Webhook) error {
+	if wh.URL == "" {
+		return ErrURLRequired
+	}
+	u, err := url.Parse(wh.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrURLInvalid
+	}
+	return nil
+}
+
+// urlPublic makes sure the URL's host is somewhere public, since
+// the delivery log shows what it answered. The dispatcher checks
+// again when it connects, in case the host's address changes.
+func (whv *webhookValidator) urlPublic(wh *Webhook) error {
+	u, err := url.Parse(wh.URL)
+	if err != nil {
+		return ErrURLInvalid
+	}
+	ips := []net.IP{net.ParseIP(u.Hostname())}
+	if ips[0] == nil {
+		if ips, err = net.LookupIP(u.Hostname()); err != nil || len(ips) == 0 {
+			return ErrURLHostUnknown
+		}
+	}
+	for _, ip := range ips {
+		if !webhook.PublicIP(ip) {
+			return ErrURLPrivate
+		}
+	}
+	return nil
+}
+
+// normalizeEvents puts the events in the order of
+// webhook.Events without any repeats.
+func (whv *webhookValidator) normalizeEvents(wh *Webhook) error {
+	wanted := make(map[string]bool)
+	for _, event := range wh.EventList() {
+		event = strings.TrimSpace(event)
+		if event == "" {
+			continue
+		}
+		if !webhook.ValidEvent(event) {
+			return ErrEventInvalid
+		}
+		wanted[event] = true
+	}
+	var events []string
+	for _, event := range webhook.Events {
+		if wanted[event] {
+			events = append(events, event)
+		}
+	}
+	wh.Events = strings.Join(events, ",")
+	return nil
+}
+
+func (whv *webhookValidator) eventsValid(wh *Webhook) error {
+	if wh.Events == "" {
+		return ErrEventsRequired
+	}
+	return nil
+}
+
+func (whv *webhookValidator) setSecretIfUnset(wh *Webhook) error {
+	if wh.Secret != "" {
+		return nil
+	}
+	secret, err := rand.String(webhookSecretBytes)
+	if err != nil {
+		return err
+	}
+	wh.Secret = secret
+	return nil
+}
+
+type webhookValFn func(*Webhook) error
+
+func runWebhookValFns(wh *Webhook, fns ...webhookValFn) error {
+	for _, fn := range fns {
+		if err := fn(wh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ WebhookDB = &webhookGorm{}
+
+type webhookGorm struct {
+	db *gorm.DB
+}
+
+func (whg *webhookGorm) ByID(id uint) (*Webhook, error) {
+	var wh Webhook
+	err := first(whg.db.Where("id = ?", id), &wh)
+	if err != nil {
+		return nil, err
+	}
+	return &wh, nil
+}
+
+func (whg *webhookGorm) ByHouseholdID(householdID uint) ([]Webhook, error) {
+	var webhooks []Webhook
+	err := whg.db.Where("household_id = ?", householdID).
+		Order("id").
+		Find(&webhooks).Error
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (whg *webhookGorm) Create(wh *Webhook) error {
+	return whg.db.Create(wh).Error
+}
+
+func (whg *webhookGorm) Update(wh *Webhook) error {
+	return whg.db.Save(wh).Error
+}
+
+func (whg *webhookGorm) Delete(id uint) error {
+	return whg.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("webhook_id = ?", id).Delete(&WebhookDelivery{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&Webhook{ID: id}).Error
+	})
+}
+
+func (whg *webhookGorm) DeliveryByID(id uint) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := first(whg.db.Where("id = ?", id), &delivery)
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (whg *webhookGorm) Deliveries(webhookID uint, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := whg.db.Where("webhook_id = ?", webhookID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (whg *webhookGorm) CreateDelivery(delivery *WebhookDelivery) error {
+	return whg.db.Create(delivery).Error
+}
+
+func (whg *webhookGorm) ClaimDue(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	// Like the outbox, SKIP LOCKED lets workers claim different
+	// deliveries at the same time without waiting.
+	err := whg.db.Raw(`UPDATE webhook_deliveries
+		SET attempts = attempts + 1, next_attempt_at = ?, updated_at = ?
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = ? AND next_attempt_at <= ?
+			ORDER BY next_attempt_at, id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, now.Add(lease), now, DeliveryPending, now, limit).
+		Scan(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (whg *webhookGorm) Delivered(id uint, status int) error {
+	return whg.updateDelivery(id, map[string]interface{}{
+		"status":          DeliveryDelivered,
+		"response_status": status,
+		"delivered_at":    time.Now(),
+		"last_error":      "",
+	})
+}
+
+func (whg *webhookGorm) Retry(id uint, status int, reason string, at time.Time) error {
+	return whg.updateDelivery(id, map[string]interface{}{
+		"response_status": status,
+		"next_attempt_at": at,
+		"last_error":      reason,
+	})
+}
+
+func (whg *webhookGorm) Fail(id uint, status int, reason string) error {
+	return whg.updateDelivery(id, map[string]interface{}{
+		"status":          DeliveryFailed,
+		"response_status": status,
+		"last_error":      reason,
+	})
+}
+
+func (whg *webhookGorm) Redeliver(id uint) error {
+	db := whg.db.Model(&WebhookDelivery{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":          DeliveryPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (whg *webhookGorm) updateDelivery(id uint, fields map[string]interface{}) error {
+	return whg.db.Model(&WebhookDelivery{ID: id}).Updates(fields).Error
+}
@@ -0,0 +1,134 @@
+// Package ratelimit limits how often something can be done with
+// token buckets. Every key, like an IP address, has a bucket
+// that starts out full. Doing something takes a token out, and
+// tokens drip back in over time up to the bucket's size.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is a bucket that holds Burst tokens and gets a token
+// back every Every.
+type Limit struct {
+	Burst int
+	Every time.Duration
+}
+
+// PerWindow lets n things be done at once, and n more over each
+// window after that.
+func PerWindow(n int, window time.Duration) Limit {
+	if n <= 0 {
+		n = 1
+	}
+	return Limit{
+		Burst: n,
+		Every: window / time.Duration(n),
+	}
+}
+
+// Store keeps the buckets.
+type Store interface {
+	// Take takes a token from the bucket for key if there is
+	// one. If there isn't it returns how long until there will
+	// be.
+	Take(key string, limit Limit, now time.Time) (retryAfter time.Duration, ok bool, err error)
+}
+
+// Take works out a bucket that had tokens at last. It returns
+// the tokens left at now, after taking one if there was one,
+// and how long until there will be one if there wasn't. Stores
+// keep the tokens and last, and leave the sums to Take.
+func Take(tokens float64, last, now time.Time, limit Limit) (left float64, retryAfter time.Duration, ok bool) {
+	burst := float64(limit.Burst)
+	if limit.Every > 0 && now.After(last) {
+		tokens += float64(now.Sub(last)) / float64(limit.Every)
+	}
+	tokens = math.Min(tokens, burst)
+	if tokens >= 1 {
+		return tokens - 1, 0, true
+	}
+	if limit.Every <= 0 {
+		return tokens, 0, false
+	}
+	retryAfter = time.Duration(math.Ceil((1 - tokens) * float64(limit.Every)))
+	return tokens, retryAfter, false
+}
+
+// Limiter limits one thing, like logging in, by key.
+type Limiter struct {
+	store Store
+	name  string
+	limit Limit
+}
+
+// NewLimiter returns a limiter whose buckets are kept in store
+// under name, so limiters can share a store.
+func NewLimiter(store Store, name string, limit Limit) *Limiter {
+	return &Limiter{
+		store: store,
+		name:  name,
+		limit: limit,
+	}
+}
+
+// Allow reports whether key can do the thing now, using up one
+// of its tokens if so, or how long it has to wait if not.
+func (l *Limiter) Allow(key string) (retryAfter time.Duration, ok bool, err error) {
+	return l.store.Take(l.name+":"+key, l.limit, time.Now())
+}
+
+var _ Store = &Memory{}
+
+// pruneEvery is how often the memory store forgets buckets that
+// have filled up again, which are the same as no bucket at all.
+const pruneEvery = time.Minute
+
+// Memory keeps buckets in memory, so each server process limits
+// on its own and forgets everything when it restarts.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: map[string]*bucket{},
+	}
+}
+
+func (m *Memory) Take(key string, limit Limit, now time.Time) (time.Duration, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.pruned) > pruneEvery {
+		m.prune(now)
+	}
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		m.buckets[key] = b
+	}
+	left, retryAfter, ok := Take(b.tokens, b.last, now, limit)
+	b.tokens = left
+	b.last = now
+	b.limit = limit
+	return retryAfter, ok, nil
+}
+
+func (m *Memory) prune(now time.Time) {
+	for key, b := range m.buckets {
+		if left, _, _ := Take(b.tokens, b.last, now, b.limit); left >= float64(b.limit.Burst)-1 {
+			delete(m.buckets, key)
+		}
+	}
+	m.pruned = now
+}
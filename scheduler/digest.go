@@ -0,0 +1,105 @@
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sirodoht/heartfort/email"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+// KindDigest is the kind of notification recorded for the
+// weekly digest.
+const KindDigest = "digest"
+
+// DigestConfig is when the weekly digest goes out. Hour is in
+// UTC like the weeks of the rota.
+type DigestConfig struct {
+	Weekday time.Weekday
+	Hour    int
+}
+
+// Digest emails every confirmed mate of every household the
+// assignments of the coming week, once a week from the
+// configured weekday and hour onwards. Members who linked a
+// chat get it there too.
+func Digest(hs models.HouseholdService, as models.AssignmentService, ms models.MateService, ns models.NotificationService, emailer *email.Client, chatter Chat, cfg DigestConfig) Task {
+	return func(now time.Time) error {
+		now = now.UTC()
+		if now.Weekday() != cfg.Weekday || now.Hour() < cfg.Hour {
+			return nil
+		}
+		week := comingWeek(now)
+		households, err := hs.All()
+		if err != nil {
+			return err
+		}
+		for _, household := range households {
+			if err := sendDigest(household, week, hs, as, ms, ns, emailer, chatter); err != nil {
+				log.Println(err)
+			}
+		}
+		return nil
+	}
+}
+
+func sendDigest(household models.Household, week time.Time, hs models.HouseholdService, as models.AssignmentService, ms models.MateService, ns models.NotificationService, emailer *email.Client, chatter Chat) error {
+	assignments, err := as.ByWeek(household.ID, week)
+	if err != nil {
+		return err
+	}
+	if len(assignments) == 0 {
+		return nil
+	}
+	entries := make([]email.DigestEntry, 0, len(assignments))
+	for _, a := range assignments {
+		entries = append(entries, email.DigestEntry{
+			Job:      a.Job.Name,
+			Assignee: a.User.Name,
+		})
+	}
+	mates, err := ms.Confirmed(household.ID)
+	if err != nil {
+		return err
+	}
+	weekLabel := week.Format(views.DateLayout)
+	for _, mate := range mates {
+		mate := mate
+		key := fmt.Sprintf("mate:%d:%s", mate.ID, weekLabel)
+		err := notify(ns, KindDigest, key, mate.Email, func() error {
+			return emailer.Digest(emailer.DefaultLocale(), mate.Email, household.Name, weekLabel, entries, ms.UnsubscribeToken(&mate))
+		})
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	if chatter.Notifier == nil {
+		return nil
+	}
+	members, err := hs.Members(household.ID)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		key := fmt.Sprintf("user:%d:%d:%s", member.ID, household.ID, weekLabel)
+		err := chatter.notify(ns, KindDigest, key, member.ID, func(chatID string) error {
+			return chatter.Notifier.Digest(chatID, household.Name, weekLabel, entries)
+		})
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return nil
+}
+
+// comingWeek is the start of the week the digest sent at now is
+// about: this week on a Monday and next week on any other day.
+func comingWeek(now time.Time) time.Time {
+	week := models.WeekOf(now)
+	if now.Sub(week) < day {
+		return week
+	}
+	return week.AddDate(0, 0, 7)
+}
@@ -0,0 +1,15 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/sirodoht/heartfort/models"
+)
+
+// Outbox purges the emails that were sent or died longer than
+// retention ago, so the outbox doesn't grow forever.
+func Outbox(obs models.OutboxService, retention time.Duration) Task {
+	return func(now time.Time) error {
+		return obs.Purge(now.Add(-retention))
+	}
+}
@@ -0,0 +1,16 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/sirodoht/heartfort/models"
+)
+
+// RateLimits forgets the rate limits kept in the database that
+// nothing has been tried against for a window, whose buckets
+// have filled up again by now.
+func RateLimits(rls models.RateLimitService, window time.Duration) Task {
+	return func(now time.Time) error {
+		return rls.DeleteStale(now.Add(-window))
+	}
+}
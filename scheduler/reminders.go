@@ -0,0 +1,154 @@
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sirodoht/heartfort/chat"
+	"github.com/sirodoht/heartfort/email"
+	"github.com/sirodoht/heartfort/models"
+	"github.com/sirodoht/heartfort/views"
+)
+
+// Kinds of notifications recorded by the reminders task.
+const (
+	KindReminder = "reminder"
+	KindOverdue  = "overdue"
+)
+
+const day = 24 * time.Hour
+
+// RemindersConfig is when assignees are reminded of their
+// assignments and nagged about overdue ones.
+type RemindersConfig struct {
+	// DaysBefore is how many days before the end of its week
+	// an unfinished assignment is reminded of.
+	DaysBefore int
+	// NagEvery is how many days apart the nags about an
+	// overdue assignment are.
+	NagEvery int
+	// MaxNags is how many times an assignee is nagged about one
+	// assignment before we give up.
+	MaxNags int
+}
+
+// Reminders emails each assignee once when the end of the week
+// of an unfinished assignment is close, and then every few days
+// once it is overdue, up to a limit. Assignees who linked a chat
+// get the same messages there.
+func Reminders(as models.AssignmentService, ns models.NotificationService, emailer *email.Client, chatter Chat, cfg RemindersConfig) Task {
+	if cfg.NagEvery < 1 {
+		cfg.NagEvery = 1
+	}
+	return func(now time.Time) error {
+		weekAgo := now.Add(-7 * day)
+		upcoming, err := as.Unfinished(weekAgo, weekAgo.Add(time.Duration(cfg.DaysBefore)*day))
+		if err != nil {
+			return err
+		}
+		for _, a := range upcoming {
+			a := a
+			dueDate := a.WeekStart.AddDate(0, 0, 6).Format(views.DateLayout)
+			key := fmt.Sprintf("assignment:%d", a.ID)
+			err := notify(ns, KindReminder, key, a.User.Email, func() error {
+				return emailer.Reminder(a.User.Locale, a.User.Name, a.User.Email, a.Job.Name, dueDate, a.ID)
+			})
+			if err != nil {
+				log.Println(err)
+			}
+			err = chatter.notify(ns, KindReminder, key, a.UserID, func(chatID string) error {
+				return chatter.Notifier.Reminder(chatID, a.User.Name, a.Job.Name, dueDate, a.ID)
+			})
+			if err != nil {
+				log.Println(err)
+			}
+		}
+
+		nagFor := time.Duration(cfg.NagEvery*cfg.MaxNags) * day
+		overdue, err := as.Unfinished(weekAgo.Add(-nagFor), weekAgo)
+		if err != nil {
+			return err
+		}
+		for _, a := range overdue {
+			a := a
+			weekEnd := a.WeekStart.AddDate(0, 0, 7)
+			nag := int(now.Sub(weekEnd) / (time.Duration(cfg.NagEvery) * day))
+			if nag >= cfg.MaxNags {
+				continue
+			}
+			week := a.WeekStart.Format(views.DateLayout)
+			key := fmt.Sprintf("assignment:%d:%d", a.ID, nag)
+			err := notify(ns, KindOverdue, key, a.User.Email, func() error {
+				return emailer.Overdue(a.User.Locale, a.User.Name, a.User.Email, a.Job.Name, week, a.ID)
+			})
+			if err != nil {
+				log.Println(err)
+			}
+			err = chatter.notify(ns, KindOverdue, key, a.UserID, func(chatID string) error {
+				return chatter.Notifier.Overdue(chatID, a.User.Name, a.Job.Name, week, a.ID)
+			})
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		return nil
+	}
+}
+
+// notify claims the notification and sends it. If sending
+// fails the claim is released so the next tick tries again.
+func notify(ns models.NotificationService, kind, key, to string, send func() error) error {
+	notification := models.Notification{
+		Kind:  kind,
+		Key:   key,
+		Email: to,
+	}
+	switch err := ns.Claim(&notification); err {
+	case nil:
+	case models.ErrAlreadySent:
+		return nil
+	default:
+		return err
+	}
+	if err := send(); err != nil {
+		if err := ns.Release(&notification); err != nil {
+			log.Println(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// chatKindPrefix goes before the kind of the notifications sent
+// to chats, so sending one doesn't count as sending the email
+// and the other way around.
+const chatKindPrefix = "chat_"
+
+// Chat is where notifications go besides email. With no
+// Notifier they only go by email.
+type Chat struct {
+	Notifier chat.Notifier
+	Links    models.ChatLinkService
+}
+
+// notify is the package notify for the chat the user linked, if
+// there is a bot and they linked one.
+func (c Chat) notify(ns models.NotificationService, kind, key string, userID uint, send func(chatID string) error) error {
+	if c.Notifier == nil {
+		return nil
+	}
+	link, err := c.Links.ByUserID(userID)
+	switch {
+	case err == models.ErrNotFound:
+		return nil
+	case err != nil:
+		return err
+	case !link.Linked():
+		return nil
+	}
+	chatID := *link.ChatID
+	return notify(ns, chatKindPrefix+kind, key, chatID, func() error {
+		return send(chatID)
+	})
+}
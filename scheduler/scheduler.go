@@ -0,0 +1,83 @@
+// Package scheduler runs tasks in the background of the server
+// process, such as emailing reminders about assignments.
+package scheduler
+
+import (
+	"log"
+	"time"
+)
+
+// Task is run on every tick of the scheduler with the time of
+// the tick. Tasks must be safe to run again after a restart,
+// which is why the ones that send emails record what they sent.
+type Task func(now time.Time) error
+
+// New returns a scheduler that runs the tasks every interval
+// once started. An interval of zero or less never runs them.
+func New(interval time.Duration, tasks ...Task) *Scheduler {
+	return &Scheduler{
+		interval: interval,
+		tasks:    tasks,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+type Scheduler struct {
+	interval time.Duration
+	tasks    []Task
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+// Start runs every task straight away and then once every
+// interval in the background until Stop is called.
+func (s *Scheduler) Start() {
+	if s.interval <= 0 {
+		close(s.done)
+		return
+	}
+	go s.run()
+}
+
+// Stop waits for any tasks that are running to finish and
+// stops the scheduler.
+func (s *Scheduler) Stop() {
+	close(s.quit)
+	<-s.done
+}
+
+func (s *Scheduler) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	s.tick(time.Now())
+	for {
+		select {
+		case now := <-ticker.C:
+			s.tick(now)
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+func (s *Scheduler) tick(now time.Time) {
+	for _, task := range s.tasks {
+		runTask(task, now)
+	}
+}
+
+// runTask runs the task, logging rather than returning its
+// errors and panics so that one bad task can't take the server
+// or the other tasks down with it.
+func runTask(task Task, now time.Time) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("scheduler: task panicked:", r)
+		}
+	}()
+	if err := task(now); err != nil {
+		log.Println("scheduler:", err)
+	}
+}
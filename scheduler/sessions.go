@@ -0,0 +1,15 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/sirodoht/heartfort/models"
+)
+
+// Sessions forgets the sessions that have expired, which can't
+// log anyone in anymore.
+func Sessions(ss models.SessionService) Task {
+	return func(now time.Time) error {
+		return ss.DeleteExpired(now)
+	}
+}
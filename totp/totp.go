@@ -0,0 +1,115 @@
+// Package totp makes and checks the time-based one-time
+// passwords of RFC 6238 that authenticator apps show, with the
+// defaults every app supports: SHA-1, 6 digits and 30 seconds.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirodoht/heartfort/rand"
+)
+
+const (
+	// Digits is how long a code is.
+	Digits = 6
+	// Period is how long each code lasts.
+	Period = 30 * time.Second
+	// modulo is 10 to the power of Digits.
+	modulo = 1000000
+	// secretBytes is the 160 bits RFC 4226 recommends.
+	secretBytes = 20
+	// skew is how many periods either side of now a code is
+	// still accepted for, for clocks that are a little off.
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a random secret in the base32 apps expect
+// it to be typed in as.
+func NewSecret() (string, error) {
+	b, err := rand.Bytes(secretBytes)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Step is the number of the period t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code for the secret at t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decode(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, Step(t)), nil
+}
+
+// Validate checks the code against the secret at t and returns
+// the step it is for. A code is only good once, so callers must
+// turn it down if they have already seen its step or a later
+// one.
+func Validate(secret, c string, t time.Time) (step int64, ok bool) {
+	key, err := decode(secret)
+	if err != nil {
+		return 0, false
+	}
+	c = strings.Replace(c, " ", "", -1)
+	if len(c) != Digits {
+		return 0, false
+	}
+	now := Step(t)
+	for s := now - skew; s <= now+skew; s++ {
+		if subtle.ConstantTimeCompare([]byte(code(key, s)), []byte(c)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// URI is the otpauth:// link apps read from a QR code to set
+// themselves up, with the account shown under the issuer.
+func URI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(int(Period/time.Second)))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+func decode(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	return encoding.DecodeString(strings.TrimRight(secret, "="))
+}
+
+// code is the HOTP of RFC 4226 for the counter step.
+func code(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, n%modulo)
+}
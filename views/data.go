@@ -22,9 +22,10 @@ const (
 // Data is the top level structure that views expect data
 // to come in.
 type Data struct {
-	Alert *Alert
-	User  *models.User
-	Yield interface{}
+	Alert     *Alert
+	User      *models.User
+	Household *models.Household
+	Yield     interface{}
 }
 
 func (d *Data) AlertError(msg string) {
@@ -0,0 +1,44 @@
+package views
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// ErrorBody is what every API error looks like, wrapped in an
+// "error" object so clients can tell errors apart from data.
+type ErrorBody struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// RenderJSON writes v out as the JSON body of the response.
+func RenderJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+// RenderJSONError writes err out as an API error. Like SetAlert
+// only the public message of our own errors is shown, anything
+// else is logged and given the generic message instead.
+func RenderJSONError(w http.ResponseWriter, status int, err error) {
+	var msg string
+	if pErr, ok := err.(PublicError); ok {
+		msg = pErr.Public()
+	} else {
+		log.Println(err)
+		msg = AlertMsgGeneric
+	}
+	RenderJSONMessage(w, status, msg)
+}
+
+// RenderJSONMessage writes an API error with the given message.
+func RenderJSONMessage(w http.ResponseWriter, status int, msg string) {
+	RenderJSON(w, status, struct {
+		Error ErrorBody `json:"error"`
+	}{ErrorBody{Status: status, Message: msg}})
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"time"
 
 	"github.com/gorilla/csrf"
 
@@ -20,6 +21,10 @@ var (
 	TemplateExt string = ".html"
 )
 
+// DateLayout is how dates are written in templates and how
+// they are expected back from date inputs in forms.
+const DateLayout = "2006-01-02"
+
 func NewView(layout string, files ...string) *View {
 	addTemplatePath(files)
 	addTemplateExt(files)
@@ -31,6 +36,20 @@ func NewView(layout string, files ...string) *View {
 		"pathEscape": func(s string) string {
 			return url.PathEscape(s)
 		},
+		"date": func(t *time.Time) string {
+			if t == nil {
+				return ""
+			}
+			return t.Format(DateLayout)
+		},
+		"hasString": func(list []string, s string) bool {
+			for _, l := range list {
+				if l == s {
+					return true
+				}
+			}
+			return false
+		},
 	}).ParseFiles(files...)
 	if err != nil {
 		panic(err)
@@ -48,6 +67,12 @@ type View struct {
 }
 
 func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{}) {
+	v.RenderStatus(w, r, http.StatusOK, data)
+}
+
+// RenderStatus renders the view like Render but responds with
+// the given status code, eg http.StatusForbidden.
+func (v *View) RenderStatus(w http.ResponseWriter, r *http.Request, status int, data interface{}) {
 	w.Header().Set("Content-Type", "text/html")
 	var vd Data
 	switch d := data.(type) {
@@ -63,6 +88,7 @@ func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{})
 		clearAlert(w)
 	}
 	vd.User = context.User(r.Context())
+	vd.Household = context.Household(r.Context())
 	var buf bytes.Buffer
 	csrfField := csrf.TemplateField(r)
 	tpl := v.Template.Funcs(template.FuncMap{
@@ -75,6 +101,7 @@ func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{})
 		http.Error(w, "Something went wrong in rendering a template.", http.StatusInternalServerError)
 		return
 	}
+	w.WriteHeader(status)
 	io.Copy(w, &buf)
 }
 
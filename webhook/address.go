@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// ErrPrivateAddress is why a delivery to the server itself or a
+// private network fails. Webhooks are set up by anyone running
+// a household, and the delivery log shows what they answered, so
+// they mustn't reach anything that isn't public.
+var ErrPrivateAddress = errors.New("webhook: address is not public")
+
+// privateNets are the networks that aren't on the public
+// internet, on top of the loopback, link-local and unspecified
+// addresses net.IP knows about.
+var privateNets = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"fc00::/7",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// PublicIP reports whether deliveries can be posted to ip.
+func PublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublic is a net.Dialer Control that refuses to connect to
+// addresses that aren't public. It runs on the address the host
+// name resolved to, just before connecting, so a name that
+// resolved to somewhere public when the webhook was saved can't
+// be pointed somewhere private afterwards.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !PublicIP(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirodoht/heartfort/rand"
+)
+
+const (
+	// deliveryLease is how long a claimed delivery is left
+	// alone by other workers. A worker that dies mid-post leaves
+	// it to be picked up again once the lease runs out.
+	deliveryLease = 5 * time.Minute
+	// maxBackoff caps the wait between attempts.
+	maxBackoff = 24 * time.Hour
+	// maxReason is how much of a failed response is kept.
+	maxReason = 512
+)
+
+// Delivery is an event waiting to be posted to a webhook.
+// Attempts counts every time it was claimed for delivery,
+// including this one.
+type Delivery struct {
+	ID       uint
+	Attempts int
+	URL      string
+	Secret   string
+	Event    string
+	Body     []byte
+}
+
+// Store is where the dispatcher keeps deliveries between the
+// event happening and it being delivered, so they survive
+// restarts. Status is the HTTP status the webhook answered
+// with, or 0 if it couldn't be reached.
+type Store interface {
+	// Enqueue queues the event for every webhook of the
+	// household subscribed to it.
+	Enqueue(householdID uint, event string, body []byte) error
+	// Claim takes up to limit deliveries that are due by now and
+	// hides them from other claims for the length of the lease.
+	Claim(now time.Time, lease time.Duration, limit int) ([]Delivery, error)
+	Delivered(id uint, status int) error
+	// Retry records why delivery failed and when to try again.
+	Retry(id uint, status int, reason string, at time.Time) error
+	// Fail records why delivery failed and gives up on it.
+	Fail(id uint, status int, reason string) error
+}
+
+// Config is how hard the dispatcher tries. Workers is how many
+// deliveries are posted at once, each waiting at most Timeout
+// for an answer. The first retry waits Backoff and every one
+// after that twice as long as the last, up to MaxAttempts in
+// total. Poll is how often the workers look for deliveries that
+// are due a retry.
+type Config struct {
+	Workers     int
+	MaxAttempts int
+	Backoff     time.Duration
+	Poll        time.Duration
+	Timeout     time.Duration
+}
+
+// Dispatcher queues events in a store and has a pool of
+// workers post them in the background, so nothing waits on, or
+// fails because of, someone else's server.
+type Dispatcher struct {
+	store  Store
+	client *http.Client
+	cfg    Config
+	wake   chan struct{}
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewDispatcher(store Store, cfg Config) *Dispatcher {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	if cfg.Poll <= 0 {
+		cfg.Poll = time.Minute
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	// Deliveries go straight to the webhook, never through a
+	// proxy, so that the dialer checks the webhook's own address.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout: cfg.Timeout,
+		Control: dialPublic,
+	}).DialContext
+	return &Dispatcher{
+		store: store,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+			// A redirect is an answer like any other, following
+			// it would post the event somewhere nobody set up.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		cfg:  cfg,
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+	}
+}
+
+// Emit queues the event with data as its payload for the
+// household's webhooks and wakes a worker up to deliver it.
+func (d *Dispatcher) Emit(householdID uint, event string, data interface{}) error {
+	id, err := rand.String(16)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(Payload{
+		ID:          id,
+		Event:       event,
+		HouseholdID: householdID,
+		CreatedAt:   time.Now().UTC(),
+		Data:        data,
+	})
+	if err != nil {
+		return err
+	}
+	if err := d.store.Enqueue(householdID, event, body); err != nil {
+		return err
+	}
+	d.Wake()
+	return nil
+}
+
+// Wake gets a worker to look for due deliveries now rather than
+// at the next poll, say after one was queued to go again.
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the workers in the background until Stop is
+// called.
+func (d *Dispatcher) Start() {
+	for i := 0; i < d.cfg.Workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+}
+
+// Stop waits for the workers to finish what they are posting
+// and stops them.
+func (d *Dispatcher) Stop() {
+	close(d.stop)
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.cfg.Poll)
+	defer ticker.Stop()
+	for {
+		for d.deliverNext() {
+			select {
+			case <-d.stop:
+				return
+			default:
+			}
+		}
+		select {
+		case <-d.stop:
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverNext delivers one due delivery and reports whether
+// there was one.
+func (d *Dispatcher) deliverNext() bool {
+	claimed, err := d.store.Claim(time.Now(), deliveryLease, 1)
+	if err != nil {
+		log.Println("webhook:", err)
+		return false
+	}
+	if len(claimed) == 0 {
+		return false
+	}
+	d.deliver(claimed[0])
+	return true
+}
+
+func (d *Dispatcher) deliver(delivery Delivery) {
+	status, err := d.post(delivery)
+	if err == nil {
+		if err := d.store.Delivered(delivery.ID, status); err != nil {
+			log.Println("webhook:", err)
+		}
+		return
+	}
+	log.Printf("webhook: delivery %d, attempt %d: %v", delivery.ID, delivery.Attempts, err)
+	if delivery.Attempts >= d.cfg.MaxAttempts {
+		err = d.store.Fail(delivery.ID, status, err.Error())
+	} else {
+		err = d.store.Retry(delivery.ID, status, err.Error(), time.Now().Add(d.backoff(delivery.Attempts)))
+	}
+	if err != nil {
+		log.Println("webhook:", err)
+	}
+}
+
+// post sends the delivery and returns the status it was
+// answered with. Anything but a 2xx is an error.
+func (d *Dispatcher) post(delivery Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Heartfort-Webhooks")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(int(delivery.ID)))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, delivery.Body))
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		io.Copy(ioutil.Discard, res.Body)
+		return res.StatusCode, nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxReason))
+	return res.StatusCode, fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(body))
+}
+
+// backoff is how long to wait after the given failed attempt.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.cfg.Backoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
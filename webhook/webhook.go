@@ -0,0 +1,64 @@
+// Package webhook posts events that happen in a household to
+// the URLs its admins subscribed, signed so the receiver can
+// tell they came from us.
+package webhook
+
+import (
+	"time"
+
+	"github.com/sirodoht/heartfort/hash"
+)
+
+// The events that can be subscribed to.
+const (
+	EventAssignmentCreated   = "assignment.created"
+	EventAssignmentCompleted = "assignment.completed"
+	EventAssignmentSwapped   = "assignment.swapped"
+	EventJobUpdated          = "job.updated"
+	EventMateSubscribed      = "mate.subscribed"
+)
+
+// Events lists every event in the order they are shown.
+var Events = []string{
+	EventAssignmentCreated,
+	EventAssignmentCompleted,
+	EventAssignmentSwapped,
+	EventJobUpdated,
+	EventMateSubscribed,
+}
+
+// The headers sent along with every delivery. SignatureHeader
+// holds "sha256=" followed by the base64 URL encoded
+// HMAC-SHA256 of the body, keyed with the webhook's secret.
+const (
+	EventHeader     = "X-Heartfort-Event"
+	DeliveryHeader  = "X-Heartfort-Delivery"
+	SignatureHeader = "X-Heartfort-Signature"
+)
+
+// Payload is the JSON body of every delivery. ID is the same
+// for every delivery of the event, including redeliveries, so
+// receivers can ignore ones they have already seen.
+type Payload struct {
+	ID          string      `json:"id"`
+	Event       string      `json:"event"`
+	HouseholdID uint        `json:"household_id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Data        interface{} `json:"data"`
+}
+
+// Sign returns the value of the signature header for the body.
+func Sign(secret string, body []byte) string {
+	return "sha256=" + hash.NewHMAC(secret).Hash(string(body))
+}
+
+// ValidEvent reports whether event is one that can be
+// subscribed to.
+func ValidEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}